@@ -3,17 +3,89 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/luismi/jp2_processing/config"
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/geotiff"
 	"github.com/luismi/jp2_processing/pkg/jp2"
 	"github.com/luismi/jp2_processing/pkg/jp2/cpu"
 	"github.com/luismi/jp2_processing/pkg/jp2/gpu"
+	"github.com/luismi/jp2_processing/pkg/kmz"
+	"github.com/luismi/jp2_processing/pkg/legend"
 	"github.com/luismi/jp2_processing/pkg/metrics"
 	"github.com/luismi/jp2_processing/pkg/ndvi"
+	"github.com/luismi/jp2_processing/pkg/output"
+	"github.com/luismi/jp2_processing/pkg/rawio"
+	"github.com/luismi/jp2_processing/pkg/tiles"
 	"github.com/luismi/jp2_processing/pkg/utils"
+	"github.com/luismi/jp2_processing/pkg/vector"
+	"github.com/luismi/jp2_processing/pkg/zarr"
+)
+
+// Compositing and data cube configuration parsed from the flags
+var (
+	compositeScenes []ndvi.ScenePair
+	compositeMode   ndvi.CompositeMethod
+	cubeScenes      []ndvi.ScenePair
+	seriesPoints    []image.Point
+	baselineScenes  []ndvi.ScenePair
+	stretchOpts     ndvi.StretchOptions
+)
+
+// Colormaps resolved from the flags
+var (
+	ndviCmap    *config.Colormap
+	changeCmap  *config.Colormap
+	anomalyCmap *config.Colormap
+	ndviLUT     *config.ColorLUT
+	paletteLUT  *config.ColorLUT
+	classScheme *config.ClassScheme
+)
+
+// Legends rendered for each colorization mode
+var (
+	legends      = make(map[string]*image.RGBA)
+	legendCorner legend.Corner
+	legendMargin int
+	legendOpts   legend.Options
+)
+
+// GeoTIFF and Zarr outputs configured from the flags, georeferenced like the input bands
+var (
+	inputGeoref *geo.Georeference
+	gtiffWriter *geotiff.Writer
+	zarrWriter  *zarr.Writer
+)
+
+// Raw array formats parsed from the flags
+var rawArrayFormats []string
+
+// JPEG2000 encode options parsed from the flags, shared by the CPU and GPU writers
+var encodeOpts jp2.EncodeOptions
+
+// Experimental CPU writer encoding JPEG2000 tiles in parallel, for the jp2tile format
+var parallelWriter *cpu.ParallelWriter
+
+// Map output naming from the flags; resolvedOutputs maps each configuration and expanded
+// path of this run to the path chosen by the overwrite policy, so later iterations of the
+// configuration reuse it, and outputOwners maps the chosen paths to their configuration
+var (
+	outputPolicy    output.Policy
+	sceneTile       string
+	sceneDate       string
+	resolvedOutputs = make(map[string]string)
+	outputOwners    = make(map[string]string)
 )
 
 // Update the threads parameter to accept a list of configurations
@@ -25,6 +97,91 @@ var (
 	redFile    = flag.String("red", "", "Path to RED band JP2 file")
 	threads    = flag.String("threads", "2,4,8,12,16", "Comma-separated list of thread configurations to use for CPU processing")
 	iterations = flag.Int("iter", 1, "Number of iterations to run")
+	colormap   = flag.String("colormap", "ndvi", "Colormap name ("+strings.Join(config.BuiltinColormapNames(), ", ")+") or GDAL color-relief, QGIS XML or GMT .cpt file")
+
+	// Colormaps for the diverging change and anomaly maps
+	changeColormap  = flag.String("change-colormap", "change", "Colormap name or file for NDVI change maps, stretched over [-1, 1]")
+	anomalyColormap = flag.String("anomaly-colormap", "anomaly", "Colormap name or file for NDVI anomaly maps, stretched over z-scores [-3, 3]")
+	ndviOut         = flag.String("ndvi-out", "", "JP2 file for the NDVI values quantized to int16 (value*10000, -32768 for no data), read back to check the round trip (CPU only), GeoTIFF file (.tif) or Zarr v2 store directory (.zarr) of -ndvi-type values")
+	saveModes       = flag.String("save-mode", "rgba", "Comma-separated list of save modes to benchmark: rgba (4 components), paletted (8-bit indices with a JP2 palette, CPU only) and/or tiled (colorized and encoded tile by tile on demand, CPU JP2 only)")
+	paletteSize     = flag.Int("palette-size", 256, "Number of palette entries sampled from the colormap for the paletted save mode (at most 256)")
+	jp2Mode         = flag.String("jp2-mode", "lossless", "JPEG2000 encoding: lossless (reversible 5/3 wavelet) or lossy (irreversible 9/7 wavelet)")
+	jp2Rates        = flag.String("jp2-rates", "", "Comma-separated compression ratios of the JPEG2000 quality layers, decreasing; in lossless mode, of the layers before the lossless one")
+	jp2PSNR         = flag.String("jp2-psnr", "", "Comma-separated target PSNR in dB of the JPEG2000 quality layers, increasing (lossy mode only)")
+	jp2Layers       = flag.Int("jp2-layers", 0, "Number of JPEG2000 quality layers (0 to derive it from -jp2-rates or -jp2-psnr)")
+	jp2Resolutions  = flag.Int("jp2-resolutions", 6, "Number of JPEG2000 resolution levels")
+	jp2Tile         = flag.Int("jp2-tile", 0, "JPEG2000 tile size in pixels (0 for a single tile)")
+	jp2Codeblock    = flag.Int("jp2-codeblock", 64, "JPEG2000 codeblock size, a power of two from 4 to 64")
+	jp2Precincts    = flag.String("jp2-precincts", "", "Comma-separated JPEG2000 precinct sizes from the highest resolution, powers of two (empty for one precinct per resolution)")
+	jp2Progression  = flag.String("jp2-progression", "LRCP", "JPEG2000 progression order: LRCP, RLCP, RPCL, PCRL or CPRL")
+	outputTemplate  = flag.String("output", "./go_jp2_direct/{index}_{backend}_{threads}_{mode}_{save}_{format}.{ext}", "Path template of the NDVI map of each benchmark configuration, with {tile}, {date}, {index}, {backend}, {threads}, {mode}, {save}, {format}, {iter} and {ext} placeholders")
+	overwrite       = flag.String("overwrite", "overwrite", "Policy for map outputs that exist before the run: overwrite, skip (the configuration is not run), fail or version (write name_1, name_2, ...). Another configuration of this run writing the same path is skipped or versioned, and stops the run with overwrite or fail")
+	formats         = flag.String("format", "jp2", "Comma-separated list of output formats of the NDVI map to benchmark: jp2, jp2tile (JP2 tiles encoded in parallel and assembled in Go, CPU only, experimental) and/or gtiff (tiled Cloud Optimized GeoTIFF)")
+	gtiffCompress   = flag.String("gtiff-compression", "deflate", "GeoTIFF tile compression: deflate, lzw or none")
+	gtiffTile       = flag.Int("gtiff-tile", 512, "GeoTIFF tile size in pixels (multiple of 16)")
+	gtiffOverviews  = flag.Bool("gtiff-overviews", true, "Add internal overviews to GeoTIFF outputs")
+	ndviType        = flag.String("ndvi-type", "int16", "Sample type of a GeoTIFF or Zarr NDVI product (-ndvi-out ending in .tif or .zarr): int16 (quantized) or float32")
+	zarrChunk       = flag.Int("zarr-chunk", 512, "Zarr chunk size in pixels")
+	zarrBands       = flag.Bool("zarr-bands", false, "Also write the decoded NIR and RED bands (float32) as arrays of a Zarr NDVI product")
+	colorModes      = flag.String("color-mode", "exact", "Comma-separated list of NDVI colorization modes to benchmark: exact (interpolation), lut (lookup table) and/or classes (discrete classes)")
+	lutSize         = flag.Int("lut-size", 4096, "Number of lookup table entries for the lut colorization mode")
+	classes         = flag.String("classes", "default", "Class scheme for the classes colorization mode: default (water, bare soil, sparse, moderate and dense vegetation) or a file of lower bound, color and label lines")
+	legendFile      = flag.String("legend", "", "PNG file for the legend of the NDVI map: a colorbar, or a class legend in the classes colorization mode")
+	legendTitle     = flag.String("legend-title", "NDVI", "Legend title")
+	legendUnits     = flag.String("legend-units", "", "Legend units, shown after the title in parentheses")
+	legendScale     = flag.Int("legend-scale", 1, "Integer magnification of the legend")
+	legendOverlay   = flag.String("legend-overlay", "", "Corner where the legend is composited onto the NDVI map: br, bl, tr or tl (empty for none)")
+	stretchMethod   = flag.String("stretch", "none", "Stretch applied to NDVI before color lookup: none, minmax, percentile or equalize (not used by the classes mode)")
+	stretchMin      = flag.Float64("stretch-min", -1, "NDVI value mapped to the start of the colormap by the minmax stretch")
+	stretchMax      = flag.Float64("stretch-max", 1, "NDVI value mapped to the end of the colormap by the minmax stretch")
+	stretchLow      = flag.Float64("stretch-low", 2, "Lower percentile of the percentile stretch")
+	stretchHigh     = flag.Float64("stretch-high", 98, "Upper percentile of the percentile stretch")
+	quicklookFile   = flag.String("quicklook", "", "PNG file for a quicklook of the NDVI map, downsampled by area averaging")
+	quicklookSize   = flag.Int("quicklook-size", 512, "Largest dimension of the quicklook in pixels")
+	tilesDir        = flag.String("tiles", "", "Directory for a Web Mercator z/x/y PNG tile pyramid of the NDVI map with an index.html viewer, or .pmtiles file for a single PMTiles archive (needs georeferencing)")
+	tilesMinZoom    = flag.Int("tiles-min-zoom", -1, "Lowest tile zoom (-1 for four levels below the highest)")
+	tilesMaxZoom    = flag.Int("tiles-max-zoom", -1, "Highest tile zoom (-1 for the native zoom of the input resolution)")
+	kmzFile         = flag.String("kmz", "", "KMZ file with the NDVI map reprojected to WGS84 as a Google Earth ground overlay, with the legend and NDVI statistics (needs georeferencing)")
+	kmzSize         = flag.Int("kmz-size", 4096, "Largest dimension of the KMZ overlay image in pixels")
+	rawOut          = flag.String("raw-out", "", "Directory for the NDVI values (float64, NaN for no data) and the decoded NIR and RED bands (float32) as raw arrays for notebooks")
+	rawFormats      = flag.String("raw-format", "npy", "Comma-separated list of raw array formats: npy (NumPy) and/or envi (ENVI .img with a .hdr header and map info)")
+	georefSpec      = flag.String("georef", "", "Georeferencing of the input bands as EPSG,originX,originY,pixelSize[,pixelHeight], overriding the one read from the NIR band")
+	polygonsFile    = flag.String("polygons", "", "GeoJSON file for the regions of the classes colorization mode traced into polygons with their class and mean NDVI (needs georeferencing)")
+	polygonsTol     = flag.Float64("polygons-tolerance", 1, "Douglas-Peucker simplification tolerance of the polygons in pixels (0 keeps every pixel corner)")
+	polygonsMinArea = flag.Float64("polygons-min-area", 0, "Minimum area of the polygons and their holes in square map units (square meters for projected CRSs)")
+	classRaster     = flag.String("class-raster", "", "PNG file for the single-band class raster of the classes colorization mode (class index plus one, 0 for no data)")
+
+	// Change detection flags
+	nir2File      = flag.String("nir2", "", "Path to NIR band JP2 file of a second date for change detection")
+	red2File      = flag.String("red2", "", "Path to RED band JP2 file of a second date for change detection")
+	lossThreshold = flag.Float64("loss", -0.1, "NDVI difference at or below which a pixel is classified as loss")
+	gainThreshold = flag.Float64("gain", 0.1, "NDVI difference at or above which a pixel is classified as gain")
+	pixelSize     = flag.Float64("pixel-size", 10, "Ground size of a pixel in meters, used for change and class areas")
+
+	// Compositing flags
+	compositeNIR    = flag.String("composite-nir", "", "Comma-separated list of NIR band JP2 files to composite, in date order")
+	compositeRED    = flag.String("composite-red", "", "Comma-separated list of RED band JP2 files to composite, in date order")
+	compositeMethod = flag.String("composite", "max", "Compositing method: max, median or latest")
+	compositeMemory = flag.Int("composite-memory", ndvi.DefaultMedianMemory>>20, "Memory budget in MiB of the scene values kept for median compositing; beyond it they are kept in a temporary file")
+
+	// Data cube flags
+	cubeNIR      = flag.String("cube-nir", "", "Comma-separated list of dated NIR band JP2 files for the temporal data cube")
+	cubeRED      = flag.String("cube-red", "", "Comma-separated list of dated RED band JP2 files for the temporal data cube")
+	smoothMethod = flag.String("smooth", "sg", "Data cube smoothing: none, ma (moving average) or sg (Savitzky-Golay)")
+	smoothWindow = flag.Int("smooth-window", 5, "Data cube smoothing window in dates (odd)")
+	sgOrder      = flag.Int("sg-order", 2, "Savitzky-Golay polynomial order")
+	seriesPixels = flag.String("series", "", "Semicolon-separated list of x,y pixels whose time series are exported")
+	seriesOut    = flag.String("series-out", "./go_jp2_direct/series.csv", "CSV file for the exported time series")
+
+	// Phenology flags
+	phenology  = flag.Bool("phenology", false, "Extract phenology metrics from the data cube")
+	phenoLayer = flag.String("pheno-layer", "sos", "Phenology layer to colorize and save: sos, eos, pos, length, peak or integral")
+	fieldsFile = flag.String("fields", "", "CSV file of id,x0,y0,x1,y1 field rectangles for per-field phenology summaries")
+
+	// Anomaly flags
+	baselineNIR = flag.String("baseline-nir", "", "Comma-separated list of historical NIR band JP2 files for the anomaly baseline")
+	baselineRED = flag.String("baseline-red", "", "Comma-separated list of historical RED band JP2 files for the anomaly baseline")
+	doyWindow   = flag.Int("doy-window", 15, "Days around the current day of year used for the baseline (0 uses every scene)")
 )
 
 func parseThreads(threadsFlag string) []int {
@@ -40,6 +197,47 @@ func parseThreads(threadsFlag string) []int {
 	return threadConfigs
 }
 
+// parseScenes pairs the comma-separated NIR and RED file lists given by the -<name>-nir and -<name>-red flags
+func parseScenes(name, nirFlag, redFlag string) []ndvi.ScenePair {
+	if nirFlag == "" && redFlag == "" {
+		return nil
+	}
+
+	nirFiles := strings.Split(nirFlag, ",")
+	redFiles := strings.Split(redFlag, ",")
+	if len(nirFiles) != len(redFiles) {
+		fmt.Printf("Error: -%s-nir and -%s-red lists differ in length: %d NIR, %d RED\n", name, name, len(nirFiles), len(redFiles))
+		os.Exit(1)
+	}
+
+	scenes := make([]ndvi.ScenePair, len(nirFiles))
+	for i := range nirFiles {
+		scenes[i] = ndvi.ScenePair{
+			NIR: strings.TrimSpace(nirFiles[i]),
+			RED: strings.TrimSpace(redFiles[i]),
+		}
+	}
+	return scenes
+}
+
+// parsePoints parses a semicolon-separated list of x,y pixel coordinates
+func parsePoints(pointsFlag string) []image.Point {
+	var points []image.Point
+	if pointsFlag == "" {
+		return points
+	}
+
+	for _, p := range strings.Split(pointsFlag, ";") {
+		var x, y int
+		if _, err := fmt.Sscanf(strings.TrimSpace(p), "%d,%d", &x, &y); err != nil {
+			fmt.Printf("Invalid pixel coordinates: %s\n", p)
+			os.Exit(1)
+		}
+		points = append(points, image.Pt(x, y))
+	}
+	return points
+}
+
 func main() {
 	// Parse command-line flags
 	flag.Parse()
@@ -47,6 +245,134 @@ func main() {
 	// Parse thread configurations
 	threadConfigs := parseThreads(*threads)
 
+	// Resolve colormaps
+	var err error
+	if ndviCmap, err = config.ResolveColormap(*colormap); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if changeCmap, err = config.ResolveColormap(*changeColormap); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	changeCmap = changeCmap.Rescale(-1, 1)
+	if anomalyCmap, err = config.ResolveColormap(*anomalyColormap); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	anomalyCmap = anomalyCmap.Rescale(-3, 3)
+
+	// Parse save modes and build the palette once for all runs
+	saves := parseSaveModes(*saveModes)
+	for _, save := range saves {
+		if save == "tiled" && *legendOverlay != "" {
+			fmt.Println("Error: tiled saving cannot composite the legend overlay")
+			os.Exit(1)
+		}
+		if save == "paletted" {
+			if *paletteSize > 256 {
+				fmt.Printf("Error: palette of %d entries does not fit 8-bit indices\n", *paletteSize)
+				os.Exit(1)
+			}
+			if paletteLUT, err = config.NewColorLUT(ndviCmap, *paletteSize); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Parse output formats and configure the GeoTIFF writer
+	outputFormats := parseFormats(*formats)
+	gtiffOpts := geotiff.DefaultOptions()
+	if gtiffOpts.Compression, err = geotiff.ParseCompression(*gtiffCompress); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	gtiffOpts.TileSize = *gtiffTile
+	gtiffOpts.Overviews = *gtiffOverviews
+	if gtiffWriter, err = geotiff.NewWriter(gtiffOpts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	zarrOpts := zarr.DefaultOptions()
+	zarrOpts.ChunkSize = *zarrChunk
+	if zarrWriter, err = zarr.NewWriter(zarrOpts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	rawArrayFormats = parseRawFormats(*rawFormats)
+	if encodeOpts, err = parseEncodeOptions(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if slices.Contains(outputFormats, "jp2tile") {
+		if parallelWriter, err = cpu.NewParallelWriter(encodeOpts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if outputPolicy, err = output.ParsePolicy(*overwrite); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	sceneTile, sceneDate = output.SceneFields(*nirFile)
+	if err := checkOutputTemplate(*outputTemplate); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *ndviType != "int16" && *ndviType != "float32" {
+		fmt.Printf("Error: unknown NDVI product type: %s\n", *ndviType)
+		os.Exit(1)
+	}
+
+	// Parse colorization modes and build the lookup table once for all runs
+	modes := parseColorModes(*colorModes)
+	for _, mode := range modes {
+		switch mode {
+		case "lut":
+			if ndviLUT, err = config.NewColorLUT(ndviCmap, *lutSize); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		case "classes":
+			if classScheme, err = config.ResolveClassScheme(*classes); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Parse compositing configuration
+	compositeScenes = parseScenes("composite", *compositeNIR, *compositeRED)
+	if compositeMode, err = ndvi.ParseCompositeMethod(*compositeMethod); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *compositeMemory < 1 {
+		fmt.Println("Error: composite memory must be at least 1 MiB")
+		os.Exit(1)
+	}
+
+	// Parse data cube configuration
+	cubeScenes = parseScenes("cube", *cubeNIR, *cubeRED)
+	if *smoothMethod != "none" && *smoothMethod != "ma" && *smoothMethod != "sg" {
+		fmt.Printf("Error: unknown smoothing method: %s\n", *smoothMethod)
+		os.Exit(1)
+	}
+	seriesPoints = parsePoints(*seriesPixels)
+
+	// Parse anomaly baseline configuration
+	baselineScenes = parseScenes("baseline", *baselineNIR, *baselineRED)
+
+	// Parse stretch configuration
+	stretchOpts = ndvi.DefaultStretchOptions()
+	if stretchOpts.Method, err = ndvi.ParseStretchMethod(*stretchMethod); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	stretchOpts.Min, stretchOpts.Max = *stretchMin, *stretchMax
+	stretchOpts.LowPercentile, stretchOpts.HighPercentile = *stretchLow, *stretchHigh
+
 	// Validate required parameters
 	if *nirFile == "" || *redFile == "" {
 		fmt.Println("Error: NIR and RED band files must be specified")
@@ -54,6 +380,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Bands reloaded from .npy arrays replace both JP2 bands
+	if rawio.IsNPY(*nirFile) != rawio.IsNPY(*redFile) {
+		fmt.Println("Error: -nir and -red must both be JP2 files or both .npy arrays")
+		os.Exit(1)
+	}
+
+	// Both second-date bands are needed for change detection
+	if (*nir2File == "") != (*red2File == "") {
+		fmt.Println("Error: Both -nir2 and -red2 must be specified for change detection")
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	// Check if at least one processor is selected
 	if !*runCPU && !*runGPU {
 		fmt.Println("Error: Must use at least one processor (CPU or GPU)")
@@ -61,12 +400,68 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Georeference GeoTIFF outputs like the NIR band; .npy arrays need -georef
+	if !rawio.IsNPY(*nirFile) {
+		if inputGeoref, err = jp2.ReadGeoreference(*nirFile); err != nil {
+			fmt.Printf("Warning: Could not read georeferencing of %s: %v\n", *nirFile, err)
+		}
+	}
+	if *georefSpec != "" {
+		if inputGeoref, err = geo.ParseGeoreference(*georefSpec); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if (*tilesDir != "" || *kmzFile != "" || *polygonsFile != "") && inputGeoref == nil {
+		fmt.Println("Error: -tiles, -kmz and -polygons need georeferenced input bands or -georef")
+		os.Exit(1)
+	}
+	if *polygonsFile != "" && classScheme == nil {
+		fmt.Println("Error: -polygons needs the classes colorization mode")
+		os.Exit(1)
+	}
+	gtiffWriter.SetGeoreference(inputGeoref)
+	zarrWriter.SetGeoreference(inputGeoref)
+
 	// Print configuration information
 	fmt.Println("NDVI Benchmark Configuration:")
 	fmt.Printf("  NIR Band: %s\n", *nirFile)
 	fmt.Printf("  RED Band: %s\n", *redFile)
 	fmt.Printf("  Iterations: %d\n", *iterations)
 	fmt.Printf("  Thread Configurations: %v\n", threadConfigs)
+	fmt.Printf("  Colormap: %s\n", ndviCmap.Name)
+	fmt.Printf("  Colorization Modes: %v\n", modes)
+	fmt.Printf("  Save Modes: %v\n", saves)
+	fmt.Printf("  Output Formats: %v\n", outputFormats)
+	if inputGeoref != nil {
+		fmt.Printf("  Georeferencing: %s\n", inputGeoref)
+	} else {
+		fmt.Println("  Georeferencing: none")
+	}
+	if stretchOpts.Method != ndvi.StretchNone {
+		fmt.Printf("  Stretch: %s\n", stretchOpts.Method)
+	}
+
+	// Render and save the legends once for all runs
+	if *legendFile != "" || *legendOverlay != "" || *kmzFile != "" {
+		if err := renderLegends(modes); err != nil {
+			fmt.Printf("Error rendering legend: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *nir2File != "" {
+		fmt.Printf("  Change NIR Band: %s\n", *nir2File)
+		fmt.Printf("  Change RED Band: %s\n", *red2File)
+	}
+	if len(compositeScenes) > 0 {
+		fmt.Printf("  Composite: %s of %d scenes\n", compositeMode, len(compositeScenes))
+	}
+	if len(cubeScenes) > 0 {
+		fmt.Printf("  Data Cube: %d scenes, smoothing %s\n", len(cubeScenes), *smoothMethod)
+	}
+	if len(baselineScenes) > 0 {
+		fmt.Printf("  Anomaly Baseline: %d scenes, ±%d days\n", len(baselineScenes), *doyWindow)
+	}
 
 	// Collect metrics for all runs
 	var allMetrics []*metrics.Metrics
@@ -74,17 +469,54 @@ func main() {
 	// Run CPU benchmark for each thread configuration if selected
 	if *runCPU {
 		for _, threadCount := range threadConfigs {
-			fmt.Printf("\nRunning CPU benchmark with %d threads...\n", threadCount)
-			cpuMetrics := runBenchmark("CPU", *nirFile, *redFile, threadCount, *iterations)
-			allMetrics = append(allMetrics, cpuMetrics)
+			for _, mode := range modes {
+				for _, save := range saves {
+					for _, format := range outputFormats {
+						// Tiles are colorized from NDVI values and encoded with OpenJPEG
+						if save == "tiled" && (format != "jp2" || mode == "classes") {
+							fmt.Printf("\nSkipping tiled saving with %s colorization to %s: needs JP2 output and exact or lut colorization\n", mode, format)
+							continue
+						}
+						// Parallel tiles are encoded from the whole RGBA image
+						if format == "jp2tile" && save != "rgba" {
+							fmt.Printf("\nSkipping %s saving to jp2tile: needs rgba saving\n", save)
+							continue
+						}
+						fmt.Printf("\nRunning CPU benchmark with %d threads (%s colorization, %s saving, %s)...\n", threadCount, mode, save, format)
+						if cpuMetrics := runBenchmark("CPU", *nirFile, *redFile, threadCount, *iterations, mode, save, format); cpuMetrics != nil {
+							allMetrics = append(allMetrics, cpuMetrics)
+						}
+					}
+				}
+			}
 		}
 	}
 
 	// Run GPU benchmark if selected and GPU is available
 	if *runGPU {
-		fmt.Println("\nRunning GPU benchmark...")
-		gpuMetrics := runBenchmark("GPU", *nirFile, *redFile, 1, *iterations)
-		allMetrics = append(allMetrics, gpuMetrics)
+		for _, mode := range modes {
+			for _, save := range saves {
+				for _, format := range outputFormats {
+					// nvJPEG2K cannot write palette boxes
+					if save == "paletted" && format == "jp2" {
+						fmt.Println("\nSkipping paletted JP2 saving on GPU: not supported")
+						continue
+					}
+					if save == "tiled" {
+						fmt.Println("\nSkipping tiled saving on GPU: not supported")
+						continue
+					}
+					if format == "jp2tile" {
+						fmt.Println("\nSkipping jp2tile saving on GPU: not supported")
+						continue
+					}
+					fmt.Printf("\nRunning GPU benchmark (%s colorization, %s saving, %s)...\n", mode, save, format)
+					if gpuMetrics := runBenchmark("GPU", *nirFile, *redFile, 1, *iterations, mode, save, format); gpuMetrics != nil {
+						allMetrics = append(allMetrics, gpuMetrics)
+					}
+				}
+			}
+		}
 	}
 
 	// Print metrics results
@@ -93,30 +525,355 @@ func main() {
 		metrics.PrintMetricsTable(allMetrics)
 
 		metrics.PrintScalabilityAnalysis(allMetrics, true)
+		metrics.PrintWriteTable(allMetrics)
+
+		if ndviLUT != nil {
+			metrics.PrintColorModeTable(allMetrics)
+		}
+		if len(saves) > 1 || saves[0] != "rgba" {
+			metrics.PrintSaveModeTable(allMetrics)
+		}
+		if len(outputFormats) > 1 || outputFormats[0] != "jp2" {
+			metrics.PrintFormatTable(allMetrics)
+		}
+		if stretchOpts.Method != ndvi.StretchNone {
+			metrics.PrintStretchTable(allMetrics)
+		}
+		if *ndviOut != "" {
+			metrics.PrintProductTable(allMetrics)
+		}
+		if *quicklookFile != "" {
+			metrics.PrintQuickLookTable(allMetrics)
+		}
+		if *tilesDir != "" {
+			metrics.PrintTileTable(allMetrics)
+		}
+		if *kmzFile != "" {
+			metrics.PrintKMZTable(allMetrics)
+		}
+		if *rawOut != "" {
+			metrics.PrintRawTable(allMetrics)
+		}
+		if classScheme != nil {
+			metrics.PrintClassTable(allMetrics)
+		}
+		if *polygonsFile != "" {
+			metrics.PrintVectorTable(allMetrics)
+		}
+
+		if *nir2File != "" {
+			metrics.PrintChangeTable(allMetrics)
+		}
+		if len(compositeScenes) > 0 {
+			metrics.PrintCompositeTable(allMetrics)
+		}
+		if len(cubeScenes) > 0 {
+			metrics.PrintCubeTable(allMetrics)
+		}
+		if len(cubeScenes) > 0 && *phenology {
+			metrics.PrintPhenologyTable(allMetrics)
+		}
+		if len(baselineScenes) > 0 {
+			metrics.PrintAnomalyTable(allMetrics)
+		}
+	}
+}
+
+// renderLegends renders the legend of each colorization mode and saves it to the legend file
+// When both a colorbar and a class legend are needed, the class legend is saved with a
+// "_classes" suffix
+func renderLegends(modes []string) error {
+	if *legendOverlay != "" {
+		corner, err := legend.ParseCorner(*legendOverlay)
+		if err != nil {
+			return err
+		}
+		legendCorner = corner
+	}
+
+	opts := legend.DefaultOptions()
+	opts.Title = *legendTitle
+	opts.Units = *legendUnits
+	opts.Scale = *legendScale
+	legendMargin = 10 * max(opts.Scale, 1)
+	legendOpts = opts
+
+	var colorbarImg, classLegendImg *image.RGBA
+	for _, mode := range modes {
+		if mode == "classes" {
+			if classLegendImg == nil {
+				classLegendImg = legend.ClassLegend(classScheme, opts)
+			}
+			legends[mode] = classLegendImg
+		} else {
+			if colorbarImg == nil {
+				colorbarImg = legend.Colorbar(ndviCmap, opts)
+			}
+			legends[mode] = colorbarImg
+		}
+	}
+
+	if *legendFile == "" {
+		return nil
+	}
+	if colorbarImg != nil {
+		if err := savePNG(colorbarImg, *legendFile); err != nil {
+			return err
+		}
+	}
+	if classLegendImg != nil {
+		path := *legendFile
+		if colorbarImg != nil {
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + "_classes" + ext
+		}
+		if err := savePNG(classLegendImg, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stretchedColorbar renders the colorbar with its ticks labeled in NDVI values before
+// the stretch
+func stretchedColorbar(stretch *ndvi.Stretch) *image.RGBA {
+	lo, hi := ndviCmap.Domain()
+	opts := legendOpts
+	opts.TickValue = func(v float64) float64 { return stretch.Invert((v - lo) / (hi - lo)) }
+	return legend.Colorbar(ndviCmap, opts)
+}
+
+// savePNG writes an image as a PNG file
+func savePNG(img image.Image, path string) error {
+	f, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// outputFields returns the placeholder values of the map output of a benchmark
+// configuration and iteration
+func outputFields(processorType string, numThreads, iteration int, colorMode, saveMode, format string) output.Fields {
+	ext := "jp2"
+	if format == "gtiff" {
+		ext = "tif"
+	}
+	return output.Fields{
+		"tile":    sceneTile,
+		"date":    sceneDate,
+		"index":   "ndvi",
+		"backend": strings.ToLower(processorType),
+		"threads": strconv.Itoa(numThreads),
+		"mode":    colorMode,
+		"save":    saveMode,
+		"format":  format,
+		"iter":    strconv.Itoa(iteration),
+		"ext":     ext,
+	}
+}
+
+// checkOutputTemplate checks that the output template only uses known placeholders
+// and that the tile and date it uses were found in the NIR file name
+func checkOutputTemplate(template string) error {
+	if _, err := output.Expand(template, outputFields("CPU", 1, 1, "exact", "rgba", "jp2")); err != nil {
+		return err
+	}
+	if strings.Contains(template, "{tile}") && sceneTile == "" {
+		return fmt.Errorf("no Sentinel-2 tile identifier in %s for the {tile} placeholder", *nirFile)
+	}
+	if strings.Contains(template, "{date}") && sceneDate == "" {
+		return fmt.Errorf("no acquisition date in %s for the {date} placeholder", *nirFile)
+	}
+	return nil
+}
+
+// resolveOutput applies the overwrite policy to the expanded output path of a benchmark
+// configuration, returning the path to write and whether the output must be skipped
+// Later iterations of a configuration reuse its path. A path written by another
+// configuration of this run goes through the policy like an existing file, except
+// that overwriting it is an error, since the template does not tell them apart
+func resolveOutput(configuration, path string) (string, bool) {
+	key := configuration + "\x00" + path
+	if resolved, ok := resolvedOutputs[key]; ok {
+		return resolved, false
+	}
+	if owner, ok := outputOwners[path]; ok && (outputPolicy == output.Overwrite || outputPolicy == output.Fail) {
+		fmt.Printf("Error: %s and %s both write %s; add placeholders such as {mode}, {save} or {format} to -output\n", owner, configuration, path)
+		os.Exit(1)
+	}
+	resolved, skip, err := output.Resolve(path, outputPolicy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !skip {
+		resolvedOutputs[key] = resolved
+		outputOwners[resolved] = configuration
+	}
+	return resolved, skip
+}
+
+// parseColorModes parses the comma-separated list of colorization modes
+func parseColorModes(modesStr string) []string {
+	var modes []string
+	for _, mode := range strings.Split(modesStr, ",") {
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if mode != "exact" && mode != "lut" && mode != "classes" {
+			fmt.Printf("Error: unknown colorization mode: %s\n", mode)
+			os.Exit(1)
+		}
+		modes = append(modes, mode)
+	}
+	return modes
+}
+
+// parseSaveModes parses the comma-separated list of save modes
+func parseSaveModes(modesStr string) []string {
+	var modes []string
+	for _, mode := range strings.Split(modesStr, ",") {
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if mode != "rgba" && mode != "paletted" && mode != "tiled" {
+			fmt.Printf("Error: unknown save mode: %s\n", mode)
+			os.Exit(1)
+		}
+		modes = append(modes, mode)
+	}
+	return modes
+}
+
+// parseFormats parses the comma-separated list of output formats
+func parseFormats(formatsStr string) []string {
+	var formats []string
+	for _, format := range strings.Split(formatsStr, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != "jp2" && format != "jp2tile" && format != "gtiff" {
+			fmt.Printf("Error: unknown output format: %s\n", format)
+			os.Exit(1)
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// parseEncodeOptions builds the JPEG2000 encode options from the flags
+func parseEncodeOptions() (jp2.EncodeOptions, error) {
+	opts := jp2.DefaultEncodeOptions()
+	switch strings.ToLower(*jp2Mode) {
+	case "lossless":
+	case "lossy":
+		opts.Lossless = false
+	default:
+		return opts, fmt.Errorf("unknown JPEG2000 mode: %s", *jp2Mode)
+	}
+
+	var err error
+	if opts.Rates, err = parseFloats(*jp2Rates); err != nil {
+		return opts, fmt.Errorf("invalid compression ratios: %v", err)
+	}
+	if opts.PSNR, err = parseFloats(*jp2PSNR); err != nil {
+		return opts, fmt.Errorf("invalid PSNR targets: %v", err)
+	}
+	for _, field := range strings.Split(*jp2Precincts, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil {
+			return opts, fmt.Errorf("invalid precinct size: %s", field)
+		}
+		opts.Precincts = append(opts.Precincts, size)
+	}
+	if opts.Progression, err = jp2.ParseProgressionOrder(*jp2Progression); err != nil {
+		return opts, err
+	}
+	opts.Layers = *jp2Layers
+	opts.Resolutions = *jp2Resolutions
+	opts.TileSize = *jp2Tile
+	opts.CodeblockSize = *jp2Codeblock
+	return opts, opts.Validate()
+}
+
+// parseFloats parses a comma-separated list of numbers, empty for none
+func parseFloats(list string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
 	}
+	return values, nil
+}
+
+// parseRawFormats parses a comma-separated list of raw array formats
+func parseRawFormats(formatsStr string) []string {
+	var formats []string
+	for _, format := range strings.Split(formatsStr, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != "npy" && format != "envi" {
+			fmt.Printf("Error: unknown raw array format: %s\n", format)
+			os.Exit(1)
+		}
+		formats = append(formats, format)
+	}
+	return formats
 }
 
 // runBenchmark runs the NDVI benchmark with the specified processor type and settings
-func runBenchmark(processorType string, nirFilePath, redFilePath string, numThreads, iterations int) *metrics.Metrics {
+func runBenchmark(processorType string, nirFilePath, redFilePath string, numThreads, iterations int, colorMode, saveMode, format string) *metrics.Metrics {
 	// Create appropriate reader and writer based on processor type
 	var reader jp2.Reader
 	var writer jp2.Writer
 
+	var err error
 	if processorType == "CPU" {
 		reader = cpu.NewReader()
-		writer = cpu.NewWriter("./go_jp2_direct/output_cpu.jp2") // Pass the required string argument
+		writer, err = cpu.NewWriter(encodeOpts)
 	} else if processorType == "GPU" {
 		reader = gpu.NewReader()
-		writer = gpu.NewWriter("./go_jp2_direct/output_gpu.jp2") // Pass the required string argument
+		writer, err = gpu.NewWriter(encodeOpts)
 	} else {
 		fmt.Printf("Error: Unknown processor type: %s\n", processorType)
 		os.Exit(1)
 	}
+	if err != nil {
+		fmt.Printf("Error creating %s writer: %v\n", processorType, err)
+		os.Exit(1)
+	}
+
+	// Reload bands exported as .npy arrays instead of decoding them
+	bandReader := reader
+	if rawio.IsNPY(nirFilePath) {
+		bandReader = rawio.NewNPYReader()
+	}
 
 	// Run multiple iterations to get average metrics
 	var accumulatedMetrics *metrics.Metrics
+	completed := 0
 
 	for i := 0; i < iterations; i++ {
+		// Name the map output and apply the overwrite policy before doing any work
+		mapPath, err := output.Expand(*outputTemplate, outputFields(processorType, numThreads, i+1, colorMode, saveMode, format))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		configuration := fmt.Sprintf("%s %d %s %s %s", processorType, numThreads, colorMode, saveMode, format)
+		outputPath, skip := resolveOutput(configuration, mapPath)
+		if skip {
+			fmt.Printf("Skipping iteration %d/%d: %s already exists\n", i+1, iterations, outputPath)
+			continue
+		}
+
 		fmt.Printf("Running iteration %d/%d...\n", i+1, iterations)
 
 		// Create metrics collector
@@ -127,7 +884,7 @@ func runBenchmark(processorType string, nirFilePath, redFilePath string, numThre
 
 		// Read NIR and RED bands
 		fmt.Printf("Reading NIR band: %s\n", nirFilePath)
-		nirBand, err := reader.Read(nirFilePath, numThreads)
+		nirBand, err := bandReader.Read(nirFilePath, numThreads)
 		if err != nil {
 			fmt.Printf("Error reading NIR band: %v\n", err)
 			os.Exit(1)
@@ -135,7 +892,7 @@ func runBenchmark(processorType string, nirFilePath, redFilePath string, numThre
 		defer nirBand.Free()
 
 		fmt.Printf("Reading RED band: %s\n", redFilePath)
-		redBand, err := reader.Read(redFilePath, numThreads)
+		redBand, err := bandReader.Read(redFilePath, numThreads)
 		if err != nil {
 			fmt.Printf("Error reading RED band: %v\n", err)
 			os.Exit(1)
@@ -149,7 +906,18 @@ func runBenchmark(processorType string, nirFilePath, redFilePath string, numThre
 		// Calculate NDVI
 		fmt.Println("Calculating NDVI...")
 		startNDVI := time.Now()
-		ndviMetrics, ndviData, err := ndvi.Calculate(nirBand, redBand, numThreads)
+		var ndviMetrics *metrics.NDVIMetrics
+		var ndviData []float64
+		var ndviResult *ndvi.Result
+		if *nir2File != "" || len(baselineScenes) > 0 || colorMode == "classes" || stretchOpts.Method != ndvi.StretchNone || *ndviOut != "" || *tilesDir != "" || *kmzFile != "" || *rawOut != "" {
+			// Keep the no-data mask for change and anomaly detection, classification, stretching, the data product and map exports
+			ndviMetrics, ndviResult, err = ndvi.CalculateResult(nirBand, redBand, numThreads)
+			if ndviResult != nil {
+				ndviData = ndviResult.Values
+			}
+		} else {
+			ndviMetrics, ndviData, err = ndvi.Calculate(nirBand, redBand, numThreads)
+		}
 		if err != nil {
 			fmt.Printf("Error calculating NDVI: %v\n", err)
 			os.Exit(1)
@@ -157,30 +925,251 @@ func runBenchmark(processorType string, nirFilePath, redFilePath string, numThre
 		ndviTime := time.Since(startNDVI)
 		collector.SetNDVIMetrics(ndviMetrics, ndviTime)
 
+		// Stretch NDVI values over the colormap, except for discrete classes
+		colorData := ndviData
+		var stretch *ndvi.Stretch
+		if stretchOpts.Method != ndvi.StretchNone && colorMode != "classes" {
+			fmt.Printf("Stretching NDVI (%s)...\n", stretchOpts.Method)
+			startStretch := time.Now()
+			stretchMetrics, st, err := ndvi.ComputeStretch(ndviData, ndviResult.Valid, stretchOpts, numThreads)
+			if err != nil {
+				fmt.Printf("Error stretching NDVI: %v\n", err)
+				os.Exit(1)
+			}
+			lo, hi := ndviCmap.Domain()
+			colorData = st.Apply(ndviData, ndviResult.Valid, lo, hi, numThreads)
+			stretch = st
+			collector.SetStretchMetrics(stretchMetrics, time.Since(startStretch))
+
+			// The overlaid colorbar is relabeled here; the legend file is saved after timing
+			if legends[colorMode] != nil && *legendOverlay != "" {
+				legends[colorMode] = stretchedColorbar(st)
+			}
+		}
+
 		// Colorize NDVI values
 		fmt.Println("Colorizing NDVI...")
 		startColor := time.Now()
-		colorMetrics, ndviColorImg := ndvi.Colorize(ndviData, nirBand.Image.Width, nirBand.Image.Height, numThreads)
+		var colorMetrics *metrics.ColorMetrics
+		var ndviColorImg *image.RGBA
+		var ndviPalettedImg *image.Paletted
+		var classImg *image.Gray
+		var colorTiles *ndvi.ColorTiles
+		switch {
+		case saveMode == "tiled":
+			// Tiles are colorized on demand while saving
+			colorMetrics = &metrics.ColorMetrics{}
+			if colorMode == "lut" {
+				colorTiles = ndvi.NewColorTiles(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviLUT)
+				colorMetrics.LUTSize = ndviLUT.Size()
+			} else {
+				colorTiles = ndvi.NewColorTiles(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviCmap)
+			}
+		case colorMode == "classes" && saveMode == "paletted":
+			// The class raster already holds palette indices
+			pixelArea := *pixelSize * *pixelSize
+			colorMetrics, _, classImg = ndvi.ColorizeClasses(ndviData, ndviResult.Valid, nirBand.Image.Width, nirBand.Image.Height, classScheme, pixelArea, numThreads)
+			ndviPalettedImg = ndvi.ClassPaletted(classImg, classScheme)
+			colorMetrics.ImageSize = int64(len(classImg.Pix))
+		case saveMode == "paletted":
+			colorMetrics, ndviPalettedImg, err = ndvi.ColorizePaletted(colorData, nirBand.Image.Width, nirBand.Image.Height, paletteLUT, numThreads)
+			if err != nil {
+				fmt.Printf("Error colorizing NDVI: %v\n", err)
+				os.Exit(1)
+			}
+		case colorMode == "lut":
+			colorMetrics, ndviColorImg = ndvi.ColorizeLUT(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviLUT, numThreads)
+		case colorMode == "classes":
+			pixelArea := *pixelSize * *pixelSize
+			colorMetrics, ndviColorImg, classImg = ndvi.ColorizeClasses(ndviData, ndviResult.Valid, nirBand.Image.Width, nirBand.Image.Height, classScheme, pixelArea, numThreads)
+		default:
+			colorMetrics, ndviColorImg = ndvi.Colorize(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviCmap, numThreads)
+		}
 		colorTime := time.Since(startColor)
 		collector.SetColorMetrics(colorMetrics, colorTime)
 
+		// Composite the legend onto the map
+		if *legendOverlay != "" {
+			if ndviPalettedImg != nil {
+				legend.Overlay(ndviPalettedImg, legends[colorMode], legendCorner, legendMargin)
+			} else {
+				legend.Overlay(ndviColorImg, legends[colorMode], legendCorner, legendMargin)
+			}
+		}
+
 		// Save colorized image
 		fmt.Println("Saving colorized image...")
-		saveTime, err := writer.Write(ndviColorImg, "./go_jp2_direct/output_path.jp2", numThreads) // Add the required string argument
+		mapWriter := writer
+		switch format {
+		case "gtiff":
+			mapWriter = gtiffWriter
+		case "jp2tile":
+			mapWriter = parallelWriter
+		}
+		var writeMetrics *metrics.WriteMetrics
+		if ndviPalettedImg != nil {
+			palettedWriter, ok := mapWriter.(jp2.PalettedWriter)
+			if !ok {
+				fmt.Printf("Error: %s writer does not support paletted output\n", processorType)
+				os.Exit(1)
+			}
+			writeMetrics, err = palettedWriter.WritePaletted(ndviPalettedImg, outputPath, numThreads)
+		} else if colorTiles != nil {
+			tiledWriter, ok := mapWriter.(jp2.TiledWriter)
+			if !ok {
+				fmt.Printf("Error: %s writer does not support tiled output\n", processorType)
+				os.Exit(1)
+			}
+			writeMetrics, err = tiledWriter.WriteTiles(colorTiles, outputPath, numThreads)
+		} else {
+			writeMetrics, err = mapWriter.Write(ndviColorImg, outputPath, numThreads)
+		}
 		if err != nil {
 			fmt.Printf("Error saving image: %v\n", err)
 			os.Exit(1)
 		}
-		collector.SetSaveTime(saveTime)
+		collector.SetWriteMetrics(writeMetrics)
+		if colorTiles != nil {
+			collector.SetTileColorTime(colorTiles.ColorTime())
+		}
 
 		// Stop timing
 		collector.StopTiming(startTime)
 
+		// Record the saved file size
+		if info, err := os.Stat(outputPath); err == nil {
+			collector.SetOutput(saveMode, format, info.Size())
+		} else {
+			collector.SetOutput(saveMode, format, 0)
+		}
+
+		// Measure the lookup table error against exact interpolation outside the timed pipeline
+		if colorMode == "lut" && ndviColorImg != nil {
+			_, exactImg := ndvi.Colorize(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviCmap, numThreads)
+			if *legendOverlay != "" {
+				legend.Overlay(exactImg, legends[colorMode], legendCorner, legendMargin)
+			}
+			collector.SetColorError(ndvi.MaxColorError(exactImg, ndviColorImg, numThreads))
+		}
+
+		// Colorize the whole map for the map exports when it was only colorized by tiles
+		if colorTiles != nil && (*quicklookFile != "" || *tilesDir != "" || *kmzFile != "") {
+			if colorMode == "lut" {
+				_, ndviColorImg = ndvi.ColorizeLUT(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviLUT, numThreads)
+			} else {
+				_, ndviColorImg = ndvi.Colorize(colorData, nirBand.Image.Width, nirBand.Image.Height, ndviCmap, numThreads)
+			}
+		}
+
+		// Save the colorbar relabeled for the stretch outside the timed pipeline
+		if stretch != nil && legends[colorMode] != nil && *legendFile != "" {
+			if *legendOverlay == "" {
+				legends[colorMode] = stretchedColorbar(stretch)
+			}
+			if err := savePNG(legends[colorMode], *legendFile); err != nil {
+				fmt.Printf("Error saving legend: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Save the class raster outside the timed pipeline
+		if classImg != nil && *classRaster != "" {
+			if err := savePNG(classImg, *classRaster); err != nil {
+				fmt.Printf("Error saving class raster: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Trace the class regions into polygons outside the timed pipeline
+		if classImg != nil && *polygonsFile != "" {
+			if err := savePolygons(classImg, ndviData, collector, numThreads); err != nil {
+				fmt.Printf("Error saving polygons: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Write the quicklook outside the timed pipeline
+		if *quicklookFile != "" {
+			var mapImg image.Image = ndviColorImg
+			if ndviPalettedImg != nil {
+				mapImg = ndviPalettedImg
+			}
+			if err := saveQuicklook(mapImg, collector, numThreads); err != nil {
+				fmt.Printf("Error saving quicklook: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Render the tile pyramid outside the timed pipeline
+		if *tilesDir != "" {
+			var mapImg image.Image = ndviColorImg
+			if ndviPalettedImg != nil {
+				mapImg = ndviPalettedImg
+			}
+			if err := saveTiles(mapImg, ndviResult, collector, numThreads); err != nil {
+				fmt.Printf("Error saving tiles: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *kmzFile != "" {
+			var mapImg image.Image = ndviColorImg
+			if ndviPalettedImg != nil {
+				mapImg = ndviPalettedImg
+			}
+			if err := saveKMZ(mapImg, ndviResult, ndviMetrics, colorMode, collector, numThreads); err != nil {
+				fmt.Printf("Error saving KMZ: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Export the raw arrays outside the timed pipeline
+		if *rawOut != "" {
+			if err := saveRaw(nirBand, redBand, ndviResult, collector, numThreads); err != nil {
+				fmt.Printf("Error saving raw arrays: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Archive the NDVI values outside the timed pipeline
+		if *ndviOut != "" {
+			if err := saveProduct(reader, writer, nirBand, redBand, ndviResult, collector, numThreads); err != nil {
+				fmt.Printf("Error saving NDVI product: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Compare with the second date outside the timed pipeline
+		if *nir2File != "" {
+			if err := detectChange(reader, writer, ndviResult, collector, numThreads); err != nil {
+				fmt.Printf("Error detecting change: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(compositeScenes) > 0 {
+			if err := compositeNDVI(reader, writer, collector, numThreads); err != nil {
+				fmt.Printf("Error compositing scenes: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(baselineScenes) > 0 {
+			if err := detectAnomaly(reader, writer, ndviResult, collector, numThreads); err != nil {
+				fmt.Printf("Error detecting anomalies: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(cubeScenes) > 0 {
+			if err := buildCube(reader, writer, collector, numThreads); err != nil {
+				fmt.Printf("Error building data cube: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		// Get metrics for this iteration
 		iterationMetrics := collector.GetMetrics()
 
 		// Accumulate metrics
-		if i == 0 {
+		completed++
+		if accumulatedMetrics == nil {
 			accumulatedMetrics = metrics.InitializeAccumulatedMetrics(iterationMetrics)
 		} else {
 			metrics.AggregateMetrics(accumulatedMetrics, iterationMetrics)
@@ -188,11 +1177,568 @@ func runBenchmark(processorType string, nirFilePath, redFilePath string, numThre
 
 		// Force garbage collection between iterations
 		ndviData = nil
+		ndviResult = nil
 		utils.FreeMemory()
 	}
 
-	// Calculate average metrics
-	averageMetrics := metrics.AverageMetrics(accumulatedMetrics, iterations)
+	// Calculate average metrics over the iterations run
+	if accumulatedMetrics == nil {
+		return nil
+	}
+	averageMetrics := metrics.AverageMetrics(accumulatedMetrics, completed)
 
 	return averageMetrics
 }
+
+// saveQuicklook writes a PNG preview of the NDVI map, downsampling the map already
+// decoded and colorized by the pipeline
+func saveQuicklook(mapImg image.Image, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving quicklook: %s\n", *quicklookFile)
+	startQuickLook := time.Now()
+
+	quicklook := ndvi.Quicklook(toRGBA(mapImg), *quicklookSize, numThreads)
+	if err := savePNG(quicklook, *quicklookFile); err != nil {
+		return err
+	}
+	quickLookTime := time.Since(startQuickLook)
+
+	info, err := os.Stat(*quicklookFile)
+	if err != nil {
+		return err
+	}
+	collector.SetQuickLookMetrics(&metrics.QuickLookMetrics{
+		Width:  quicklook.Bounds().Dx(),
+		Height: quicklook.Bounds().Dy(),
+		Size:   info.Size(),
+	}, quickLookTime)
+	return nil
+}
+
+// saveTiles renders the NDVI map as a Web Mercator tile pyramid with no-data pixels
+// transparent, into a PMTiles archive or z/x/y files with a viewer page next to them
+func saveTiles(mapImg image.Image, result *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving tiles: %s\n", *tilesDir)
+	startTiles := time.Now()
+
+	bounds := mapImg.Bounds()
+	opts := tiles.DefaultOptions()
+	opts.MinZoom, opts.MaxZoom = *tilesMinZoom, *tilesMaxZoom
+	if opts.MaxZoom < 0 {
+		nativeZoom, err := tiles.NativeZoom(inputGeoref, bounds.Dx(), bounds.Dy(), opts.TileSize)
+		if err != nil {
+			return err
+		}
+		opts.MaxZoom = nativeZoom
+	}
+	if opts.MinZoom < 0 {
+		opts.MinZoom = max(0, opts.MaxZoom-4)
+	}
+
+	proj, err := geo.ProjectionFor(inputGeoref.EPSG)
+	if err != nil {
+		return err
+	}
+	lonLat := tiles.LonLatBounds(proj, inputGeoref, bounds.Dx(), bounds.Dy())
+
+	// Pack the tiles into a PMTiles archive, or write them as z/x/y files
+	var sink tiles.Sink
+	var archive *tiles.PMTilesSink
+	if strings.EqualFold(filepath.Ext(*tilesDir), ".pmtiles") {
+		archive = tiles.NewPMTilesSink(*tilesDir, "NDVI")
+		sink = archive
+	} else if sink, err = tiles.NewDirSink(*tilesDir); err != nil {
+		return err
+	}
+
+	tileMetrics, err := tiles.Render(toRGBA(mapImg), result.Valid, inputGeoref, opts, sink, numThreads)
+	if err != nil {
+		return err
+	}
+	if archive != nil {
+		if tileMetrics.UniqueTiles, err = archive.Finish(lonLat); err != nil {
+			return err
+		}
+	}
+	tilesTime := time.Since(startTiles)
+
+	if archive != nil {
+		info, err := os.Stat(*tilesDir)
+		if err != nil {
+			return err
+		}
+		tileMetrics.ArchiveSize = info.Size()
+	} else if err := tiles.WriteViewer(*tilesDir, "NDVI", lonLat, opts); err != nil {
+		return err
+	}
+
+	collector.SetTileMetrics(tileMetrics, tilesTime)
+	return nil
+}
+
+// saveKMZ writes the NDVI map as a Google Earth ground overlay with its legend, unless
+// already composited onto the map, and the NDVI statistics as description
+func saveKMZ(mapImg image.Image, result *ndvi.Result, ndviMetrics *metrics.NDVIMetrics, colorMode string, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving KMZ: %s\n", *kmzFile)
+	startKMZ := time.Now()
+
+	opts := kmz.DefaultOptions()
+	opts.MaxSize = *kmzSize
+	if *legendOverlay == "" && legends[colorMode] != nil {
+		opts.Legend = legends[colorMode]
+	}
+	opts.Stats = &kmz.Stats{
+		Min:          ndviMetrics.Min,
+		Max:          ndviMetrics.Max,
+		Mean:         ndviMetrics.Average,
+		TotalPixels:  ndviMetrics.TotalPixels,
+		NoDataPixels: ndviMetrics.NoDataPixels,
+	}
+
+	kmzMetrics, err := kmz.Write(toRGBA(mapImg), result.Valid, inputGeoref, opts, *kmzFile, numThreads)
+	if err != nil {
+		return err
+	}
+	collector.SetKMZMetrics(kmzMetrics, time.Since(startKMZ))
+	return nil
+}
+
+// savePolygons traces the regions of the class raster into simplified polygons with
+// their mean NDVI and writes them as GeoJSON
+func savePolygons(classImg *image.Gray, ndviData []float64, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving polygons: %s\n", *polygonsFile)
+	startVector := time.Now()
+
+	pixelWidth, _ := inputGeoref.PixelSize()
+	opts := vector.Options{
+		Tolerance: *polygonsTol * math.Abs(pixelWidth),
+		MinArea:   *polygonsMinArea,
+	}
+	polygons, vectorMetrics, err := vector.Polygonize(classImg, ndviData, inputGeoref, opts, numThreads)
+	if err != nil {
+		return err
+	}
+
+	var labels []string
+	for _, entry := range classScheme.Legend() {
+		labels = append(labels, entry.Label)
+	}
+	if vectorMetrics.Size, err = vector.WriteGeoJSON(*polygonsFile, classScheme.Name, polygons, labels, inputGeoref.EPSG); err != nil {
+		return err
+	}
+	collector.SetVectorMetrics(vectorMetrics, time.Since(startVector))
+	return nil
+}
+
+// saveRaw writes the NDVI values and the decoded bands to the raw array directory in
+// each raw array format
+func saveRaw(nirBand, redBand *jp2.BandResult, result *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving raw arrays: %s\n", *rawOut)
+	startRaw := time.Now()
+
+	values := ndvi.Float64(result, numThreads)
+	bands := []struct {
+		name string
+		band *jp2.BandResult
+	}{{"nir", nirBand}, {"red", redBand}}
+
+	var paths []string
+	for _, format := range rawArrayFormats {
+		switch format {
+		case "npy":
+			path := filepath.Join(*rawOut, "ndvi.npy")
+			if err := rawio.WriteNPYFloat64(path, values, []int{result.Height, result.Width}, numThreads); err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			for _, b := range bands {
+				path := filepath.Join(*rawOut, b.name+".npy")
+				if err := rawio.WriteNPYImage(path, b.band.Image, numThreads); err != nil {
+					return err
+				}
+				paths = append(paths, path)
+			}
+		case "envi":
+			path := filepath.Join(*rawOut, "ndvi.img")
+			header := rawio.ENVIHeader{Description: "NDVI", BandNames: []string{"NDVI"}, Georef: inputGeoref}
+			if err := rawio.WriteENVIFloat64(path, values, result.Width, result.Height, header, numThreads); err != nil {
+				return err
+			}
+			paths = append(paths, path, rawio.ENVIHeaderPath(path))
+			for _, b := range bands {
+				path := filepath.Join(*rawOut, b.name+".img")
+				header := rawio.ENVIHeader{Description: strings.ToUpper(b.name) + " band", BandNames: componentNames(strings.ToUpper(b.name), b.band.Image.Components), Georef: inputGeoref}
+				if err := rawio.WriteENVIImage(path, b.band.Image, header, numThreads); err != nil {
+					return err
+				}
+				paths = append(paths, path, rawio.ENVIHeaderPath(path))
+			}
+		}
+	}
+	rawTime := time.Since(startRaw)
+
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+	}
+	collector.SetRawMetrics(&metrics.RawMetrics{
+		Formats: strings.Join(rawArrayFormats, ","),
+		Files:   len(paths),
+		Size:    size,
+	}, rawTime)
+	return nil
+}
+
+// componentNames returns one band name per component of a decoded band, numbered from 1
+// when the band has more than one component
+func componentNames(name string, components int) []string {
+	if components == 1 {
+		return []string{name}
+	}
+	names := make([]string, components)
+	for c := range names {
+		names[c] = fmt.Sprintf("%s %d", name, c+1)
+	}
+	return names
+}
+
+// toRGBA returns a map image as RGBA, converting paletted maps
+func toRGBA(mapImg image.Image) *image.RGBA {
+	if rgba, ok := mapImg.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := mapImg.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, mapImg, bounds.Min, draw.Src)
+	return img
+}
+
+// saveProduct writes the NDVI values as a quantized int16 product and reads it back
+// to measure the round trip error
+func saveProduct(reader jp2.Reader, writer jp2.Writer, nirBand, redBand *jp2.BandResult, result *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	switch strings.ToLower(filepath.Ext(*ndviOut)) {
+	case ".tif", ".tiff":
+		return saveGeoTIFFProduct(result, collector, numThreads)
+	case ".zarr":
+		return saveZarrProduct(nirBand, redBand, result, collector, numThreads)
+	}
+
+	quantizedWriter, ok := writer.(jp2.QuantizedWriter)
+	if !ok {
+		fmt.Println("Skipping NDVI product: writer does not support int16 output")
+		return nil
+	}
+
+	fmt.Printf("Saving NDVI product: %s\n", *ndviOut)
+	q := jp2.NDVIQuantization()
+	samples := ndvi.Quantize(result, q, numThreads)
+	writeMetrics, err := quantizedWriter.WriteQuantized(samples, result.Width, result.Height, q, *ndviOut, numThreads)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(*ndviOut)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Reading NDVI product back...")
+	startRead := time.Now()
+	product, err := jp2.ReadQuantized(reader, *ndviOut, numThreads)
+	if err != nil {
+		return fmt.Errorf("reading NDVI product: %v", err)
+	}
+	readTime := time.Since(startRead)
+
+	maxError, mismatches, err := ndvi.QuantizationError(result, product, numThreads)
+	if err != nil {
+		return err
+	}
+
+	collector.SetProductMetrics(&metrics.ProductMetrics{
+		WriteTime:       writeMetrics.TotalTime,
+		ReadTime:        readTime,
+		Size:            info.Size(),
+		Step:            q.Scale,
+		MaxError:        maxError,
+		ValidMismatches: mismatches,
+	})
+	return nil
+}
+
+// saveGeoTIFFProduct writes the NDVI values as a GeoTIFF of int16 quantized or float32
+// values; the product is not read back
+func saveGeoTIFFProduct(result *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving NDVI product: %s (%s GeoTIFF)\n", *ndviOut, *ndviType)
+	var writeMetrics *metrics.WriteMetrics
+	var err error
+	step := 0.0
+	if *ndviType == "float32" {
+		writeMetrics, err = gtiffWriter.WriteFloat32(ndvi.Float32(result, numThreads), result.Width, result.Height, *ndviOut, numThreads)
+	} else {
+		q := jp2.NDVIQuantization()
+		step = q.Scale
+		writeMetrics, err = gtiffWriter.WriteQuantized(ndvi.Quantize(result, q, numThreads), result.Width, result.Height, q, *ndviOut, numThreads)
+	}
+	if err != nil {
+		return err
+	}
+
+	collector.SetProductMetrics(&metrics.ProductMetrics{
+		WriteTime: writeMetrics.TotalTime,
+		Size:      writeMetrics.Bytes,
+		Step:      step,
+	})
+	return nil
+}
+
+// saveZarrProduct writes the NDVI values as an int16 quantized or float32 array of a
+// Zarr store, optionally with the decoded bands; the product is not read back
+func saveZarrProduct(nirBand, redBand *jp2.BandResult, result *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Saving NDVI product: %s (%s Zarr)\n", *ndviOut, *ndviType)
+	var writeMetrics *metrics.WriteMetrics
+	var err error
+	step := 0.0
+	if *ndviType == "float32" {
+		writeMetrics, err = zarrWriter.WriteFloat32(ndvi.Float32(result, numThreads), result.Width, result.Height, *ndviOut, numThreads)
+	} else {
+		q := jp2.NDVIQuantization()
+		step = q.Scale
+		writeMetrics, err = zarrWriter.WriteQuantized(ndvi.Quantize(result, q, numThreads), result.Width, result.Height, q, *ndviOut, numThreads)
+	}
+	if err != nil {
+		return err
+	}
+	writeTime := writeMetrics.TotalTime
+	if *zarrBands {
+		for _, b := range []struct {
+			name string
+			band *jp2.BandResult
+		}{{"nir", nirBand}, {"red", redBand}} {
+			bandMetrics, err := zarrWriter.WriteBand(b.band.Image, b.name, *ndviOut, numThreads)
+			if err != nil {
+				return err
+			}
+			writeTime += bandMetrics.TotalTime
+		}
+	}
+
+	var size int64
+	err = filepath.WalkDir(*ndviOut, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	collector.SetProductMetrics(&metrics.ProductMetrics{
+		WriteTime: writeTime,
+		Size:      size,
+		Step:      step,
+	})
+	return nil
+}
+
+// detectChange computes NDVI for the second date, compares it with before and saves the change map
+func detectChange(reader jp2.Reader, writer jp2.Writer, before *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Reading change NIR band: %s\n", *nir2File)
+	nirBand, err := reader.Read(*nir2File, numThreads)
+	if err != nil {
+		return fmt.Errorf("reading change NIR band: %v", err)
+	}
+	defer nirBand.Free()
+
+	fmt.Printf("Reading change RED band: %s\n", *red2File)
+	redBand, err := reader.Read(*red2File, numThreads)
+	if err != nil {
+		return fmt.Errorf("reading change RED band: %v", err)
+	}
+	defer redBand.Free()
+
+	fmt.Println("Detecting NDVI change...")
+	startChange := time.Now()
+	_, after, err := ndvi.CalculateResult(nirBand, redBand, numThreads)
+	if err != nil {
+		return err
+	}
+
+	opts := ndvi.ChangeOptions{
+		LossThreshold: *lossThreshold,
+		GainThreshold: *gainThreshold,
+		PixelArea:     *pixelSize * *pixelSize,
+	}
+	changeMetrics, change, err := ndvi.DetectChange(before, after, opts, numThreads)
+	if err != nil {
+		return err
+	}
+	collector.SetChangeMetrics(changeMetrics, time.Since(startChange))
+
+	fmt.Println("Saving change map...")
+	_, changeImg := ndvi.ColorizeChange(change, changeCmap, numThreads)
+	if _, err := writer.Write(changeImg, "./go_jp2_direct/output_change.jp2", numThreads); err != nil {
+		return fmt.Errorf("saving change map: %v", err)
+	}
+
+	return nil
+}
+
+// compositeNDVI composites the configured scenes and saves the colorized composite
+func compositeNDVI(reader jp2.Reader, writer jp2.Writer, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Compositing %d scenes (%s)...\n", len(compositeScenes), compositeMode)
+	startComposite := time.Now()
+	compositeMetrics, composite, err := ndvi.CompositeScenes(reader, compositeScenes, compositeMode, int64(*compositeMemory)<<20, numThreads)
+	if err != nil {
+		return err
+	}
+	collector.SetCompositeMetrics(compositeMetrics, time.Since(startComposite))
+
+	fmt.Println("Saving composite...")
+	_, compositeImg := ndvi.Colorize(composite.Values, composite.Width, composite.Height, ndviCmap, numThreads)
+	compositeImg = ndvi.MaskInvalid(compositeImg, composite.Valid, numThreads)
+	if _, err := writer.Write(compositeImg, "./go_jp2_direct/output_composite.jp2", numThreads); err != nil {
+		return fmt.Errorf("saving composite: %v", err)
+	}
+
+	return nil
+}
+
+// buildCube stacks the dated scenes, fills gaps, smooths them and exports the requested series
+func buildCube(reader jp2.Reader, writer jp2.Writer, collector *metrics.Collector, numThreads int) error {
+	fmt.Printf("Building data cube from %d scenes...\n", len(cubeScenes))
+	startCube := time.Now()
+	cube, err := ndvi.BuildCube(reader, cubeScenes, numThreads)
+	if err != nil {
+		return err
+	}
+	cubeTime := time.Since(startCube)
+
+	fmt.Println("Filling gaps and smoothing...")
+	startSmooth := time.Now()
+	filled := cube.FillGaps(numThreads)
+	switch *smoothMethod {
+	case "none":
+	case "ma":
+		err = cube.SmoothMovingAverage(*smoothWindow, numThreads)
+	case "sg":
+		err = cube.SmoothSavitzkyGolay(*smoothWindow, *sgOrder, numThreads)
+	default:
+		err = fmt.Errorf("unknown smoothing method: %s", *smoothMethod)
+	}
+	if err != nil {
+		return err
+	}
+
+	cubeMetrics := cube.Metrics()
+	cubeMetrics.Time = cubeTime
+	cubeMetrics.SmoothTime = time.Since(startSmooth)
+	cubeMetrics.Smoothing = *smoothMethod
+	cubeMetrics.FilledObservations = filled
+	collector.SetCubeMetrics(cubeMetrics)
+
+	if *phenology {
+		if err := extractPhenology(cube, writer, collector, numThreads); err != nil {
+			return err
+		}
+	}
+
+	if len(seriesPoints) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Exporting %d time series to %s\n", len(seriesPoints), *seriesOut)
+	f, err := output.Create(*seriesOut)
+	if err != nil {
+		return err
+	}
+	if err := cube.WriteSeriesCSV(f, seriesPoints); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// extractPhenology extracts season metrics from the cube, summarizes them per field and saves the selected layer
+func extractPhenology(cube *ndvi.Cube, writer jp2.Writer, collector *metrics.Collector, numThreads int) error {
+	layer, err := ndvi.ParsePhenologyMetric(*phenoLayer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Extracting phenology...")
+	startPheno := time.Now()
+	phenoMetrics, pheno, err := ndvi.ExtractPhenology(cube, ndvi.DefaultPhenologyOptions(), numThreads)
+	if err != nil {
+		return err
+	}
+
+	if *fieldsFile != "" {
+		f, err := os.Open(*fieldsFile)
+		if err != nil {
+			return err
+		}
+		labels, err := ndvi.ReadFieldRects(f, cube.Width, cube.Height)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if phenoMetrics.Fields, err = ndvi.SummarizeFields(pheno, labels); err != nil {
+			return err
+		}
+	}
+	collector.SetPhenologyMetrics(phenoMetrics, time.Since(startPheno))
+
+	fmt.Printf("Saving phenology layer %s...\n", layer)
+	layerResult := pheno.Layer(layer)
+	lo, hi := ndvi.ValidRange(layerResult)
+	_, layerImg := ndvi.ColorizeLayer(layerResult, lo, hi, ndviCmap, numThreads)
+	outputPath := fmt.Sprintf("./go_jp2_direct/output_phenology_%s.jp2", layer)
+	if _, err := writer.Write(layerImg, outputPath, numThreads); err != nil {
+		return fmt.Errorf("saving phenology layer: %v", err)
+	}
+
+	return nil
+}
+
+// detectAnomaly builds the historical baseline, compares current with it and saves the anomaly map
+func detectAnomaly(reader jp2.Reader, writer jp2.Writer, current *ndvi.Result, collector *metrics.Collector, numThreads int) error {
+	// Without a dated current scene every historical scene is used
+	window := *doyWindow
+	date, err := ndvi.ParseSceneDate(*nirFile)
+	if err != nil {
+		fmt.Printf("Warning: %v, using every baseline scene\n", err)
+		window = 0
+	}
+
+	fmt.Printf("Building baseline from %d scenes...\n", len(baselineScenes))
+	startAnomaly := time.Now()
+	baseline, used, err := ndvi.BuildBaseline(reader, baselineScenes, date, window, numThreads)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Detecting NDVI anomalies...")
+	anomalyMetrics, anomaly, err := ndvi.DetectAnomaly(current, baseline, ndvi.DefaultAnomalyOptions(), numThreads)
+	if err != nil {
+		return err
+	}
+	anomalyMetrics.BaselineScenes = used
+	collector.SetAnomalyMetrics(anomalyMetrics, time.Since(startAnomaly))
+
+	fmt.Println("Saving anomaly map...")
+	_, anomalyImg := ndvi.ColorizeAnomaly(anomaly, anomalyCmap, numThreads)
+	if _, err := writer.Write(anomalyImg, "./go_jp2_direct/output_anomaly.jp2", numThreads); err != nil {
+		return fmt.Errorf("saving anomaly map: %v", err)
+	}
+
+	return nil
+}
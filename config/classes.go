@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Class is one class of a classification scheme
+type Class struct {
+	Label string
+	Min   float64 // Lower bound of the class, inclusive
+	Color color.RGBA
+}
+
+// ClassScheme maps values to a small set of discrete classes
+// A value belongs to the last class whose lower bound is not above it, and values
+// below the first lower bound fall into the first class
+type ClassScheme struct {
+	Name    string
+	Classes []Class
+	NoData  color.RGBA // Color for pixels without data, transparent by default
+}
+
+// LegendEntry describes a class for map legends
+type LegendEntry struct {
+	Index    int // Class index, also the value of the class raster minus one
+	Label    string
+	Min, Max float64 // Value range of the class, Min inclusive and Max exclusive
+	Color    color.RGBA
+}
+
+// defaultClasses is the standard land cover split of NDVI
+var defaultClasses = []Class{
+	{Label: "Water", Min: math.Inf(-1), Color: color.RGBA{38, 115, 202, 255}},
+	{Label: "Bare soil", Min: 0, Color: color.RGBA{181, 140, 94, 255}},
+	{Label: "Sparse vegetation", Min: 0.2, Color: color.RGBA{235, 225, 120, 255}},
+	{Label: "Moderate vegetation", Min: 0.4, Color: color.RGBA{120, 190, 80, 255}},
+	{Label: "Dense vegetation", Min: 0.6, Color: color.RGBA{20, 110, 40, 255}},
+}
+
+// NewClassScheme creates a classification scheme, sorting the classes by lower bound
+func NewClassScheme(name string, classes []Class) (*ClassScheme, error) {
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("class scheme %s has no classes", name)
+	}
+	if len(classes) > 255 {
+		return nil, fmt.Errorf("class scheme %s has %d classes, at most 255 are supported", name, len(classes))
+	}
+
+	sorted := make([]Class, len(classes))
+	copy(sorted, classes)
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].Min < sorted[b].Min })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Min == sorted[i-1].Min {
+			return nil, fmt.Errorf("class scheme %s: classes %q and %q have the same lower bound %v", name, sorted[i-1].Label, sorted[i].Label, sorted[i].Min)
+		}
+	}
+
+	return &ClassScheme{Name: name, Classes: sorted}, nil
+}
+
+// DefaultClassScheme returns water, bare soil, sparse, moderate and dense vegetation classes
+func DefaultClassScheme() *ClassScheme {
+	scheme, _ := NewClassScheme("default", defaultClasses)
+	return scheme
+}
+
+// ResolveClassScheme returns the default scheme for "default", or loads it from a file
+func ResolveClassScheme(spec string) (*ClassScheme, error) {
+	if strings.ToLower(spec) == "default" {
+		return DefaultClassScheme(), nil
+	}
+	return LoadClassScheme(spec)
+}
+
+// LoadClassScheme loads a class scheme file
+func LoadClassScheme(path string) (*ClassScheme, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open class scheme: %v", err)
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	scheme, err := ParseClassScheme(f, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return scheme, nil
+}
+
+// ParseClassScheme parses a class scheme text file
+// Each line holds the lower bound of a class ("-inf" for an open first class), its
+// color and its label, separated by whitespace. The color is R,G,B[,A] with its
+// components separated by commas, R G B [A] separated by whitespace, a #hex color or
+// a color name, and the rest of the line is the label as written; a lower bound of
+// "nv" sets the no-data color instead
+func ParseClassScheme(r io.Reader, name string) (*ClassScheme, error) {
+	var classes []Class
+	noData := color.RGBA{}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		bound, rest := cutClassField(text)
+		if rest == "" {
+			return nil, fmt.Errorf("line %d: missing color", line)
+		}
+		components, label := cutClassColor(rest)
+		if len(components) == 2 || len(components) > 4 {
+			return nil, fmt.Errorf("line %d: color needs 3 or 4 components, got %d", line, len(components))
+		}
+		c, err := parseColorFields(components)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if strings.ToLower(bound) == "nv" {
+			noData = c
+			continue
+		}
+
+		lower, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid lower bound %q", line, bound)
+		}
+
+		if label == "" {
+			label = fmt.Sprintf("Class %d", len(classes)+1)
+		}
+		classes = append(classes, Class{Label: label, Min: lower, Color: c})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	scheme, err := NewClassScheme(name, classes)
+	if err != nil {
+		return nil, err
+	}
+	scheme.NoData = noData
+	return scheme, nil
+}
+
+// cutClassField returns the first whitespace separated field of a line and the rest
+func cutClassField(text string) (string, string) {
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		return text[:i], strings.TrimSpace(text[i:])
+	}
+	return text, ""
+}
+
+// cutClassColor splits the color at the start of text from the label after it
+// Numeric colors are the components joined by commas, which may have spaces around
+// them, or three or four whitespace separated integers; other colors are a single field
+func cutClassColor(text string) ([]string, string) {
+	if text[0] < '0' || text[0] > '9' {
+		field, label := cutClassField(text)
+		return []string{field}, label
+	}
+
+	var components []string
+	rest := text
+	for {
+		end := strings.IndexAny(rest, ", \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		components = append(components, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+		if !strings.HasPrefix(rest, ",") {
+			break
+		}
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+	if len(components) > 1 {
+		return components, rest
+	}
+
+	// Without commas take the leading integers as R G B, and a fourth as alpha
+	// only when a label follows it, as older class files were written
+	fields, label := cutIntegerFields(text, 4)
+	if len(fields) == 4 && label == "" {
+		fields, label = cutIntegerFields(text, 3)
+	}
+	if len(fields) < 2 {
+		return components, rest
+	}
+	return fields, label
+}
+
+// cutIntegerFields cuts up to n leading whitespace separated integer fields from text
+// and returns them with the rest of the text
+func cutIntegerFields(text string, n int) ([]string, string) {
+	var fields []string
+	rest := text
+	for len(fields) < n && rest != "" {
+		field, after := cutClassField(rest)
+		if _, err := strconv.Atoi(field); err != nil {
+			break
+		}
+		fields = append(fields, field)
+		rest = after
+	}
+	return fields, rest
+}
+
+// Classify returns the index of the class of value
+func (s *ClassScheme) Classify(value float64) int {
+	// Binary search for the first class whose lower bound is above the value
+	k := sort.Search(len(s.Classes), func(i int) bool { return s.Classes[i].Min > value })
+	if k == 0 {
+		return 0
+	}
+	return k - 1
+}
+
+// Palette returns the no-data color followed by the class colors, matching the
+// class raster values of class index plus one
+func (s *ClassScheme) Palette() color.Palette {
+	palette := make(color.Palette, 0, len(s.Classes)+1)
+	palette = append(palette, s.NoData)
+	for _, class := range s.Classes {
+		palette = append(palette, class.Color)
+	}
+	return palette
+}
+
+// Legend returns the value range, label and color of every class
+func (s *ClassScheme) Legend() []LegendEntry {
+	legend := make([]LegendEntry, len(s.Classes))
+	for i, class := range s.Classes {
+		entry := LegendEntry{
+			Index: i,
+			Label: class.Label,
+			Min:   class.Min,
+			Max:   math.Inf(1),
+			Color: class.Color,
+		}
+		if i == 0 {
+			entry.Min = math.Inf(-1)
+		}
+		if i+1 < len(s.Classes) {
+			entry.Max = s.Classes[i+1].Min
+		}
+		legend[i] = entry
+	}
+	return legend
+}
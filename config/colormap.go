@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ColorStop is a color at a value of a colormap
+type ColorStop struct {
+	Value float64
+	Color color.RGBA
+}
+
+// Colormap maps values to colors by interpolating linearly between sorted stops
+type Colormap struct {
+	Name   string
+	Stops  []ColorStop
+	NoData color.RGBA // Color for pixels without data, transparent by default
+}
+
+// NewColormap creates a colormap from stops, sorting them by value
+func NewColormap(name string, stops []ColorStop) (*Colormap, error) {
+	if len(stops) == 0 {
+		return nil, fmt.Errorf("colormap %s has no color stops", name)
+	}
+
+	sorted := make([]ColorStop, len(stops))
+	copy(sorted, stops)
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].Value < sorted[b].Value })
+
+	return &Colormap{Name: name, Stops: sorted}, nil
+}
+
+// DefaultColormap returns the NDVI colormap used when none is selected
+func DefaultColormap() *Colormap {
+	cmap, _ := BuiltinColormap("ndvi")
+	return cmap
+}
+
+// BuiltinColormap returns a copy of a named built-in colormap
+func BuiltinColormap(name string) (*Colormap, error) {
+	stops, ok := builtinColormaps[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown colormap: %s (available: %s)", name, strings.Join(BuiltinColormapNames(), ", "))
+	}
+	return NewColormap(strings.ToLower(name), stops)
+}
+
+// BuiltinColormapNames returns the names of the built-in colormaps in sorted order
+func BuiltinColormapNames() []string {
+	names := make([]string, 0, len(builtinColormaps))
+	for name := range builtinColormaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveColormap returns the built-in colormap with the given name, or loads it
+// from a file when spec is not a built-in name
+func ResolveColormap(spec string) (*Colormap, error) {
+	if _, ok := builtinColormaps[strings.ToLower(spec)]; ok {
+		return BuiltinColormap(spec)
+	}
+	if _, err := os.Stat(spec); err != nil {
+		return nil, fmt.Errorf("unknown colormap %q: not a built-in (%s) nor a readable file", spec, strings.Join(BuiltinColormapNames(), ", "))
+	}
+	return LoadColormap(spec)
+}
+
+// LoadColormap loads a colormap file, choosing the format from its extension:
+// .cpt for GMT, .xml or .qml for QGIS and anything else for GDAL color-relief
+func LoadColormap(path string) (*Colormap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open colormap: %v", err)
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var cmap *Colormap
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".cpt":
+		cmap, err = ParseGMTPalette(f, name)
+	case ".xml", ".qml":
+		cmap, err = ParseQGISColorRamp(f, name)
+	default:
+		cmap, err = ParseGDALColorRelief(f, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cmap, nil
+}
+
+// Color returns the color for the given value, clamping to the colormap ends
+func (c *Colormap) Color(value float64) color.RGBA {
+	points := c.Stops
+	if value <= points[0].Value {
+		return points[0].Color
+	}
+	if value >= points[len(points)-1].Value {
+		return points[len(points)-1].Color
+	}
+
+	// Binary search for the index
+	var idx int
+	lo, hi := 0, len(points)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		if points[mid].Value > value {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+			idx = mid
+		}
+	}
+
+	// Special case for the last point
+	if idx >= len(points)-1 {
+		return points[len(points)-1].Color
+	}
+
+	// Efficient interpolation
+	p1, p2 := points[idx], points[idx+1]
+	if p2.Value == p1.Value {
+		return p2.Color // Hard edge of a discrete palette
+	}
+	t := (value - p1.Value) / (p2.Value - p1.Value)
+
+	r := uint8(float64(p1.Color.R) + t*(float64(p2.Color.R)-float64(p1.Color.R)))
+	g := uint8(float64(p1.Color.G) + t*(float64(p2.Color.G)-float64(p1.Color.G)))
+	b := uint8(float64(p1.Color.B) + t*(float64(p2.Color.B)-float64(p1.Color.B)))
+	a := uint8(float64(p1.Color.A) + t*(float64(p2.Color.A)-float64(p1.Color.A)))
+
+	return color.RGBA{r, g, b, a}
+}
+
+// Domain returns the values of the first and last stops
+func (c *Colormap) Domain() (float64, float64) {
+	return c.Stops[0].Value, c.Stops[len(c.Stops)-1].Value
+}
+
+// Rescale returns a copy of the colormap with its stops moved linearly onto [lo, hi]
+func (c *Colormap) Rescale(lo, hi float64) *Colormap {
+	from, to := c.Domain()
+	rescaled := &Colormap{
+		Name:   c.Name,
+		Stops:  make([]ColorStop, len(c.Stops)),
+		NoData: c.NoData,
+	}
+	for i, stop := range c.Stops {
+		t := 0.0
+		if to != from {
+			t = (stop.Value - from) / (to - from)
+		}
+		rescaled.Stops[i] = ColorStop{Value: lo + t*(hi-lo), Color: stop.Color}
+	}
+	return rescaled
+}
@@ -0,0 +1,435 @@
+package config
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Percentages in GDAL color-relief files are resolved against the NDVI domain
+const (
+	percentDomainMin = -1.0
+	percentDomainMax = 1.0
+)
+
+// namedColors are the color names understood by GDAL color-relief and GMT palette files
+var namedColors = map[string]color.RGBA{
+	"white":   {255, 255, 255, 255},
+	"black":   {0, 0, 0, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"magenta": {255, 0, 255, 255},
+	"cyan":    {0, 255, 255, 255},
+	"aqua":    {0, 192, 192, 255},
+	"grey":    {190, 190, 190, 255},
+	"gray":    {190, 190, 190, 255},
+	"orange":  {255, 127, 0, 255},
+	"brown":   {165, 42, 42, 255},
+	"purple":  {160, 32, 240, 255},
+	"violet":  {238, 130, 238, 255},
+	"indigo":  {75, 0, 130, 255},
+}
+
+// ParseGDALColorRelief parses a gdaldem color-relief text file
+// Each line holds a value followed by R G B [A] or a color name; the value may be
+// "nv" for no data or a percentage, which is resolved against the NDVI domain
+func ParseGDALColorRelief(r io.Reader, name string) (*Colormap, error) {
+	var stops []ColorStop
+	noData := color.RGBA{}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := splitColorLine(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing color", line)
+		}
+
+		c, err := parseColorFields(fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		valueField := strings.ToLower(fields[0])
+		if valueField == "nv" {
+			noData = c
+			continue
+		}
+
+		value, err := parseReliefValue(valueField)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		stops = append(stops, ColorStop{Value: value, Color: c})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cmap, err := NewColormap(name, stops)
+	if err != nil {
+		return nil, err
+	}
+	cmap.NoData = noData
+	return cmap, nil
+}
+
+// parseReliefValue parses a color-relief value, resolving percentages
+func parseReliefValue(field string) (float64, error) {
+	if strings.HasSuffix(field, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(field, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", field)
+		}
+		return percentDomainMin + percent/100*(percentDomainMax-percentDomainMin), nil
+	}
+
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", field)
+	}
+	return value, nil
+}
+
+// ParseGMTPalette parses a GMT color palette table (.cpt) in the RGB color model
+// Each line defines a segment "z0 color0 z1 color1" where colors are R G B, R/G/B,
+// #rrggbb or a color name; the N line sets the no-data color and B/F lines are ignored
+func ParseGMTPalette(r io.Reader, name string) (*Colormap, error) {
+	var stops []ColorStop
+	noData := color.RGBA{}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			if model := strings.ToUpper(strings.ReplaceAll(text, " ", "")); strings.HasPrefix(model, "#COLOR_MODEL=") && !strings.HasSuffix(model, "RGB") {
+				return nil, fmt.Errorf("line %d: only the RGB color model is supported", line)
+			}
+			continue
+		}
+
+		// Annotation flags after a semicolon are not needed for coloring
+		if idx := strings.Index(text, ";"); idx >= 0 {
+			text = text[:idx]
+		}
+		fields := expandSlashColors(splitColorLine(text))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "B", "F":
+			continue
+		case "N":
+			c, err := parseColorFields(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			noData = c
+			continue
+		}
+
+		z0, c0, rest, err := parseCPTStop(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		z1, c1, rest, err := parseCPTStop(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(rest) > 1 {
+			return nil, fmt.Errorf("line %d: unexpected fields %v", line, rest)
+		}
+
+		stops = append(stops, ColorStop{Value: z0, Color: c0}, ColorStop{Value: z1, Color: c1})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cmap, err := NewColormap(name, stops)
+	if err != nil {
+		return nil, err
+	}
+	cmap.NoData = noData
+	return cmap, nil
+}
+
+// parseCPTStop parses a value and its color from the start of fields
+func parseCPTStop(fields []string) (float64, color.RGBA, []string, error) {
+	if len(fields) < 2 {
+		return 0, color.RGBA{}, nil, fmt.Errorf("incomplete segment")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, color.RGBA{}, nil, fmt.Errorf("invalid value %q", fields[0])
+	}
+
+	// A color is either three numeric components or a single name/hex token
+	n := 1
+	if len(fields) >= 4 {
+		if _, err := strconv.Atoi(fields[1]); err == nil {
+			n = 3
+		}
+	}
+	c, err := parseColorFields(fields[1 : 1+n])
+	if err != nil {
+		return 0, color.RGBA{}, nil, err
+	}
+	return value, c, fields[1+n:], nil
+}
+
+// expandSlashColors splits R/G/B tokens into separate fields
+func expandSlashColors(fields []string) []string {
+	expanded := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if strings.Count(f, "/") == 2 {
+			expanded = append(expanded, strings.Split(f, "/")...)
+		} else {
+			expanded = append(expanded, f)
+		}
+	}
+	return expanded
+}
+
+// qgisStyle covers QGIS style exports (<colorramp>) and raster layer styles (<colorrampshader>)
+type qgisStyle struct {
+	Ramps   []qgisColorRamp `xml:"colorramps>colorramp"`
+	Ramp    []qgisColorRamp `xml:"colorramp"`
+	Shaders []qgisShader    `xml:",any"`
+}
+
+// qgisColorRamp is a gradient color ramp with legacy <prop> or newer <Option> properties
+type qgisColorRamp struct {
+	Name    string       `xml:"name,attr"`
+	Type    string       `xml:"type,attr"`
+	Props   []qgisProp   `xml:"prop"`
+	Options []qgisOption `xml:"Option>Option"`
+}
+
+type qgisProp struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type qgisOption struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// qgisShader is any element that may contain a <colorrampshader> deeper in the tree
+type qgisShader struct {
+	XMLName xml.Name
+	Items   []qgisShaderItem `xml:"item"`
+	Nested  []qgisShader     `xml:",any"`
+}
+
+type qgisShaderItem struct {
+	Value string `xml:"value,attr"`
+	Color string `xml:"color,attr"`
+	Alpha string `xml:"alpha,attr"`
+}
+
+// ParseQGISColorRamp parses a QGIS color ramp XML export or a raster style (.qml)
+// Gradient ramps are stretched over the NDVI domain; color ramp shader items keep their values
+func ParseQGISColorRamp(r io.Reader, name string) (*Colormap, error) {
+	var style qgisStyle
+	if err := xml.NewDecoder(r).Decode(&style); err != nil {
+		return nil, fmt.Errorf("invalid QGIS XML: %v", err)
+	}
+
+	// Raster styles list absolute values in a color ramp shader
+	for _, shader := range style.Shaders {
+		if items := findShaderItems(shader); len(items) > 0 {
+			return qgisShaderColormap(items, name)
+		}
+	}
+
+	ramps := append(style.Ramps, style.Ramp...)
+	if len(ramps) == 0 {
+		return nil, fmt.Errorf("no color ramp found")
+	}
+	ramp := ramps[0]
+	if ramp.Name != "" {
+		name = ramp.Name
+	}
+
+	props := make(map[string]string)
+	for _, p := range ramp.Props {
+		props[p.Key] = p.Value
+	}
+	for _, o := range ramp.Options {
+		props[o.Name] = o.Value
+	}
+
+	color1, err := parseQGISColor(props["color1"])
+	if err != nil {
+		return nil, fmt.Errorf("color1: %v", err)
+	}
+	color2, err := parseQGISColor(props["color2"])
+	if err != nil {
+		return nil, fmt.Errorf("color2: %v", err)
+	}
+
+	stops := []ColorStop{{Value: 0, Color: color1}, {Value: 1, Color: color2}}
+	if props["stops"] != "" {
+		for _, stop := range splitQGISStops(props["stops"]) {
+			parts := strings.SplitN(stop, ";", 3)
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("invalid stop %q", stop)
+			}
+			offset, err := strconv.ParseFloat(parts[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stop offset %q", parts[0])
+			}
+			c, err := parseQGISColor(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("stop %q: %v", stop, err)
+			}
+			stops = append(stops, ColorStop{Value: offset, Color: c})
+		}
+	}
+
+	cmap, err := NewColormap(name, stops)
+	if err != nil {
+		return nil, err
+	}
+	return cmap.Rescale(percentDomainMin, percentDomainMax), nil
+}
+
+// qgisStopStart matches the colon before the offset and semicolon of a gradient stop
+var qgisStopStart = regexp.MustCompile(`:[-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?;`)
+
+// splitQGISStops splits the stops property of a QGIS gradient ramp into "offset;color"
+// stops. Colors of QGIS 3.28 and later, such as "215,25,28,255,rgb:0.84,0.1,0.11,1",
+// contain colons themselves, so only colons starting an offset separate stops
+func splitQGISStops(stops string) []string {
+	var split []string
+	start := 0
+	for _, m := range qgisStopStart.FindAllStringIndex(stops, -1) {
+		split = append(split, stops[start:m[0]])
+		start = m[0] + 1
+	}
+	return append(split, stops[start:])
+}
+
+// findShaderItems returns the items of the first color ramp shader below shader
+func findShaderItems(shader qgisShader) []qgisShaderItem {
+	if strings.EqualFold(shader.XMLName.Local, "colorrampshader") && len(shader.Items) > 0 {
+		return shader.Items
+	}
+	for _, nested := range shader.Nested {
+		if items := findShaderItems(nested); len(items) > 0 {
+			return items
+		}
+	}
+	return nil
+}
+
+// qgisShaderColormap converts color ramp shader items into a colormap
+func qgisShaderColormap(items []qgisShaderItem, name string) (*Colormap, error) {
+	stops := make([]ColorStop, 0, len(items))
+	for _, item := range items {
+		value, err := strconv.ParseFloat(item.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shader value %q", item.Value)
+		}
+		c, err := parseHexColor(item.Color)
+		if err != nil {
+			return nil, err
+		}
+		if item.Alpha != "" {
+			alpha, err := strconv.Atoi(item.Alpha)
+			if err != nil || alpha < 0 || alpha > 255 {
+				return nil, fmt.Errorf("invalid shader alpha %q", item.Alpha)
+			}
+			c.A = uint8(alpha)
+		}
+		stops = append(stops, ColorStop{Value: value, Color: c})
+	}
+	return NewColormap(name, stops)
+}
+
+// parseQGISColor parses a QGIS "r,g,b,a" color, ignoring any trailing color space suffix
+func parseQGISColor(s string) (color.RGBA, error) {
+	if s == "" {
+		return color.RGBA{}, fmt.Errorf("missing color")
+	}
+	if strings.HasPrefix(s, "#") {
+		return parseHexColor(s)
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) < 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	if len(parts) > 4 {
+		parts = parts[:4]
+	}
+	return parseColorFields(parts)
+}
+
+// splitColorLine splits a line on spaces, tabs, commas and colons
+func splitColorLine(line string) []string {
+	return strings.FieldsFunc(strings.TrimSpace(line), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ',' || r == ':'
+	})
+}
+
+// parseColorFields parses R G B [A] components, a #rrggbb[aa] hex color or a color name
+func parseColorFields(fields []string) (color.RGBA, error) {
+	if len(fields) == 1 {
+		if c, ok := namedColors[strings.ToLower(fields[0])]; ok {
+			return c, nil
+		}
+		if strings.HasPrefix(fields[0], "#") {
+			return parseHexColor(fields[0])
+		}
+		return color.RGBA{}, fmt.Errorf("unknown color %q", fields[0])
+	}
+	if len(fields) < 3 {
+		return color.RGBA{}, fmt.Errorf("incomplete color %v", fields)
+	}
+
+	var comps [4]uint8
+	comps[3] = 255
+	for i := 0; i < len(fields) && i < 4; i++ {
+		v, err := strconv.Atoi(strings.TrimSpace(fields[i]))
+		if err != nil || v < 0 || v > 255 {
+			return color.RGBA{}, fmt.Errorf("invalid color component %q", fields[i])
+		}
+		comps[i] = uint8(v)
+	}
+	return color.RGBA{comps[0], comps[1], comps[2], comps[3]}, nil
+}
+
+// parseHexColor parses a #rrggbb or #rrggbbaa color
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
@@ -4,11 +4,10 @@ import (
 	"image/color"
 )
 
+// Built-in colormap stops, all defined over the NDVI domain [-1, 1]
+
 // NDVI gradient color points for visualization
-var ndviGradientPoints = []struct {
-	Value float64
-	Color color.RGBA
-}{
+var ndviGradientPoints = []ColorStop{
 	{-1.0, color.RGBA{0, 0, 128, 255}},    // Dark blue (water/shadows)
 	{-0.2, color.RGBA{65, 105, 225, 255}}, // Medium blue
 	{0.0, color.RGBA{255, 0, 0, 255}},     // Red (soil/urban areas)
@@ -16,40 +15,80 @@ var ndviGradientPoints = []struct {
 	{1.0, color.RGBA{0, 128, 0, 255}},     // Green (dense vegetation)
 }
 
-// GetNDVIColor returns a color for the given NDVI value using optimized gradient lookup
-func GetNDVIColor(ndviValue float64) color.RGBA {
-	if ndviValue <= -1.0 {
-		return ndviGradientPoints[0].Color
-	}
-	if ndviValue >= 1.0 {
-		return ndviGradientPoints[len(ndviGradientPoints)-1].Color
-	}
-
-	// Binary search for the index
-	var idx int
-	lo, hi := 0, len(ndviGradientPoints)-1
-	for lo <= hi {
-		mid := (lo + hi) / 2
-		if ndviGradientPoints[mid].Value > ndviValue {
-			hi = mid - 1
-		} else {
-			lo = mid + 1
-			idx = mid
-		}
-	}
-
-	// Special case for the last point
-	if idx >= len(ndviGradientPoints)-1 {
-		return ndviGradientPoints[len(ndviGradientPoints)-1].Color
-	}
-
-	// Efficient interpolation
-	p1, p2 := ndviGradientPoints[idx], ndviGradientPoints[idx+1]
-	t := (ndviValue - p1.Value) / (p2.Value - p1.Value)
-
-	r := uint8(float64(p1.Color.R) + t*float64(p2.Color.R-p1.Color.R))
-	g := uint8(float64(p1.Color.G) + t*float64(p2.Color.G-p1.Color.G))
-	b := uint8(float64(p1.Color.B) + t*float64(p2.Color.B-p1.Color.B))
-
-	return color.RGBA{r, g, b, 255}
+// NDVI change gradient color points (diverging around no change)
+var changeGradientPoints = []ColorStop{
+	{-1.0, color.RGBA{103, 0, 13, 255}},   // Dark red (strong loss)
+	{-0.3, color.RGBA{239, 59, 44, 255}},  // Red (loss)
+	{0.0, color.RGBA{247, 247, 247, 255}}, // Light gray (no change)
+	{0.3, color.RGBA{65, 171, 93, 255}},   // Green (gain)
+	{1.0, color.RGBA{0, 68, 27, 255}},     // Dark green (strong gain)
+}
+
+// NDVI anomaly gradient color points, z-scores of [-3, 3] scaled onto [-1, 1]
+var anomalyGradientPoints = []ColorStop{
+	{-1.0, color.RGBA{84, 48, 5, 255}},    // Dark brown (severe stress)
+	{-0.5, color.RGBA{191, 129, 45, 255}}, // Brown (stress)
+	{0.0, color.RGBA{245, 245, 245, 255}}, // Light gray (normal)
+	{0.5, color.RGBA{53, 151, 143, 255}},  // Teal (above normal)
+	{1.0, color.RGBA{0, 60, 48, 255}},     // Dark teal (well above normal)
+}
+
+// Viridis perceptually uniform gradient
+var viridisGradientPoints = []ColorStop{
+	{-1.00, color.RGBA{68, 1, 84, 255}},
+	{-0.75, color.RGBA{71, 44, 122, 255}},
+	{-0.50, color.RGBA{59, 81, 139, 255}},
+	{-0.25, color.RGBA{44, 113, 142, 255}},
+	{0.00, color.RGBA{33, 144, 141, 255}},
+	{0.25, color.RGBA{39, 173, 129, 255}},
+	{0.50, color.RGBA{92, 200, 99, 255}},
+	{0.75, color.RGBA{170, 220, 50, 255}},
+	{1.00, color.RGBA{253, 231, 37, 255}},
+}
+
+// ColorBrewer RdYlGn diverging gradient (red for loss, green for gain)
+var rdYlGnGradientPoints = []ColorStop{
+	{-1.0, color.RGBA{165, 0, 38, 255}},
+	{-0.8, color.RGBA{215, 48, 39, 255}},
+	{-0.6, color.RGBA{244, 109, 67, 255}},
+	{-0.4, color.RGBA{253, 174, 97, 255}},
+	{-0.2, color.RGBA{254, 224, 139, 255}},
+	{0.0, color.RGBA{255, 255, 191, 255}},
+	{0.2, color.RGBA{217, 239, 139, 255}},
+	{0.4, color.RGBA{166, 217, 106, 255}},
+	{0.6, color.RGBA{102, 189, 99, 255}},
+	{0.8, color.RGBA{26, 152, 80, 255}},
+	{1.0, color.RGBA{0, 104, 55, 255}},
+}
+
+// ColorBrewer BrBG diverging gradient (brown for stress, teal for vigor)
+var brBGGradientPoints = []ColorStop{
+	{-1.0, color.RGBA{84, 48, 5, 255}},
+	{-0.8, color.RGBA{140, 81, 10, 255}},
+	{-0.6, color.RGBA{191, 129, 45, 255}},
+	{-0.4, color.RGBA{223, 194, 125, 255}},
+	{-0.2, color.RGBA{246, 232, 195, 255}},
+	{0.0, color.RGBA{245, 245, 245, 255}},
+	{0.2, color.RGBA{199, 234, 229, 255}},
+	{0.4, color.RGBA{128, 205, 193, 255}},
+	{0.6, color.RGBA{53, 151, 143, 255}},
+	{0.8, color.RGBA{1, 102, 94, 255}},
+	{1.0, color.RGBA{0, 60, 48, 255}},
+}
+
+// Linear grayscale gradient
+var grayscaleGradientPoints = []ColorStop{
+	{-1.0, color.RGBA{0, 0, 0, 255}},
+	{1.0, color.RGBA{255, 255, 255, 255}},
+}
+
+// builtinColormaps are the colormaps available by name
+var builtinColormaps = map[string][]ColorStop{
+	"ndvi":      ndviGradientPoints,
+	"change":    changeGradientPoints,
+	"anomaly":   anomalyGradientPoints,
+	"viridis":   viridisGradientPoints,
+	"rdylgn":    rdYlGnGradientPoints,
+	"brbg":      brBGGradientPoints,
+	"grayscale": grayscaleGradientPoints,
 }
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"image/color"
+)
+
+// ColorLUT is a colormap sampled at evenly spaced values over its domain so that
+// colorizing a pixel is a quantization and a copy instead of a search and interpolation
+type ColorLUT struct {
+	Min, Max float64
+	Scale    float64 // Entries per unit of value, (size-1)/(Max-Min)
+	Pix      []uint8 // Packed RGBA entries, 4 bytes each
+	NoData   color.RGBA
+}
+
+// NewColorLUT samples the colormap at size evenly spaced values over its domain
+func NewColorLUT(cmap *Colormap, size int) (*ColorLUT, error) {
+	if size < 2 {
+		return nil, fmt.Errorf("lookup table needs at least 2 entries, got %d", size)
+	}
+
+	lo, hi := cmap.Domain()
+	if hi <= lo {
+		return nil, fmt.Errorf("colormap %s has an empty domain", cmap.Name)
+	}
+
+	lut := &ColorLUT{
+		Min:    lo,
+		Max:    hi,
+		Scale:  float64(size-1) / (hi - lo),
+		Pix:    make([]uint8, size*4),
+		NoData: cmap.NoData,
+	}
+	for k := 0; k < size; k++ {
+		c := cmap.Color(lo + float64(k)/lut.Scale)
+		lut.Pix[k*4] = c.R
+		lut.Pix[k*4+1] = c.G
+		lut.Pix[k*4+2] = c.B
+		lut.Pix[k*4+3] = c.A
+	}
+
+	return lut, nil
+}
+
+// Size returns the number of entries of the table
+func (l *ColorLUT) Size() int {
+	return len(l.Pix) / 4
+}
+
+// Index returns the entry nearest to value, clamping to the table ends
+func (l *ColorLUT) Index(value float64) int {
+	if value <= l.Min {
+		return 0
+	}
+	if value >= l.Max {
+		return l.Size() - 1
+	}
+	return int((value-l.Min)*l.Scale + 0.5)
+}
+
+// Palette returns the entries of the table as a palette
+func (l *ColorLUT) Palette() color.Palette {
+	palette := make(color.Palette, l.Size())
+	for k := range palette {
+		palette[k] = color.RGBA{l.Pix[k*4], l.Pix[k*4+1], l.Pix[k*4+2], l.Pix[k*4+3]}
+	}
+	return palette
+}
+
+// Color returns the color of the entry nearest to value
+func (l *ColorLUT) Color(value float64) color.RGBA {
+	k := l.Index(value) * 4
+	return color.RGBA{l.Pix[k], l.Pix[k+1], l.Pix[k+2], l.Pix[k+3]}
+}
@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Georeference places a raster grid in a coordinate reference system through an
+// affine geotransform, with the same layout as GDAL's:
+//
+//	x = GeoTransform[0] + col*GeoTransform[1] + row*GeoTransform[2]
+//	y = GeoTransform[3] + col*GeoTransform[4] + row*GeoTransform[5]
+//
+// where (col, row) = (0, 0) is the top-left corner of the top-left pixel
+type Georeference struct {
+	EPSG         int // EPSG code of the coordinate reference system, 0 when unknown
+	GeoTransform [6]float64
+}
+
+// NewGeoreference creates a north-up georeference from the top-left corner of the
+// grid and the pixel size; pixelHeight is negative when rows go south
+func NewGeoreference(epsg int, originX, originY, pixelWidth, pixelHeight float64) *Georeference {
+	return &Georeference{
+		EPSG:         epsg,
+		GeoTransform: [6]float64{originX, pixelWidth, 0, originY, 0, pixelHeight},
+	}
+}
+
+// ParseGeoreference parses a north-up georeference given as
+// "EPSG,originX,originY,pixelSize[,pixelHeight]", where the origin is the top-left
+// corner and the pixel height defaults to minus the pixel size
+func ParseGeoreference(s string) (*Georeference, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 && len(fields) != 5 {
+		return nil, fmt.Errorf("invalid georeference %q: want EPSG,originX,originY,pixelSize[,pixelHeight]", s)
+	}
+
+	code := strings.TrimSpace(fields[0])
+	if i := strings.LastIndex(code, ":"); i >= 0 {
+		code = code[i+1:]
+	}
+	epsg, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EPSG code %q", fields[0])
+	}
+
+	values := make([]float64, len(fields)-1)
+	for i, field := range fields[1:] {
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return nil, fmt.Errorf("invalid georeference value %q", field)
+		}
+	}
+	pixelHeight := -values[2]
+	if len(values) == 4 {
+		pixelHeight = values[3]
+	}
+	if values[2] == 0 || pixelHeight == 0 {
+		return nil, fmt.Errorf("invalid georeference %q: zero pixel size", s)
+	}
+	return NewGeoreference(epsg, values[0], values[1], values[2], pixelHeight), nil
+}
+
+// IsGeographic reports whether an EPSG code is a geographic (longitude/latitude)
+// coordinate reference system; codes 4000-4999 are treated as geographic
+func IsGeographic(epsg int) bool {
+	return epsg >= 4000 && epsg < 5000
+}
+
+// IsGeographic reports whether the georeference is in longitude and latitude
+func (g *Georeference) IsGeographic() bool {
+	return IsGeographic(g.EPSG)
+}
+
+// IsNorthUp reports whether the grid has no rotation terms
+func (g *Georeference) IsNorthUp() bool {
+	return g.GeoTransform[2] == 0 && g.GeoTransform[4] == 0
+}
+
+// PixelSize returns the pixel width and height in CRS units, the height being
+// negative when rows go south
+func (g *Georeference) PixelSize() (float64, float64) {
+	return g.GeoTransform[1], g.GeoTransform[5]
+}
+
+// PixelToWorld converts grid coordinates, which may be fractional, to CRS coordinates
+func (g *Georeference) PixelToWorld(col, row float64) (float64, float64) {
+	t := g.GeoTransform
+	return t[0] + col*t[1] + row*t[2], t[3] + col*t[4] + row*t[5]
+}
+
+// WorldToPixel converts CRS coordinates to fractional grid coordinates
+func (g *Georeference) WorldToPixel(x, y float64) (float64, float64, error) {
+	t := g.GeoTransform
+	det := t[1]*t[5] - t[2]*t[4]
+	if det == 0 {
+		return 0, 0, fmt.Errorf("degenerate geotransform %v", t)
+	}
+	dx, dy := x-t[0], y-t[3]
+	return (dx*t[5] - dy*t[2]) / det, (dy*t[1] - dx*t[4]) / det, nil
+}
+
+// Bounds returns the extent in CRS coordinates of a width x height grid
+func (g *Georeference) Bounds(width, height int) (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, corner := range [4][2]float64{{0, 0}, {float64(width), 0}, {0, float64(height)}, {float64(width), float64(height)}} {
+		x, y := g.PixelToWorld(corner[0], corner[1])
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return minX, minY, maxX, maxY
+}
+
+// Scaled returns the georeference of the same area sampled with pixels factor
+// times larger, as used by overviews
+func (g *Georeference) Scaled(factor float64) *Georeference {
+	scaled := *g
+	for _, i := range []int{1, 2, 4, 5} {
+		scaled.GeoTransform[i] *= factor
+	}
+	return &scaled
+}
+
+// Resized returns the georeference of the same area resampled from a width x height
+// grid to a newWidth x newHeight grid
+func (g *Georeference) Resized(width, height, newWidth, newHeight int) *Georeference {
+	resized := *g
+	scaleX := float64(width) / float64(newWidth)
+	scaleY := float64(height) / float64(newHeight)
+	resized.GeoTransform[1] *= scaleX
+	resized.GeoTransform[4] *= scaleX
+	resized.GeoTransform[2] *= scaleY
+	resized.GeoTransform[5] *= scaleY
+	return &resized
+}
+
+// String returns the CRS, origin and pixel size
+func (g *Georeference) String() string {
+	crs := "unknown CRS"
+	if g.EPSG != 0 {
+		crs = fmt.Sprintf("EPSG:%d", g.EPSG)
+	}
+	return fmt.Sprintf("%s, origin (%g, %g), pixel size (%g, %g)", crs, g.GeoTransform[0], g.GeoTransform[3], g.GeoTransform[1], g.GeoTransform[5])
+}
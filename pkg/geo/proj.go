@@ -0,0 +1,177 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+)
+
+// EPSG codes used directly by the exporters
+const (
+	EPSGWGS84       = 4326
+	EPSGWebMercator = 3857
+)
+
+// Web Mercator sphere radius and latitude limit in degrees
+const (
+	webMercatorR      = 6378137.0
+	webMercatorMaxLat = 85.0511287798066
+)
+
+// Ellipsoid parameters
+const (
+	wgs84A    = 6378137.0
+	wgs84InvF = 298.257223563
+	grs80InvF = 298.257222101
+)
+
+// Projection converts between geographic coordinates in degrees and projected coordinates
+// Datum shifts are ignored, which is below a meter between WGS84, ETRS89 and NAD83
+type Projection interface {
+	Forward(lon, lat float64) (x, y float64)
+	Inverse(x, y float64) (lon, lat float64)
+}
+
+// ProjectionFor returns the projection of an EPSG code: geographic CRS (4000-4999),
+// Web Mercator (3857), and UTM zones on WGS84 (326zz north, 327zz south), ETRS89
+// (258zz) and NAD83 (269zz)
+func ProjectionFor(epsg int) (Projection, error) {
+	if zone, south, ok := UTMZone(epsg); ok {
+		invF := grs80InvF
+		if epsg > 32600 {
+			invF = wgs84InvF
+		}
+		return newUTM(zone, south, invF), nil
+	}
+
+	switch {
+	case IsGeographic(epsg):
+		return geographic{}, nil
+	case epsg == EPSGWebMercator || epsg == 900913:
+		return webMercator{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported coordinate reference system EPSG:%d", epsg)
+	}
+}
+
+// UTMZone returns the zone and hemisphere of a UTM EPSG code on WGS84 (326zz north,
+// 327zz south), ETRS89 (258zz) or NAD83 (269zz), and whether the code is one of them
+func UTMZone(epsg int) (int, bool, bool) {
+	switch {
+	case epsg > 32600 && epsg <= 32660:
+		return epsg - 32600, false, true
+	case epsg > 32700 && epsg <= 32760:
+		return epsg - 32700, true, true
+	case epsg >= 25828 && epsg <= 25838:
+		return epsg - 25800, false, true
+	case epsg >= 26901 && epsg <= 26923:
+		return epsg - 26900, false, true
+	default:
+		return 0, false, false
+	}
+}
+
+// Transform converts coordinates from one EPSG coordinate reference system to another
+func Transform(fromEPSG, toEPSG int, x, y float64) (float64, float64, error) {
+	from, err := ProjectionFor(fromEPSG)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := ProjectionFor(toEPSG)
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, lat := from.Inverse(x, y)
+	tx, ty := to.Forward(lon, lat)
+	return tx, ty, nil
+}
+
+// geographic is the identity projection of longitude and latitude
+type geographic struct{}
+
+func (geographic) Forward(lon, lat float64) (float64, float64) { return lon, lat }
+func (geographic) Inverse(x, y float64) (float64, float64)     { return x, y }
+
+// webMercator is the spherical Mercator projection of web maps, clamped at the
+// latitudes where the world becomes square
+type webMercator struct{}
+
+func (webMercator) Forward(lon, lat float64) (float64, float64) {
+	lat = math.Max(-webMercatorMaxLat, math.Min(webMercatorMaxLat, lat))
+	return webMercatorR * lon * math.Pi / 180,
+		webMercatorR * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+}
+
+func (webMercator) Inverse(x, y float64) (float64, float64) {
+	return x / webMercatorR * 180 / math.Pi,
+		(2*math.Atan(math.Exp(y/webMercatorR)) - math.Pi/2) * 180 / math.Pi
+}
+
+// transverseMercator implements the ellipsoidal transverse Mercator projection with
+// the Krüger series to third order in n, accurate to about a millimeter in a UTM zone
+type transverseMercator struct {
+	e                float64 // First eccentricity
+	lon0             float64 // Central meridian in radians
+	k0A              float64 // Scale factor times the rectifying radius
+	falseEasting     float64
+	falseNorthing    float64
+	alpha, beta, del [3]float64
+}
+
+// newUTM returns the projection of a UTM zone
+func newUTM(zone int, south bool, invF float64) *transverseMercator {
+	f := 1 / invF
+	n := f / (2 - f)
+	n2, n3 := n*n, n*n*n
+
+	tm := &transverseMercator{
+		e:            math.Sqrt(f * (2 - f)),
+		lon0:         float64(zone*6-183) * math.Pi / 180,
+		k0A:          0.9996 * wgs84A / (1 + n) * (1 + n2/4 + n2*n2/64),
+		falseEasting: 500000,
+		alpha:        [3]float64{n/2 - 2*n2/3 + 5*n3/16, 13*n2/48 - 3*n3/5, 61 * n3 / 240},
+		beta:         [3]float64{n/2 - 2*n2/3 + 37*n3/96, n2/48 + n3/15, 17 * n3 / 480},
+		del:          [3]float64{2*n - 2*n2/3 - 2*n3, 7*n2/3 - 8*n3/5, 56 * n3 / 15},
+	}
+	if south {
+		tm.falseNorthing = 10000000
+	}
+	return tm
+}
+
+func (tm *transverseMercator) Forward(lon, lat float64) (float64, float64) {
+	phi := lat * math.Pi / 180
+	dLon := lon*math.Pi/180 - tm.lon0
+
+	sinPhi := math.Sin(phi)
+	t := math.Sinh(math.Atanh(sinPhi) - tm.e*math.Atanh(tm.e*sinPhi))
+	xi := math.Atan2(t, math.Cos(dLon))
+	eta := math.Atanh(math.Sin(dLon) / math.Sqrt(1+t*t))
+
+	x, y := eta, xi
+	for j, a := range tm.alpha {
+		k := 2 * float64(j+1)
+		x += a * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += a * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	return tm.falseEasting + tm.k0A*x, tm.falseNorthing + tm.k0A*y
+}
+
+func (tm *transverseMercator) Inverse(x, y float64) (float64, float64) {
+	xi := (y - tm.falseNorthing) / tm.k0A
+	eta := (x - tm.falseEasting) / tm.k0A
+
+	xiP, etaP := xi, eta
+	for j, b := range tm.beta {
+		k := 2 * float64(j+1)
+		xiP -= b * math.Sin(k*xi) * math.Cosh(k*eta)
+		etaP -= b * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	chi := math.Asin(math.Sin(xiP) / math.Cosh(etaP))
+	phi := chi
+	for j, d := range tm.del {
+		phi += d * math.Sin(2*float64(j+1)*chi)
+	}
+	lon := tm.lon0 + math.Atan2(math.Sinh(etaP), math.Cos(xiP))
+	return lon * 180 / math.Pi, phi * 180 / math.Pi
+}
@@ -0,0 +1,119 @@
+package geo
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// warpGridStep is the spacing in destination pixels of the exactly reprojected points;
+// the source coordinates of the pixels in between are interpolated bilinearly
+const warpGridStep = 16
+
+// Warp fills dst, placed by dstGeoref, with the src pixels nearest to its pixel centers
+// and reports whether every pixel is transparent; pixels outside src are transparent
+// Source coordinates are computed exactly every 16 pixels and interpolated in between
+func Warp(dst *image.RGBA, dstGeoref *Georeference, src *image.RGBA, srcGeoref *Georeference) (bool, error) {
+	dstProj, err := ProjectionFor(dstGeoref.EPSG)
+	if err != nil {
+		return false, err
+	}
+	srcProj, err := ProjectionFor(srcGeoref.EPSG)
+	if err != nil {
+		return false, err
+	}
+
+	// Source pixel coordinates at the grid points
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	nx := (width+warpGridStep-1)/warpGridStep + 1
+	ny := (height+warpGridStep-1)/warpGridStep + 1
+	cols := make([]float64, nx*ny)
+	rows := make([]float64, nx*ny)
+	for gy := 0; gy < ny; gy++ {
+		for gx := 0; gx < nx; gx++ {
+			lon, lat := dstProj.Inverse(dstGeoref.PixelToWorld(float64(gx*warpGridStep), float64(gy*warpGridStep)))
+			col, row, err := srcGeoref.WorldToPixel(srcProj.Forward(lon, lat))
+			if err != nil {
+				return false, err
+			}
+			cols[gy*nx+gx], rows[gy*nx+gx] = col, row
+		}
+	}
+
+	srcBounds := src.Bounds()
+	srcWidth, srcHeight := srcBounds.Dx(), srcBounds.Dy()
+	empty := true
+	for y := 0; y < height; y++ {
+		gy := y / warpGridStep
+		fy := (float64(y) + 0.5 - float64(gy*warpGridStep)) / warpGridStep
+		out := dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			gx := x / warpGridStep
+			fx := (float64(x) + 0.5 - float64(gx*warpGridStep)) / warpGridStep
+			i := gy*nx + gx
+			col := bilinear(cols[i], cols[i+1], cols[i+nx], cols[i+nx+1], fx, fy)
+			row := bilinear(rows[i], rows[i+1], rows[i+nx], rows[i+nx+1], fx, fy)
+
+			p := out[x*4 : x*4+4]
+			c, r := int(math.Floor(col)), int(math.Floor(row))
+			if c < 0 || r < 0 || c >= srcWidth || r >= srcHeight {
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				continue
+			}
+			offset := src.PixOffset(srcBounds.Min.X+c, srcBounds.Min.Y+r)
+			copy(p, src.Pix[offset:offset+4])
+			if p[3] != 0 {
+				empty = false
+			}
+		}
+	}
+	return empty, nil
+}
+
+// WarpParallel warps src into dst like Warp, splitting dst into bands of rows
+// processed in parallel
+func WarpParallel(dst *image.RGBA, dstGeoref *Georeference, src *image.RGBA, srcGeoref *Georeference, numThreads int) error {
+	bounds := dst.Bounds()
+	height := bounds.Dy()
+
+	// Process bands of rows in parallel, aligned to the interpolation grid
+	numWorkers := numThreads
+	chunkSize := (height + numWorkers - 1) / numWorkers
+	chunkSize = (chunkSize + warpGridStep - 1) / warpGridStep * warpGridStep
+	errs := make([]error, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, height)
+		end := min(start+chunkSize, height)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			if start == end {
+				return
+			}
+			band := dst.SubImage(image.Rect(bounds.Min.X, bounds.Min.Y+start, bounds.Max.X, bounds.Min.Y+end)).(*image.RGBA)
+			bandGeoref := *dstGeoref
+			bandGeoref.GeoTransform[0], bandGeoref.GeoTransform[3] = dstGeoref.PixelToWorld(0, float64(start))
+			_, errs[worker] = Warp(band, &bandGeoref, src, srcGeoref)
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bilinear interpolates between the four corners of a grid cell
+func bilinear(v00, v10, v01, v11, fx, fy float64) float64 {
+	top := v00 + (v10-v00)*fx
+	bottom := v01 + (v11-v01)*fx
+	return top + (bottom-top)*fy
+}
@@ -0,0 +1,103 @@
+package geotiff
+
+// TIFF LZW codes
+const (
+	lzwClear     = 256
+	lzwEOI       = 257
+	lzwFirstCode = 258
+	lzwMaxCode   = 4095
+	lzwTableBits = 14
+	lzwTableMask = 1<<lzwTableBits - 1
+)
+
+// lzwEncoder packs codes most significant bit first
+type lzwEncoder struct {
+	out   []byte
+	bits  uint32 // Pending bits, left aligned
+	nBits uint
+	width uint
+	table [1 << lzwTableBits]uint32 // Open addressing hash of prefix<<8|byte keys to codes, 0 when empty
+}
+
+// compressLZW compresses data with the TIFF flavour of LZW, which differs from the
+// GIF one used by compress/lzw: codes are written most significant bit first and
+// widen one code early, as libtiff does
+func compressLZW(data []byte) []byte {
+	e := &lzwEncoder{width: 9, out: make([]byte, 0, len(data)/2+16)}
+	e.write(lzwClear)
+	if len(data) == 0 {
+		e.write(lzwEOI)
+		return e.flush()
+	}
+
+	next := uint32(lzwFirstCode)
+	maxCode := uint32(1<<9 - 1)
+	code := uint32(data[0])
+
+	for _, c := range data[1:] {
+		key := code<<8 | uint32(c)
+		h := (key>>12 ^ key) & lzwTableMask
+		found := false
+		for ; e.table[h] != 0; h = (h + 1) & lzwTableMask {
+			if e.table[h]>>12 == key {
+				code = e.table[h] & lzwMaxCode
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		// Emit the longest known string and add it extended by c
+		e.write(code)
+		e.table[h] = key<<12 | next
+		next++
+		if next == lzwMaxCode-1 {
+			e.write(lzwClear)
+			e.reset()
+			next = lzwFirstCode
+			maxCode = 1<<9 - 1
+		} else if next > maxCode {
+			e.width++
+			maxCode = 1<<e.width - 1
+		}
+		code = uint32(c)
+	}
+
+	e.write(code)
+	next++
+	if next == lzwMaxCode-1 {
+		e.write(lzwClear)
+		e.width = 9
+	} else if next > maxCode {
+		e.width++
+	}
+	e.write(lzwEOI)
+	return e.flush()
+}
+
+// write appends a code of the current width
+func (e *lzwEncoder) write(code uint32) {
+	e.bits |= code << (32 - e.width - e.nBits)
+	e.nBits += e.width
+	for e.nBits >= 8 {
+		e.out = append(e.out, byte(e.bits>>24))
+		e.bits <<= 8
+		e.nBits -= 8
+	}
+}
+
+// reset clears the string table and returns to 9-bit codes
+func (e *lzwEncoder) reset() {
+	clear(e.table[:])
+	e.width = 9
+}
+
+// flush writes the pending bits padded with zeros and returns the output
+func (e *lzwEncoder) flush() []byte {
+	if e.nBits > 0 {
+		e.out = append(e.out, byte(e.bits>>24))
+	}
+	return e.out
+}
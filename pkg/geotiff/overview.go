@@ -0,0 +1,101 @@
+package geotiff
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// combineFunc computes an overview pixel from the 1 to 4 source pixels it covers
+type combineFunc func(dst []byte, block [][]byte, fill []byte)
+
+// downsample halves a raster in both dimensions, combining each 2x2 block of pixels
+func downsample(src *raster, combine combineFunc, threads int) *raster {
+	dst := newRaster((src.width+1)/2, (src.height+1)/2, src.samples, src.sampleBytes, src.sampleFormat)
+	dst.fill = src.fill
+	pixelBytes := src.pixelBytes()
+
+	// Process rows in parallel
+	numWorkers := threads
+	chunkSize := (dst.height + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, dst.height)
+
+		go func(start, end int) {
+			defer wg.Done()
+			block := make([][]byte, 0, 4)
+			for y := start; y < end; y++ {
+				row := dst.row(y)
+				for x := 0; x < dst.width; x++ {
+					block = block[:0]
+					for sy := 2 * y; sy < min(2*y+2, src.height); sy++ {
+						srcRow := src.row(sy)
+						for sx := 2 * x; sx < min(2*x+2, src.width); sx++ {
+							block = append(block, srcRow[sx*pixelBytes:(sx+1)*pixelBytes])
+						}
+					}
+					combine(row[x*pixelBytes:(x+1)*pixelBytes], block, src.fill)
+				}
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return dst
+}
+
+// averageUint8 averages every 8-bit sample, rounding to nearest
+func averageUint8(dst []byte, block [][]byte, fill []byte) {
+	for s := range dst {
+		sum := 0
+		for _, p := range block {
+			sum += int(p[s])
+		}
+		dst[s] = byte((sum + len(block)/2) / len(block))
+	}
+}
+
+// nearest keeps the top-left pixel of the block, for palette indices and classes
+func nearest(dst []byte, block [][]byte, fill []byte) {
+	copy(dst, block[0])
+}
+
+// averageInt16 returns a combiner averaging the int16 samples that are not noData
+func averageInt16(noData int16) combineFunc {
+	return func(dst []byte, block [][]byte, fill []byte) {
+		sum, n := 0, 0
+		for _, p := range block {
+			if v := int16(binary.LittleEndian.Uint16(p)); v != noData {
+				sum += int(v)
+				n++
+			}
+		}
+		if n == 0 {
+			copy(dst, fill)
+			return
+		}
+		binary.LittleEndian.PutUint16(dst, uint16(int16(math.Round(float64(sum)/float64(n)))))
+	}
+}
+
+// averageFloat32 averages the float32 samples that are not NaN
+func averageFloat32(dst []byte, block [][]byte, fill []byte) {
+	sum, n := 0.0, 0
+	for _, p := range block {
+		if v := math.Float32frombits(binary.LittleEndian.Uint32(p)); !math.IsNaN(float64(v)) {
+			sum += float64(v)
+			n++
+		}
+	}
+	if n == 0 {
+		copy(dst, fill)
+		return
+	}
+	binary.LittleEndian.PutUint32(dst, math.Float32bits(float32(sum/float64(n))))
+}
@@ -0,0 +1,492 @@
+package geotiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+	"github.com/luismi/jp2_processing/pkg/output"
+)
+
+// TIFF tags
+const (
+	tagNewSubfileType          = 254
+	tagImageWidth              = 256
+	tagImageLength             = 257
+	tagBitsPerSample           = 258
+	tagCompression             = 259
+	tagPhotometric             = 262
+	tagSamplesPerPixel         = 277
+	tagPlanarConfiguration     = 284
+	tagPredictor               = 317
+	tagColorMap                = 320
+	tagTileWidth               = 322
+	tagTileLength              = 323
+	tagTileOffsets             = 324
+	tagTileByteCounts          = 325
+	tagExtraSamples            = 338
+	tagSampleFormat            = 339
+	tagModelPixelScale         = 33550
+	tagModelTiepoint           = 33922
+	tagModelTransformation     = 34264
+	tagGeoKeyDirectory         = 34735
+	tagGeoASCIIParams          = 34737
+	tagGDALMetadata            = 42112
+	tagGDALNoData              = 42113
+	subfileReducedImage        = 1
+	photometricMinIsBlack      = 1
+	photometricRGB             = 2
+	photometricPalette         = 3
+	extraSampleAssociatedAlpha = 1
+	sampleFormatUint           = 1
+	sampleFormatInt            = 2
+	sampleFormatFloat          = 3
+	predictorHorizontal        = 2
+	compressionTagNone         = 1
+	compressionTagLZW          = 5
+	compressionTagDeflate      = 8
+	planarContig               = 1
+	keyModelType               = 1024
+	keyRasterType              = 1025
+	keyGeographicType          = 2048
+	keyGeogCitation            = 2049
+	keyProjectedCSType         = 3072
+	keyPCSCitation             = 3073
+	crsUserDefined             = 32767
+	modelTypeProjected         = 1
+	modelTypeGeographic        = 2
+	rasterPixelIsArea          = 1
+)
+
+// TIFF field types
+const (
+	typeASCII  uint16 = 2
+	typeShort  uint16 = 3
+	typeLong   uint16 = 4
+	typeDouble uint16 = 12
+)
+
+// raster holds pixel-interleaved little-endian samples
+type raster struct {
+	width, height int
+	samples       int    // Samples per pixel
+	sampleBytes   int    // Bytes per sample: 1, 2 or 4
+	sampleFormat  uint16 // TIFF SampleFormat
+	pix           []byte
+	fill          []byte // One pixel used for the padding of edge tiles and empty overview pixels
+}
+
+// newRaster allocates a raster filled with zeros
+func newRaster(width, height, samples, sampleBytes int, sampleFormat uint16) *raster {
+	return &raster{
+		width:        width,
+		height:       height,
+		samples:      samples,
+		sampleBytes:  sampleBytes,
+		sampleFormat: sampleFormat,
+		pix:          make([]byte, width*height*samples*sampleBytes),
+		fill:         make([]byte, samples*sampleBytes),
+	}
+}
+
+// pixelBytes returns the size of a pixel in bytes
+func (r *raster) pixelBytes() int {
+	return r.samples * r.sampleBytes
+}
+
+// row returns the samples of row y
+func (r *raster) row(y int) []byte {
+	stride := r.width * r.pixelBytes()
+	return r.pix[y*stride : (y+1)*stride]
+}
+
+// layout holds the tags that depend on the kind of image and how overviews are made
+type layout struct {
+	photometric  uint16
+	extraSamples []uint16
+	colorMap     []uint16
+	noData       string // GDAL_NODATA, empty for none
+	metadata     string // GDAL_METADATA, empty for none
+	combine      combineFunc
+}
+
+// field is a TIFF directory entry with its little-endian value
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// writeRaster writes a raster and its overviews as a Cloud Optimized GeoTIFF: all
+// image directories follow the header, then the tiles of each image from the
+// smallest overview to the full resolution image
+// The time since startSave, spent building the raster, is reported as copy time
+func (w *Writer) writeRaster(r *raster, l layout, outputPath string, startSave time.Time, threads int) (*metrics.WriteMetrics, error) {
+	writeMetrics := &metrics.WriteMetrics{CopyTime: time.Since(startSave)}
+	startEncode := time.Now()
+
+	// Full resolution image followed by overviews halving its size down to one tile
+	levels := []*raster{r}
+	for w.opts.Overviews {
+		last := levels[len(levels)-1]
+		if last.width <= w.opts.TileSize && last.height <= w.opts.TileSize {
+			break
+		}
+		levels = append(levels, downsample(last, l.combine, threads))
+	}
+
+	tiles := w.encodeTiles(levels, l, threads)
+	writeMetrics.EncodeTime = time.Since(startEncode)
+	startFile := time.Now()
+
+	// Directories are sized with placeholder offsets, which take the same space
+	dirFields := make([][]field, len(levels))
+	dirSizes := make([]int, len(levels))
+	dataStart := 8
+	for i, level := range levels {
+		dirFields[i] = w.fields(level, l, i, make([]uint32, len(tiles[i])), make([]uint32, len(tiles[i])))
+		dirSizes[i] = len(encodeIFD(dirFields[i], 0, 0))
+		dataStart += dirSizes[i]
+	}
+
+	// Tile data, smallest overview first
+	offset := int64(dataStart)
+	offsets := make([][]uint32, len(levels))
+	counts := make([][]uint32, len(levels))
+	for i := len(levels) - 1; i >= 0; i-- {
+		offsets[i] = make([]uint32, len(tiles[i]))
+		counts[i] = make([]uint32, len(tiles[i]))
+		for t, tile := range tiles[i] {
+			offsets[i][t] = uint32(offset)
+			counts[i][t] = uint32(len(tile))
+			offset += int64(len(tile))
+		}
+	}
+	if offset > math.MaxUint32 {
+		return nil, fmt.Errorf("GeoTIFF of %d bytes exceeds the 4 GiB classic TIFF limit", offset)
+	}
+
+	// Write to a temporary file renamed once complete
+	out, err := output.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(out)
+
+	// Little-endian header pointing to the first directory
+	bw.Write([]byte{'I', 'I', 42, 0, 8, 0, 0, 0})
+	dirOffset := uint32(8)
+	for i, level := range levels {
+		next := uint32(0)
+		if i+1 < len(levels) {
+			next = dirOffset + uint32(dirSizes[i])
+		}
+		bw.Write(encodeIFD(w.fields(level, l, i, offsets[i], counts[i]), dirOffset, next))
+		dirOffset += uint32(dirSizes[i])
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, tile := range tiles[i] {
+			bw.Write(tile)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		out.Abort()
+		return nil, fmt.Errorf("failed to write %s: %v", outputPath, err)
+	}
+	if err := out.Commit(); err != nil {
+		return nil, err
+	}
+	writeMetrics.FileTime = time.Since(startFile)
+
+	writeMetrics.Bytes = offset
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(len(r.pix)), offset)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// fields returns the directory entries of an image; georeferencing is only written
+// for the full resolution image
+func (w *Writer) fields(r *raster, l layout, level int, offsets, counts []uint32) []field {
+	tileSize := w.opts.TileSize
+
+	compression := uint16(compressionTagNone)
+	switch w.opts.Compression {
+	case CompressionDeflate:
+		compression = compressionTagDeflate
+	case CompressionLZW:
+		compression = compressionTagLZW
+	}
+
+	bits := make([]uint16, r.samples)
+	formats := make([]uint16, r.samples)
+	for s := range bits {
+		bits[s] = uint16(r.sampleBytes * 8)
+		formats[s] = r.sampleFormat
+	}
+
+	fields := []field{
+		longField(tagImageWidth, uint32(r.width)),
+		longField(tagImageLength, uint32(r.height)),
+		shortField(tagBitsPerSample, bits...),
+		shortField(tagCompression, compression),
+		shortField(tagPhotometric, l.photometric),
+		shortField(tagSamplesPerPixel, uint16(r.samples)),
+		shortField(tagPlanarConfiguration, planarContig),
+		longField(tagTileWidth, uint32(tileSize)),
+		longField(tagTileLength, uint32(tileSize)),
+		longField(tagTileOffsets, offsets...),
+		longField(tagTileByteCounts, counts...),
+		shortField(tagSampleFormat, formats...),
+	}
+	if level > 0 {
+		fields = append(fields, longField(tagNewSubfileType, subfileReducedImage))
+	}
+	if w.usePredictor(r, l) {
+		fields = append(fields, shortField(tagPredictor, predictorHorizontal))
+	}
+	if l.colorMap != nil {
+		fields = append(fields, shortField(tagColorMap, l.colorMap...))
+	}
+	if l.extraSamples != nil {
+		fields = append(fields, shortField(tagExtraSamples, l.extraSamples...))
+	}
+	if l.noData != "" {
+		fields = append(fields, asciiField(tagGDALNoData, l.noData))
+	}
+	if level == 0 {
+		if l.metadata != "" {
+			fields = append(fields, asciiField(tagGDALMetadata, l.metadata))
+		}
+		if w.georef != nil {
+			fields = append(fields, w.geoFields()...)
+		}
+	}
+	return fields
+}
+
+// geoFields returns the model and GeoKey directory tags of the georeference
+func (w *Writer) geoFields() []field {
+	g := w.georef
+	t := g.GeoTransform
+
+	var fields []field
+	if g.IsNorthUp() {
+		fields = append(fields,
+			doubleField(tagModelPixelScale, t[1], -t[5], 0),
+			doubleField(tagModelTiepoint, 0, 0, 0, t[0], t[3], 0))
+	} else {
+		fields = append(fields, doubleField(tagModelTransformation,
+			t[1], t[2], 0, t[0],
+			t[4], t[5], 0, t[3],
+			0, 0, 0, 0,
+			0, 0, 0, 1))
+	}
+
+	if g.EPSG != 0 {
+		modelType, crsKey, citationKey := uint16(modelTypeProjected), uint16(keyProjectedCSType), uint16(keyPCSCitation)
+		if g.IsGeographic() {
+			modelType, crsKey, citationKey = modelTypeGeographic, keyGeographicType, keyGeogCitation
+		}
+		// Header (version 1.1.0, 3 keys) and keys sorted by id: id, location, count, value
+		keys := []uint16{
+			1, 1, 0, 3,
+			keyModelType, 0, 1, modelType,
+			keyRasterType, 0, 1, rasterPixelIsArea,
+			crsKey, 0, 1, uint16(g.EPSG),
+		}
+		if g.EPSG > math.MaxUint16 {
+			// Codes that do not fit a GeoKey, such as ESRI 102xxx codes, are written as a
+			// user-defined CRS cited by its code in the ASCII parameters
+			citation := fmt.Sprintf("EPSG:%d|", g.EPSG)
+			keys[3] = 4
+			keys[15] = crsUserDefined
+			keys = append(keys, citationKey, tagGeoASCIIParams, uint16(len(citation)), 0)
+			fields = append(fields, asciiField(tagGeoASCIIParams, citation))
+		}
+		fields = append(fields, shortField(tagGeoKeyDirectory, keys...))
+	}
+	return fields
+}
+
+// usePredictor reports whether integer samples are differenced before compression
+// Palette indices and floating point samples are not
+func (w *Writer) usePredictor(r *raster, l layout) bool {
+	return w.opts.Predictor && w.opts.Compression != CompressionNone &&
+		r.sampleFormat != sampleFormatFloat && l.photometric != photometricPalette
+}
+
+// encodeTiles compresses the tiles of every level, row by row of tiles
+func (w *Writer) encodeTiles(levels []*raster, l layout, threads int) [][][]byte {
+	tileSize := w.opts.TileSize
+
+	// One job per tile over all levels
+	type job struct{ level, col, row int }
+	var jobs []job
+	tiles := make([][][]byte, len(levels))
+	for i, r := range levels {
+		across := (r.width + tileSize - 1) / tileSize
+		down := (r.height + tileSize - 1) / tileSize
+		tiles[i] = make([][]byte, across*down)
+		for row := 0; row < down; row++ {
+			for col := 0; col < across; col++ {
+				jobs = append(jobs, job{i, col, row})
+			}
+		}
+	}
+
+	// Process tiles in parallel
+	jobCount := len(jobs)
+	numWorkers := threads
+	chunkSize := (jobCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for worker := 0; worker < numWorkers; worker++ {
+		start := worker * chunkSize
+		end := min(start+chunkSize, jobCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			buf := make([]byte, tileSize*tileSize*levels[0].pixelBytes())
+			for j := start; j < end; j++ {
+				jb := jobs[j]
+				r := levels[jb.level]
+				across := (r.width + tileSize - 1) / tileSize
+				tiles[jb.level][jb.row*across+jb.col] = w.encodeTile(r, w.usePredictor(r, l), jb.col, jb.row, buf)
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return tiles
+}
+
+// encodeTile copies a tile into buf, padding it past the image edges, and compresses it
+func (w *Writer) encodeTile(r *raster, predictor bool, col, row int, buf []byte) []byte {
+	tileSize := w.opts.TileSize
+	pixelBytes := r.pixelBytes()
+	rowBytes := tileSize * pixelBytes
+
+	x0, y0 := col*tileSize, row*tileSize
+	valid := min(tileSize, r.width-x0) * pixelBytes
+	for y := 0; y < tileSize; y++ {
+		dst := buf[y*rowBytes : (y+1)*rowBytes]
+		n := 0
+		if y0+y < r.height {
+			n = copy(dst, r.row(y0 + y)[x0*pixelBytes:x0*pixelBytes+valid])
+		}
+		for x := n; x < rowBytes; x += pixelBytes {
+			copy(dst[x:], r.fill)
+		}
+		if predictor {
+			differenceRow(dst, r.samples, r.sampleBytes)
+		}
+	}
+
+	switch w.opts.Compression {
+	case CompressionDeflate:
+		var b bytes.Buffer
+		zw := zlib.NewWriter(&b)
+		zw.Write(buf)
+		zw.Close()
+		return b.Bytes()
+	case CompressionLZW:
+		return compressLZW(buf)
+	default:
+		return append([]byte(nil), buf...)
+	}
+}
+
+// differenceRow applies the horizontal predictor to a row of little-endian samples,
+// replacing each sample by its difference with the same sample of the previous pixel
+func differenceRow(row []byte, samples, sampleBytes int) {
+	pixelBytes := samples * sampleBytes
+	switch sampleBytes {
+	case 1:
+		for i := len(row) - 1; i >= pixelBytes; i-- {
+			row[i] -= row[i-pixelBytes]
+		}
+	case 2:
+		for i := len(row) - 2; i >= pixelBytes; i -= 2 {
+			v := binary.LittleEndian.Uint16(row[i:]) - binary.LittleEndian.Uint16(row[i-pixelBytes:])
+			binary.LittleEndian.PutUint16(row[i:], v)
+		}
+	case 4:
+		for i := len(row) - 4; i >= pixelBytes; i -= 4 {
+			v := binary.LittleEndian.Uint32(row[i:]) - binary.LittleEndian.Uint32(row[i-pixelBytes:])
+			binary.LittleEndian.PutUint32(row[i:], v)
+		}
+	}
+}
+
+// encodeIFD encodes a directory at the given file offset, with the values that do
+// not fit an entry stored right after it
+func encodeIFD(fields []field, offset, next uint32) []byte {
+	sort.Slice(fields, func(a, b int) bool { return fields[a].tag < fields[b].tag })
+
+	size := 2 + len(fields)*12 + 4
+	ifd := make([]byte, size)
+	binary.LittleEndian.PutUint16(ifd, uint16(len(fields)))
+	for i, f := range fields {
+		entry := ifd[2+i*12:]
+		binary.LittleEndian.PutUint16(entry[0:], f.tag)
+		binary.LittleEndian.PutUint16(entry[2:], f.typ)
+		binary.LittleEndian.PutUint32(entry[4:], f.count)
+		if len(f.value) <= 4 {
+			copy(entry[8:12], f.value)
+			continue
+		}
+		binary.LittleEndian.PutUint32(entry[8:], offset+uint32(len(ifd)))
+		ifd = append(ifd, f.value...)
+		if len(ifd)%2 == 1 {
+			ifd = append(ifd, 0) // Values start on word boundaries
+		}
+	}
+	binary.LittleEndian.PutUint32(ifd[size-4:], next)
+	return ifd
+}
+
+// shortField returns an entry of SHORT values
+func shortField(tag uint16, values ...uint16) field {
+	value := make([]byte, 0, len(values)*2)
+	for _, v := range values {
+		value = binary.LittleEndian.AppendUint16(value, v)
+	}
+	return field{tag, typeShort, uint32(len(values)), value}
+}
+
+// longField returns an entry of LONG values
+func longField(tag uint16, values ...uint32) field {
+	value := make([]byte, 0, len(values)*4)
+	for _, v := range values {
+		value = binary.LittleEndian.AppendUint32(value, v)
+	}
+	return field{tag, typeLong, uint32(len(values)), value}
+}
+
+// doubleField returns an entry of DOUBLE values
+func doubleField(tag uint16, values ...float64) field {
+	value := make([]byte, 0, len(values)*8)
+	for _, v := range values {
+		value = binary.LittleEndian.AppendUint64(value, math.Float64bits(v))
+	}
+	return field{tag, typeDouble, uint32(len(values)), value}
+}
+
+// asciiField returns a NUL-terminated ASCII entry
+func asciiField(tag uint16, s string) field {
+	value := append([]byte(s), 0)
+	return field{tag, typeASCII, uint32(len(value)), value}
+}
@@ -0,0 +1,213 @@
+package geotiff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Compression selects the codec of the GeoTIFF tiles
+type Compression int
+
+const (
+	CompressionNone    Compression = iota // Uncompressed tiles
+	CompressionDeflate                    // zlib (Adobe Deflate) tiles
+	CompressionLZW                        // TIFF LZW tiles
+)
+
+// String returns the flag name of the compression
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionDeflate:
+		return "deflate"
+	case CompressionLZW:
+		return "lzw"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// ParseCompression converts a flag value into a Compression
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return CompressionNone, nil
+	case "deflate":
+		return CompressionDeflate, nil
+	case "lzw":
+		return CompressionLZW, nil
+	default:
+		return 0, fmt.Errorf("unknown GeoTIFF compression: %s", name)
+	}
+}
+
+// Options configures the GeoTIFF layout
+type Options struct {
+	Compression Compression
+	TileSize    int  // Tile width and height in pixels, a multiple of 16
+	Overviews   bool // Add internal overviews halving the size down to a single tile
+	Predictor   bool // Horizontal differencing of integer samples before compression
+}
+
+// DefaultOptions returns 512x512 DEFLATE tiles with a predictor and overviews
+func DefaultOptions() Options {
+	return Options{
+		Compression: CompressionDeflate,
+		TileSize:    512,
+		Overviews:   true,
+		Predictor:   true,
+	}
+}
+
+// Writer saves images as tiled, Cloud Optimized GeoTIFF files
+// It implements jp2.Writer, jp2.PalettedWriter and jp2.QuantizedWriter so it can
+// replace the JPEG2000 writers, and also writes float32 values
+type Writer struct {
+	opts   Options
+	georef *geo.Georeference
+}
+
+// NewWriter creates a GeoTIFF writer
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.TileSize < 16 || opts.TileSize%16 != 0 {
+		return nil, fmt.Errorf("GeoTIFF tile size must be a positive multiple of 16, got %d", opts.TileSize)
+	}
+	return &Writer{opts: opts}, nil
+}
+
+// SetGeoreference sets the georeferencing written as GeoTIFF tags, nil for none
+func (w *Writer) SetGeoreference(georef *geo.Georeference) {
+	w.georef = georef
+}
+
+// Write saves an RGBA image as 8-bit RGB with an associated alpha channel
+// Returns the time and size of each stage of the write and any error that occurred
+func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	bounds := img.Bounds()
+	r := newRaster(bounds.Dx(), bounds.Dy(), 4, 1, sampleFormatUint)
+	for y := 0; y < r.height; y++ {
+		copy(r.row(y), img.Pix[y*img.Stride:y*img.Stride+r.width*4])
+	}
+
+	l := layout{
+		photometric:  photometricRGB,
+		extraSamples: []uint16{extraSampleAssociatedAlpha},
+		combine:      averageUint8, // Premultiplied samples average correctly
+	}
+	return w.writeRaster(r, l, outputPath, startSave, threads)
+}
+
+// WritePaletted saves a paletted image as 8-bit indices with a TIFF color map
+// A transparent first palette entry is declared as no data; the color map itself
+// cannot store transparency
+func (w *Writer) WritePaletted(img *image.Paletted, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if len(img.Palette) > 256 {
+		return nil, fmt.Errorf("palette of %d entries does not fit 8-bit indices", len(img.Palette))
+	}
+
+	bounds := img.Bounds()
+	r := newRaster(bounds.Dx(), bounds.Dy(), 1, 1, sampleFormatUint)
+	for y := 0; y < r.height; y++ {
+		copy(r.row(y), img.Pix[y*img.Stride:y*img.Stride+r.width])
+	}
+
+	// Color map: all red values, then all green, then all blue, over 16 bits
+	colorMap := make([]uint16, 3*256)
+	for i, c := range img.Palette {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		colorMap[i] = uint16(nc.R) * 257
+		colorMap[256+i] = uint16(nc.G) * 257
+		colorMap[512+i] = uint16(nc.B) * 257
+	}
+
+	l := layout{
+		photometric: photometricPalette,
+		colorMap:    colorMap,
+		combine:     nearest,
+	}
+	if len(img.Palette) > 0 {
+		if _, _, _, a := img.Palette[0].RGBA(); a == 0 {
+			l.noData = "0"
+		}
+	}
+	return w.writeRaster(r, l, outputPath, startSave, threads)
+}
+
+// WriteQuantized saves int16 samples with the no-data value and the scale and offset
+// of the quantization as GDAL tags
+func (w *Writer) WriteQuantized(samples []int16, width, height int, q jp2.Quantization, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if len(samples) != width*height {
+		return nil, fmt.Errorf("got %d samples for a %dx%d image", len(samples), width, height)
+	}
+
+	r := newRaster(width, height, 1, 2, sampleFormatInt)
+	for i, s := range samples {
+		r.pix[i*2] = byte(uint16(s))
+		r.pix[i*2+1] = byte(uint16(s) >> 8)
+	}
+	r.fill = []byte{byte(uint16(q.NoData)), byte(uint16(q.NoData) >> 8)}
+
+	l := layout{
+		photometric: photometricMinIsBlack,
+		noData:      strconv.Itoa(int(q.NoData)),
+		metadata:    gdalMetadata(q.Scale, q.Offset, q.Units),
+		combine:     averageInt16(q.NoData),
+	}
+	return w.writeRaster(r, l, outputPath, startSave, threads)
+}
+
+// WriteFloat32 saves float32 values, with NaN as the no-data value
+func (w *Writer) WriteFloat32(values []float32, width, height int, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if len(values) != width*height {
+		return nil, fmt.Errorf("got %d values for a %dx%d image", len(values), width, height)
+	}
+
+	r := newRaster(width, height, 1, 4, sampleFormatFloat)
+	for i, v := range values {
+		bits := math.Float32bits(v)
+		r.pix[i*4] = byte(bits)
+		r.pix[i*4+1] = byte(bits >> 8)
+		r.pix[i*4+2] = byte(bits >> 16)
+		r.pix[i*4+3] = byte(bits >> 24)
+	}
+	nan := math.Float32bits(float32(math.NaN()))
+	r.fill = []byte{byte(nan), byte(nan >> 8), byte(nan >> 16), byte(nan >> 24)}
+
+	l := layout{
+		photometric: photometricMinIsBlack,
+		noData:      "nan",
+		combine:     averageFloat32,
+	}
+	return w.writeRaster(r, l, outputPath, startSave, threads)
+}
+
+// gdalMetadata returns the GDAL_METADATA tag declaring the scale, offset and units of band 1
+func gdalMetadata(scale, offset float64, units string) string {
+	var b strings.Builder
+	b.WriteString("<GDALMetadata>\n")
+	fmt.Fprintf(&b, "  <Item name=\"OFFSET\" sample=\"0\" role=\"offset\">%s</Item>\n", strconv.FormatFloat(offset, 'g', -1, 64))
+	fmt.Fprintf(&b, "  <Item name=\"SCALE\" sample=\"0\" role=\"scale\">%s</Item>\n", strconv.FormatFloat(scale, 'g', -1, 64))
+	if units != "" {
+		fmt.Fprintf(&b, "  <Item name=\"UNITTYPE\" sample=\"0\" role=\"unittype\">%s</Item>\n", units)
+	}
+	b.WriteString("</GDALMetadata>")
+	return b.String()
+}
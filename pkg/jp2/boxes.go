@@ -0,0 +1,301 @@
+package jp2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// Enumerated color spaces of the JP2 colr box
+const (
+	EnumSRGB      uint32 = 16
+	EnumGreyscale uint32 = 17
+)
+
+// jp2Signature is the content of the JPEG 2000 signature box
+var jp2Signature = []byte{0x0d, 0x0a, 0x87, 0x0a}
+
+// Container describes the JP2 boxes wrapped around a raw JPEG 2000 codestream,
+// for files whose header boxes OpenJPEG cannot write itself
+type Container struct {
+	Width, Height int
+	Components    int    // Components of the codestream
+	Precision     int    // Bits per component
+	Signed        bool   // Components are signed
+	ColorSpace    uint32 // Enumerated color space of the colr box
+	Palette       color.Palette
+	XML           [][]byte // Contents of xml boxes written after the header
+}
+
+// WriteContainer writes a JP2 file made of the container boxes and the codestream
+// A palette is written as pclr and cmap boxes that map the single codestream
+// component to RGB, or RGBA with a cdef box when any entry is not opaque
+func WriteContainer(w io.Writer, c *Container, codestream []byte) error {
+	if c.Palette != nil && c.Components != 1 {
+		return fmt.Errorf("a palette needs a single index component, got %d components", c.Components)
+	}
+	if len(c.Palette) > 1<<c.Precision {
+		return fmt.Errorf("palette of %d entries does not fit %d-bit indices", len(c.Palette), c.Precision)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// Signature and file type
+	writeBox(bw, "jP  ", jp2Signature)
+	ftyp := make([]byte, 12)
+	copy(ftyp[0:], "jp2 ")
+	copy(ftyp[8:], "jp2 ")
+	writeBox(bw, "ftyp", ftyp)
+
+	// Header superbox
+	header := boxBytes("ihdr", c.ihdr())
+	colr := make([]byte, 7)
+	colr[0] = 1 // Enumerated color space
+	binary.BigEndian.PutUint32(colr[3:], c.ColorSpace)
+	header = append(header, boxBytes("colr", colr)...)
+	if c.Palette != nil {
+		pclr, columns := paletteBox(c.Palette)
+		header = append(header, boxBytes("pclr", pclr)...)
+
+		cmap := make([]byte, 0, columns*4)
+		for i := 0; i < columns; i++ {
+			cmap = append(cmap, 0, 0, 1, byte(i)) // Component 0 through palette column i
+		}
+		header = append(header, boxBytes("cmap", cmap)...)
+
+		if columns == 4 {
+			cdef := []byte{0, 4}
+			for i, assoc := range []uint16{1, 2, 3, 0} {
+				typ := uint16(0) // Color channel
+				if i == 3 {
+					typ = 1 // Opacity of the whole image
+				}
+				cdef = binary.BigEndian.AppendUint16(cdef, uint16(i))
+				cdef = binary.BigEndian.AppendUint16(cdef, typ)
+				cdef = binary.BigEndian.AppendUint16(cdef, assoc)
+			}
+			header = append(header, boxBytes("cdef", cdef)...)
+		}
+	}
+	writeBox(bw, "jp2h", header)
+
+	for _, xml := range c.XML {
+		writeBox(bw, "xml ", xml)
+	}
+
+	// Contiguous codestream
+	writeBox(bw, "jp2c", codestream)
+
+	return bw.Flush()
+}
+
+// ihdr returns the content of the image header box
+func (c *Container) ihdr() []byte {
+	ihdr := make([]byte, 14)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(c.Height))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(c.Width))
+	binary.BigEndian.PutUint16(ihdr[8:], uint16(c.Components))
+	ihdr[10] = bitDepth(c.Precision, c.Signed)
+	ihdr[11] = 7 // JPEG 2000 compression
+	return ihdr
+}
+
+// paletteBox returns the content of a pclr box with 8-bit columns and its number of columns
+func paletteBox(palette color.Palette) ([]byte, int) {
+	columns := 3
+	for _, c := range palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			columns = 4
+			break
+		}
+	}
+
+	pclr := make([]byte, 0, 3+columns+len(palette)*columns)
+	pclr = binary.BigEndian.AppendUint16(pclr, uint16(len(palette)))
+	pclr = append(pclr, byte(columns))
+	for i := 0; i < columns; i++ {
+		pclr = append(pclr, bitDepth(8, false))
+	}
+	for _, c := range palette {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		pclr = append(pclr, nc.R, nc.G, nc.B)
+		if columns == 4 {
+			pclr = append(pclr, nc.A)
+		}
+	}
+	return pclr, columns
+}
+
+// bitDepth encodes a precision and signedness as a JP2 bit depth byte
+func bitDepth(precision int, signed bool) byte {
+	depth := byte(precision - 1)
+	if signed {
+		depth |= 0x80
+	}
+	return depth
+}
+
+// boxBytes returns a box with the given type and content
+func boxBytes(boxType string, content []byte) []byte {
+	box := make([]byte, 8, 8+len(content))
+	binary.BigEndian.PutUint32(box[0:], uint32(8+len(content)))
+	copy(box[4:], boxType)
+	return append(box, content...)
+}
+
+// writeBox writes a box header followed by its content, using an extended
+// length for boxes of 4 GiB or more
+func writeBox(w *bufio.Writer, boxType string, content []byte) {
+	if uint64(len(content))+8 > 0xffffffff {
+		var header [16]byte
+		binary.BigEndian.PutUint32(header[0:], 1)
+		copy(header[4:], boxType)
+		binary.BigEndian.PutUint64(header[8:], uint64(len(content))+16)
+		w.Write(header[:])
+	} else {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[0:], uint32(8+len(content)))
+		copy(header[4:], boxType)
+		w.Write(header[:])
+	}
+	w.Write(content)
+}
+
+// ReadContainer reads the header boxes of a JP2 file: the image header, color
+// specification, palette and xml boxes. The codestream is skipped
+func ReadContainer(r io.ReadSeeker) (*Container, error) {
+	c := &Container{}
+	foundHeader := false
+
+	for {
+		boxType, content, err := readBox(r, func(boxType string) bool { return boxType != "jp2c" })
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch boxType {
+		case "jP  ":
+			if string(content) != string(jp2Signature) {
+				return nil, fmt.Errorf("invalid JP2 signature")
+			}
+		case "jp2h":
+			if err := c.readHeaderBoxes(content); err != nil {
+				return nil, err
+			}
+			foundHeader = true
+		case "xml ":
+			c.XML = append(c.XML, content)
+		}
+	}
+
+	if !foundHeader {
+		return nil, fmt.Errorf("missing JP2 header box")
+	}
+	return c, nil
+}
+
+// readHeaderBoxes parses the boxes inside the jp2h superbox
+func (c *Container) readHeaderBoxes(header []byte) error {
+	for len(header) >= 8 {
+		length := int(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		if length < 8 || length > len(header) {
+			return fmt.Errorf("invalid %q box length %d", boxType, length)
+		}
+		content := header[8:length]
+		header = header[length:]
+
+		switch boxType {
+		case "ihdr":
+			if len(content) < 14 {
+				return fmt.Errorf("truncated ihdr box")
+			}
+			c.Height = int(binary.BigEndian.Uint32(content[0:]))
+			c.Width = int(binary.BigEndian.Uint32(content[4:]))
+			c.Components = int(binary.BigEndian.Uint16(content[8:]))
+			c.Precision = int(content[10]&0x7f) + 1
+			c.Signed = content[10]&0x80 != 0
+		case "colr":
+			if len(content) >= 7 && content[0] == 1 {
+				c.ColorSpace = binary.BigEndian.Uint32(content[3:])
+			}
+		case "pclr":
+			if len(content) < 3 {
+				return fmt.Errorf("truncated pclr box")
+			}
+			entries := int(binary.BigEndian.Uint16(content))
+			columns := int(content[2])
+			if columns < 3 || len(content) < 3+columns+entries*columns {
+				return fmt.Errorf("unsupported pclr box with %d columns", columns)
+			}
+			for _, depth := range content[3 : 3+columns] {
+				if depth != bitDepth(8, false) {
+					return fmt.Errorf("unsupported pclr box with %d-bit columns", int(depth&0x7f)+1)
+				}
+			}
+			data := content[3+columns:]
+			c.Palette = make(color.Palette, entries)
+			for i := range c.Palette {
+				entry := data[i*columns:]
+				alpha := uint8(255)
+				if columns > 3 {
+					alpha = entry[3]
+				}
+				c.Palette[i] = color.NRGBA{entry[0], entry[1], entry[2], alpha}
+			}
+		}
+	}
+	return nil
+}
+
+// readBox reads the next box, returning its content when keep reports true for its
+// type and skipping over it otherwise
+func readBox(r io.ReadSeeker, keep func(boxType string) bool) (string, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", nil, fmt.Errorf("truncated box header")
+		}
+		return "", nil, err
+	}
+
+	length := uint64(binary.BigEndian.Uint32(header[0:]))
+	boxType := string(header[4:8])
+	headerSize := uint64(8)
+	switch length {
+	case 0:
+		// Box extends to the end of the file
+		if keep(boxType) {
+			content, err := io.ReadAll(r)
+			return boxType, content, err
+		}
+		_, err := r.Seek(0, io.SeekEnd)
+		return boxType, nil, err
+	case 1:
+		var xl [8]byte
+		if _, err := io.ReadFull(r, xl[:]); err != nil {
+			return "", nil, fmt.Errorf("truncated %q box length", boxType)
+		}
+		length = binary.BigEndian.Uint64(xl[:])
+		headerSize = 16
+	}
+	if length < headerSize {
+		return "", nil, fmt.Errorf("invalid %q box length %d", boxType, length)
+	}
+
+	size := int64(length - headerSize)
+	if !keep(boxType) {
+		_, err := r.Seek(size, io.SeekCurrent)
+		return boxType, nil, err
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return "", nil, fmt.Errorf("truncated %q box", boxType)
+	}
+	return boxType, content, nil
+}
@@ -0,0 +1,155 @@
+package jp2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Codestream markers
+const (
+	markerSOC = 0xff4f // Start of codestream
+	markerSIZ = 0xff51 // Image and tile size
+	markerTLM = 0xff55 // Tile-part lengths
+	markerPLM = 0xff57 // Packet lengths, main header
+	markerPPM = 0xff60 // Packed packet headers, main header
+	markerSOT = 0xff90 // Start of tile-part
+	markerEOC = 0xffd9 // End of codestream
+)
+
+// AssembleTiles joins codestreams encoded separately for each tile of a width x height
+// image into a single codestream with tiles of tileSize pixels
+// tiles holds one codestream per tile in raster order, each a single tile of the
+// tile size whose image is the tile itself. Their main headers must match except
+// for the image size, so every tile was encoded with the same parameters; the first
+// one becomes the main header with the size of the whole image. Tile-parts are
+// copied with their tile index rewritten
+// Tile origins must be multiples of the codeblock and precinct partitions of every
+// resolution, which holds when tileSize is a power of two of at least
+// 2^(resolutions-1)
+func AssembleTiles(width, height, tileSize int, tiles [][]byte) ([]byte, error) {
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	if len(tiles) != tilesX*tilesY || len(tiles) == 0 {
+		return nil, fmt.Errorf("got %d tile codestreams for %dx%d tiles", len(tiles), tilesX, tilesY)
+	}
+	if tilesX*tilesY > 65535 {
+		return nil, fmt.Errorf("%d tiles exceed the 65535 of a codestream", tilesX*tilesY)
+	}
+
+	var mainHeader []byte
+	var size int
+	parts := make([][]byte, len(tiles))
+	for i, tile := range tiles {
+		x0, y0 := (i%tilesX)*tileSize, (i/tilesX)*tileSize
+		header, tileParts, err := splitCodestream(tile)
+		if err != nil {
+			return nil, fmt.Errorf("tile %d: %v", i, err)
+		}
+		tileWidth, tileHeight, err := resizeSIZ(header, width, height, tileSize)
+		if err != nil {
+			return nil, fmt.Errorf("tile %d: %v", i, err)
+		}
+		if tileWidth != min(tileSize, width-x0) || tileHeight != min(tileSize, height-y0) {
+			return nil, fmt.Errorf("tile %d is %dx%d, expected %dx%d", i, tileWidth, tileHeight, min(tileSize, width-x0), min(tileSize, height-y0))
+		}
+		if i == 0 {
+			mainHeader = header
+		} else if !bytes.Equal(header, mainHeader) {
+			return nil, fmt.Errorf("tile %d was encoded with different parameters", i)
+		}
+		if err := renumberTileParts(tileParts, i); err != nil {
+			return nil, fmt.Errorf("tile %d: %v", i, err)
+		}
+		parts[i] = tileParts
+		size += len(tileParts)
+	}
+
+	codestream := make([]byte, 0, len(mainHeader)+size+2)
+	codestream = append(codestream, mainHeader...)
+	for _, tileParts := range parts {
+		codestream = append(codestream, tileParts...)
+	}
+	return binary.BigEndian.AppendUint16(codestream, markerEOC), nil
+}
+
+// splitCodestream returns copies of the main header of a codestream, from SOC to the
+// first SOT, and of its tile-parts, up to EOC
+func splitCodestream(codestream []byte) ([]byte, []byte, error) {
+	if len(codestream) < 4 || binary.BigEndian.Uint16(codestream) != markerSOC {
+		return nil, nil, fmt.Errorf("missing SOC marker")
+	}
+	if binary.BigEndian.Uint16(codestream[2:]) != markerSIZ {
+		return nil, nil, fmt.Errorf("missing SIZ marker")
+	}
+	pos := 2
+	for {
+		if pos+4 > len(codestream) {
+			return nil, nil, fmt.Errorf("truncated main header")
+		}
+		marker := binary.BigEndian.Uint16(codestream[pos:])
+		switch marker {
+		case markerSOT:
+			end := len(codestream) - 2
+			if end < pos || binary.BigEndian.Uint16(codestream[end:]) != markerEOC {
+				return nil, nil, fmt.Errorf("missing EOC marker")
+			}
+			return bytes.Clone(codestream[:pos]), bytes.Clone(codestream[pos:end]), nil
+		case markerTLM, markerPLM, markerPPM:
+			// Lengths and packet headers of the main header would describe a single tile
+			return nil, nil, fmt.Errorf("unsupported main header marker %04X", marker)
+		}
+		if marker>>8 != 0xff {
+			return nil, nil, fmt.Errorf("invalid marker %04X in main header", marker)
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(codestream[pos+2:]))
+	}
+}
+
+// resizeSIZ sets the image size of the SIZ segment of a main header, with no offsets
+// and square tiles of tileSize, and returns the image size it replaced
+func resizeSIZ(header []byte, width, height, tileSize int) (int, int, error) {
+	// SOC, then SIZ: marker, Lsiz, Rsiz, Xsiz, Ysiz, XOsiz, YOsiz, XTsiz, YTsiz, XTOsiz, YTOsiz
+	siz := header[2:]
+	if len(siz) < 38 {
+		return 0, 0, fmt.Errorf("truncated SIZ marker")
+	}
+	if binary.BigEndian.Uint32(siz[14:]) != 0 || binary.BigEndian.Uint32(siz[18:]) != 0 {
+		return 0, 0, fmt.Errorf("image offsets are not supported")
+	}
+	if int(binary.BigEndian.Uint32(siz[22:])) != tileSize || int(binary.BigEndian.Uint32(siz[26:])) != tileSize {
+		return 0, 0, fmt.Errorf("tiles are not %dx%d", tileSize, tileSize)
+	}
+	tileWidth, tileHeight := int(binary.BigEndian.Uint32(siz[6:])), int(binary.BigEndian.Uint32(siz[10:]))
+	binary.BigEndian.PutUint32(siz[6:], uint32(width))
+	binary.BigEndian.PutUint32(siz[10:], uint32(height))
+	binary.BigEndian.PutUint32(siz[30:], 0)
+	binary.BigEndian.PutUint32(siz[34:], 0)
+	return tileWidth, tileHeight, nil
+}
+
+// renumberTileParts sets the tile index of the tile-parts of a single tile codestream
+// and fills in the length of a last tile-part left open with a Psot of 0
+func renumberTileParts(tileParts []byte, index int) error {
+	pos := 0
+	for pos < len(tileParts) {
+		// SOT: marker, Lsot, Isot, Psot, TPsot, TNsot
+		if pos+12 > len(tileParts) || binary.BigEndian.Uint16(tileParts[pos:]) != markerSOT {
+			return fmt.Errorf("missing SOT marker at %d", pos)
+		}
+		if binary.BigEndian.Uint16(tileParts[pos+4:]) != 0 {
+			return fmt.Errorf("codestream has more than one tile")
+		}
+		binary.BigEndian.PutUint16(tileParts[pos+4:], uint16(index))
+		length := int(binary.BigEndian.Uint32(tileParts[pos+6:]))
+		if length == 0 {
+			length = len(tileParts) - pos
+			binary.BigEndian.PutUint32(tileParts[pos+6:], uint32(length))
+		}
+		if length < 12 || pos+length > len(tileParts) {
+			return fmt.Errorf("invalid tile-part length %d", length)
+		}
+		pos += length
+	}
+	return nil
+}
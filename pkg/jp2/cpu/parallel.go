@@ -0,0 +1,191 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// #cgo CFLAGS: -I/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/include
+// #cgo LDFLAGS: -L/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/lib -lopenjp2
+// #include <openjpeg-2.5/openjpeg.h>
+// #include <stdlib.h>
+import "C"
+
+// ParallelWriter implements jp2.Writer by encoding the JPEG2000 tiles of an image
+// concurrently, each with its own single-threaded OpenJPEG codec, and assembling the
+// tile codestreams into one codestream and JP2 container in Go
+// This is an experimental alternative to a single codec using several threads
+type ParallelWriter struct {
+	opts jp2.EncodeOptions
+}
+
+// NewParallelWriter creates a parallel tile writer with the given encode options
+// Tiles use the options' tile size, or 1024 pixels when they encode a single tile;
+// it must be a power of two of at least 2^(resolutions-1) so tiles encoded on their
+// own partition their codeblocks and precincts as in the whole image
+func NewParallelWriter(opts jp2.EncodeOptions) (*ParallelWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.TileSize == 0 {
+		opts.TileSize = defaultTileSize
+	}
+	minSize := 1 << (opts.Resolutions - 1)
+	if opts.TileSize&(opts.TileSize-1) != 0 || opts.TileSize < minSize {
+		return nil, fmt.Errorf("parallel tile size must be a power of two of at least %d, got %d", minSize, opts.TileSize)
+	}
+	return &ParallelWriter{opts: opts}, nil
+}
+
+// Write encodes an RGBA image in parallel tiles and saves it as JP2
+func (w *ParallelWriter) Write(img *image.RGBA, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	return writeFile(outputPath, func(out io.Writer) (*metrics.WriteMetrics, error) {
+		return w.Encode(img, out, threads)
+	})
+}
+
+// Encode encodes an RGBA image in parallel tiles as JP2 into out
+// Workers copy and encode tiles at the same time, so the copy time is the mean copy
+// time of the workers and the rest of the parallel stage is encode time, together
+// with assembling the codestream
+func (w *ParallelWriter) Encode(img *image.RGBA, out io.Writer, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return nil, errors.New("cannot encode an empty image")
+	}
+	tileSize := w.opts.TileSize
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	numTiles := tilesX * tilesY
+	codestreams := make([][]byte, numTiles)
+
+	// Encode tiles in parallel
+	numWorkers := max(threads, 1)
+	chunkSize := (numTiles + numWorkers - 1) / numWorkers
+	copyTimes := make([]time.Duration, numWorkers)
+	errs := make([]error, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for worker := 0; worker < numWorkers; worker++ {
+		start := min(worker*chunkSize, numTiles)
+		end := min(start+chunkSize, numTiles)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				x0, y0 := (i%tilesX)*tileSize, (i/tilesX)*tileSize
+				r := image.Rect(x0, y0, min(x0+tileSize, width), min(y0+tileSize, height))
+				codestream, copyTime, err := encodeTile(img, r, w.opts)
+				copyTimes[worker] += copyTime
+				if err != nil {
+					errs[worker] = fmt.Errorf("failed to encode tile %d: %v", i, err)
+					return
+				}
+				codestreams[i] = codestream
+			}
+		}(worker, start, end)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, copyTime := range copyTimes {
+		writeMetrics.CopyTime += copyTime
+	}
+	writeMetrics.CopyTime /= time.Duration(min(numWorkers, numTiles))
+
+	codestream, err := jp2.AssembleTiles(width, height, tileSize, codestreams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble tiles: %v", err)
+	}
+	writeMetrics.EncodeTime = time.Since(startSave) - writeMetrics.CopyTime
+
+	startFile := time.Now()
+	container := &jp2.Container{
+		Width:      width,
+		Height:     height,
+		Components: 4,
+		Precision:  8,
+		ColorSpace: jp2.EnumSRGB,
+	}
+	counter := &countingWriter{w: out}
+	if err := jp2.WriteContainer(counter, container, codestream); err != nil {
+		return nil, fmt.Errorf("failed to write JP2 output: %v", err)
+	}
+	writeMetrics.FileTime = time.Since(startFile)
+
+	writeMetrics.Bytes = counter.n
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(width*height*4), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// encodeTile encodes region r of an RGBA image on its own as a single tile J2K
+// codestream with one thread, returning the codestream and the time to copy the pixels
+func encodeTile(img *image.RGBA, r image.Rectangle, opts jp2.EncodeOptions) ([]byte, time.Duration, error) {
+	width, height := r.Dx(), r.Dy()
+
+	var cmptparm [4]C.opj_image_cmptparm_t
+	for i := 0; i < 4; i++ {
+		cmptparm[i].dx = 1
+		cmptparm[i].dy = 1
+		cmptparm[i].w = C.uint(width)
+		cmptparm[i].h = C.uint(height)
+		cmptparm[i].prec = 8
+		cmptparm[i].sgnd = 0
+	}
+
+	startCopy := time.Now()
+	cimage := C.opj_image_create(4, &cmptparm[0], C.OPJ_CLRSPC_SRGB)
+	if cimage == nil {
+		return nil, 0, errors.New("failed to create OpenJPEG image")
+	}
+	defer C.opj_image_destroy(cimage)
+
+	cimage.x0 = 0
+	cimage.y0 = 0
+	cimage.x1 = C.uint(width)
+	cimage.y1 = C.uint(height)
+
+	// Copy the region to the components
+	for i := 0; i < 4; i++ {
+		comp := (*C.opj_image_comp_t)(unsafe.Pointer(
+			uintptr(unsafe.Pointer(cimage.comps)) + uintptr(i)*unsafe.Sizeof(C.opj_image_comp_t{}),
+		))
+		if comp.data == nil {
+			return nil, 0, fmt.Errorf("component %d data is nil", i)
+		}
+		data := unsafe.Slice((*C.int)(comp.data), width*height)
+		for y := 0; y < height; y++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X+r.Min.X, img.Rect.Min.Y+r.Min.Y+y):]
+			for x := 0; x < width; x++ {
+				data[y*width+x] = C.int(row[x*4+i])
+			}
+		}
+	}
+	copyTime := time.Since(startCopy)
+
+	// The tile is encoded with the tile size of the whole image so its SIZ matches
+	codestream := &outputStream{}
+	if err := encodeImage(cimage, C.OPJ_CODEC_J2K, codestream, opts, 1); err != nil {
+		return nil, copyTime, err
+	}
+	return codestream.buf, copyTime, nil
+}
@@ -0,0 +1,179 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+)
+
+// #cgo CFLAGS: -I/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/include
+// #cgo LDFLAGS: -L/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/lib -lopenjp2
+// #include <openjpeg-2.5/openjpeg.h>
+// #include <stdint.h>
+// #include <stdlib.h>
+//
+// extern OPJ_SIZE_T goStreamWrite(void* buffer, OPJ_SIZE_T n, void* userData);
+// extern OPJ_OFF_T goStreamSkip(OPJ_OFF_T n, void* userData);
+// extern OPJ_BOOL goStreamSeek(OPJ_OFF_T offset, void* userData);
+import "C"
+
+// streamChunkSize is the size of the OpenJPEG stream buffer, as in its default streams
+const streamChunkSize = 1 << 20
+
+// outputStream bridges an OpenJPEG output stream to a Go writer
+// Seekable writers are written through, relative to their position when the stream
+// was created; other writers get the whole output buffered in memory and written
+// when the stream is flushed, since OpenJPEG seeks back to fill in box lengths
+type outputStream struct {
+	w      io.Writer
+	seeker io.WriteSeeker // nil when w cannot seek
+	base   int64          // Position of the seeker at offset 0
+	buf    []byte         // Buffered output when w cannot seek
+	pos    int64          // Current offset
+	size   int64          // Bytes of output, the largest offset written
+	ioTime time.Duration  // Time spent in w
+	err    error          // First error of w, which OpenJPEG only reports as a failure
+	handle unsafe.Pointer // C memory holding the cgo handle of the stream
+}
+
+// newOutputStream returns an output stream for w, writing through when w can seek
+func newOutputStream(w io.Writer) *outputStream {
+	s := &outputStream{w: w}
+	if seeker, ok := w.(io.WriteSeeker); ok {
+		// Pipes and terminals implement Seek but fail
+		if base, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			s.seeker, s.base = seeker, base
+		}
+	}
+	return s
+}
+
+// write writes p at the current offset, filling any gap left by seeking past the end
+// with zeros
+func (s *outputStream) write(p []byte) error {
+	if s.seeker == nil {
+		end := s.pos + int64(len(p))
+		if end > int64(len(s.buf)) {
+			s.buf = append(s.buf, make([]byte, end-int64(len(s.buf)))...)
+		}
+		copy(s.buf[s.pos:end], p)
+	} else {
+		start := time.Now()
+		_, err := s.seeker.Write(p)
+		s.ioTime += time.Since(start)
+		if err != nil {
+			return err
+		}
+	}
+	s.pos += int64(len(p))
+	s.size = max(s.size, s.pos)
+	return nil
+}
+
+// seek moves the current offset, which may go past the end of the output
+func (s *outputStream) seek(offset int64) error {
+	if offset < 0 {
+		return fmt.Errorf("invalid stream offset %d", offset)
+	}
+	if s.seeker != nil {
+		start := time.Now()
+		_, err := s.seeker.Seek(s.base+offset, io.SeekStart)
+		s.ioTime += time.Since(start)
+		if err != nil {
+			return err
+		}
+	}
+	s.pos = offset
+	return nil
+}
+
+// flush writes the buffered output to w once encoding has finished
+func (s *outputStream) flush() error {
+	if s.seeker != nil {
+		// Leave the writer at the end of the output, as a sequential write would
+		return s.seek(s.size)
+	}
+	start := time.Now()
+	_, err := s.w.Write(s.buf)
+	s.ioTime += time.Since(start)
+	return err
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// open creates an OpenJPEG output stream calling back into s
+// The stream must be destroyed with close
+func (s *outputStream) open() (*C.opj_stream_t, error) {
+	stream := C.opj_stream_create(streamChunkSize, C.OPJ_FALSE)
+	if stream == nil {
+		return nil, errors.New("failed to create output stream")
+	}
+
+	// The handle is kept in C memory, since C may not keep Go pointers
+	s.handle = C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
+	*(*C.uintptr_t)(s.handle) = C.uintptr_t(cgo.NewHandle(s))
+
+	C.opj_stream_set_user_data(stream, s.handle, nil)
+	C.opj_stream_set_write_function(stream, C.opj_stream_write_fn(C.goStreamWrite))
+	C.opj_stream_set_skip_function(stream, C.opj_stream_skip_fn(C.goStreamSkip))
+	C.opj_stream_set_seek_function(stream, C.opj_stream_seek_fn(C.goStreamSeek))
+	return stream, nil
+}
+
+// close destroys an OpenJPEG stream created by open and releases its handle
+// OpenJPEG flushes its buffer to the write callback when compression ends, not on
+// destruction, so close does not write
+func (s *outputStream) close(stream *C.opj_stream_t) {
+	C.opj_stream_destroy(stream)
+	cgo.Handle(*(*C.uintptr_t)(s.handle)).Delete()
+	C.free(s.handle)
+	s.handle = nil
+}
+
+// streamFromUserData returns the output stream of an OpenJPEG callback
+func streamFromUserData(userData unsafe.Pointer) *outputStream {
+	return cgo.Handle(*(*C.uintptr_t)(userData)).Value().(*outputStream)
+}
+
+//export goStreamWrite
+func goStreamWrite(buffer unsafe.Pointer, n C.OPJ_SIZE_T, userData unsafe.Pointer) C.OPJ_SIZE_T {
+	s := streamFromUserData(userData)
+	if err := s.write(unsafe.Slice((*byte)(buffer), int(n))); err != nil {
+		s.err = err
+		return ^C.OPJ_SIZE_T(0)
+	}
+	return n
+}
+
+//export goStreamSkip
+func goStreamSkip(n C.OPJ_OFF_T, userData unsafe.Pointer) C.OPJ_OFF_T {
+	s := streamFromUserData(userData)
+	if err := s.seek(s.pos + int64(n)); err != nil {
+		s.err = err
+		return -1
+	}
+	return n
+}
+
+//export goStreamSeek
+func goStreamSeek(offset C.OPJ_OFF_T, userData unsafe.Pointer) C.OPJ_BOOL {
+	s := streamFromUserData(userData)
+	if err := s.seek(int64(offset)); err != nil {
+		s.err = err
+		return C.OPJ_FALSE
+	}
+	return C.OPJ_TRUE
+}
@@ -0,0 +1,171 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"time"
+	"unsafe"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// #cgo CFLAGS: -I/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/include
+// #cgo LDFLAGS: -L/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/lib -lopenjp2
+// #include <openjpeg-2.5/openjpeg.h>
+// #include <stdlib.h>
+import "C"
+
+// defaultTileSize is the tile size of tiled encoding when the options use a single tile
+const defaultTileSize = 1024
+
+// encodedTile is a tile of planar samples ready for the encoder
+type encodedTile struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// WriteTiles encodes the tiles of src as JP2 and saves them to outputPath
+func (w *Writer) WriteTiles(src jp2.TileSource, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	return writeFile(outputPath, func(out io.Writer) (*metrics.WriteMetrics, error) {
+		return w.EncodeTiles(src, out, threads)
+	})
+}
+
+// EncodeTiles encodes the tiles of src as JP2 into out with OpenJPEG's tile by tile API,
+// so neither the RGBA image nor full-size component arrays are ever allocated
+// Tiles use the writer's tile size, or 1024 pixels when it encodes a single tile. The
+// next tile is read from src while the current one is encoded, and only the time spent
+// waiting for tiles counts as copy time
+func (w *Writer) EncodeTiles(src jp2.TileSource, out io.Writer, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
+
+	opts := w.opts
+	if opts.TileSize == 0 {
+		opts.TileSize = defaultTileSize
+	}
+	width, height := src.Size()
+
+	// Configure component parameters (RGBA) of an image without sample buffers
+	var cmptparm [4]C.opj_image_cmptparm_t
+	for i := 0; i < 4; i++ {
+		cmptparm[i].dx = 1
+		cmptparm[i].dy = 1
+		cmptparm[i].w = C.uint(width)
+		cmptparm[i].h = C.uint(height)
+		cmptparm[i].prec = 8
+		cmptparm[i].sgnd = 0
+	}
+	cimage := C.opj_image_tile_create(4, &cmptparm[0], C.OPJ_CLRSPC_SRGB)
+	if cimage == nil {
+		return nil, errors.New("failed to create OpenJPEG image")
+	}
+	defer C.opj_image_destroy(cimage)
+
+	cimage.x0 = 0
+	cimage.y0 = 0
+	cimage.x1 = C.uint(width)
+	cimage.y1 = C.uint(height)
+
+	codec, err := newEncoder(cimage, C.OPJ_CODEC_JP2, opts, threads)
+	if err != nil {
+		return nil, err
+	}
+	defer C.opj_destroy_codec(codec)
+
+	stream := newOutputStream(out)
+	cstream, err := stream.open()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.close(cstream)
+
+	startEncode := time.Now()
+	if C.opj_start_compress(codec, cimage, cstream) == C.OPJ_FALSE {
+		return nil, streamError("failed to start compression", stream)
+	}
+
+	// Read tiles in raster order one ahead of the encoder, reusing two buffers
+	tileSize := opts.TileSize
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	ready := make(chan encodedTile)
+	free := make(chan []byte, 2)
+	free <- make([]byte, tileSize*tileSize*4)
+	free <- make([]byte, tileSize*tileSize*4)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(ready)
+		rgba := make([]byte, tileSize*tileSize*4)
+		for index := 0; index < tilesX*tilesY; index++ {
+			x0 := (index % tilesX) * tileSize
+			y0 := (index / tilesX) * tileSize
+			r := image.Rect(x0, y0, min(x0+tileSize, width), min(y0+tileSize, height))
+
+			var data []byte
+			select {
+			case data = <-free:
+			case <-done:
+				return
+			}
+			n := r.Dx() * r.Dy()
+			err := src.ReadTile(r, rgba[:n*4])
+			if err == nil {
+				// OpenJPEG takes the samples of each component one after the other
+				data = data[:n*4]
+				for j := 0; j < n; j++ {
+					data[j] = rgba[j*4]
+					data[n+j] = rgba[j*4+1]
+					data[2*n+j] = rgba[j*4+2]
+					data[3*n+j] = rgba[j*4+3]
+				}
+			}
+
+			select {
+			case ready <- encodedTile{index: index, data: data, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		startWait := time.Now()
+		tile, ok := <-ready
+		writeMetrics.CopyTime += time.Since(startWait)
+		if !ok {
+			break
+		}
+		if tile.err != nil {
+			return nil, fmt.Errorf("failed to read tile %d: %v", tile.index, tile.err)
+		}
+		if C.opj_write_tile(codec, C.OPJ_UINT32(tile.index), (*C.OPJ_BYTE)(unsafe.Pointer(&tile.data[0])), C.OPJ_UINT32(len(tile.data)), cstream) == C.OPJ_FALSE {
+			return nil, streamError(fmt.Sprintf("failed to encode tile %d", tile.index), stream)
+		}
+		free <- tile.data[:cap(tile.data)]
+	}
+
+	// End the encoding process, which flushes the stream buffer
+	if C.opj_end_compress(codec, cstream) == C.OPJ_FALSE {
+		return nil, streamError("failed to end compression", stream)
+	}
+	if err := stream.flush(); err != nil {
+		return nil, fmt.Errorf("failed to write JP2 output: %v", err)
+	}
+	writeMetrics.FileTime = stream.ioTime
+	writeMetrics.EncodeTime = time.Since(startEncode) - writeMetrics.CopyTime - stream.ioTime
+
+	writeMetrics.Bytes = stream.size
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(width*height*4), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
@@ -4,9 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"os"
+	"io"
 	"time"
 	"unsafe"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+	"github.com/luismi/jp2_processing/pkg/output"
 )
 
 // #cgo CFLAGS: -I/home/linuxbrew/.linuxbrew/Cellar/openjpeg/2.5.3/include
@@ -17,26 +21,80 @@ import "C"
 
 // Writer implements jp2.Writer for CPU-based encoding
 type Writer struct {
-	outputPath string
+	opts jp2.EncodeOptions
+}
+
+// NewWriter creates a new CPU-based JP2 writer with the given encode options
+func NewWriter(opts jp2.EncodeOptions) (*Writer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Writer{opts: opts}, nil
 }
 
-// NewWriter creates a new CPU-based JP2 writer
-func NewWriter(outputPath string) *Writer {
-	return &Writer{outputPath: outputPath}
+// Write encodes an RGBA image with the writer's encode options and saves it as JP2
+func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	return writeFile(outputPath, func(out io.Writer) (*metrics.WriteMetrics, error) {
+		return w.Encode(img, out, threads)
+	})
 }
 
-// Update the Write method to match the jp2.Writer interface
-func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Duration, error) {
+// WritePaletted encodes a paletted image as a single 8-bit index component and saves
+// it with pclr and cmap boxes that map the indices to the palette colors
+// Indices are encoded losslessly whatever the writer's options
+func (w *Writer) WritePaletted(img *image.Paletted, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	return writeFile(outputPath, func(out io.Writer) (*metrics.WriteMetrics, error) {
+		return w.EncodePaletted(img, out, threads)
+	})
+}
+
+// WriteQuantized losslessly encodes int16 samples as a signed 16-bit component and
+// saves them with the quantization in an xml box
+func (w *Writer) WriteQuantized(samples []int16, width, height int, q jp2.Quantization, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	return writeFile(outputPath, func(out io.Writer) (*metrics.WriteMetrics, error) {
+		return w.EncodeQuantized(samples, width, height, q, out, threads)
+	})
+}
+
+// writeFile encodes into a temporary file renamed to outputPath once encoding succeeds,
+// creating its directory if needed, and adds the time to create and commit the file
+// to the metrics of encode
+func writeFile(outputPath string, encode func(out io.Writer) (*metrics.WriteMetrics, error)) (*metrics.WriteMetrics, error) {
 	startSave := time.Now()
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll("./go_jp2_direct", 0755); err != nil {
-		return 0, fmt.Errorf("failed to create directory: %v", err)
+	file, err := output.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	fileTime := time.Since(startSave)
+
+	writeMetrics, err := encode(file)
+	if err != nil {
+		file.Abort()
+		return nil, err
 	}
 
-	// Use the outputPath argument for the output filename
-	cfilename := C.CString(outputPath)
-	defer C.free(unsafe.Pointer(cfilename))
+	startCommit := time.Now()
+	if err := file.Commit(); err != nil {
+		return nil, err
+	}
+	writeMetrics.FileTime += fileTime + time.Since(startCommit)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// Encode encodes an RGBA image with the writer's encode options as JP2 into out
+// Seekable writers such as files are written while encoding; the output for other
+// writers is buffered in memory and written at the end. The time spent in out is
+// reported as file time. With a tile size, the image is encoded tile by tile with
+// EncodeTiles instead of being copied whole to the encoder
+func (w *Writer) Encode(img *image.RGBA, out io.Writer, threads int) (*metrics.WriteMetrics, error) {
+	if w.opts.TileSize > 0 {
+		return w.EncodeTiles(jp2.RGBATiles(img), out, threads)
+	}
+
+	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
 
 	// Get image dimensions
 	bounds := img.Bounds()
@@ -58,9 +116,10 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 	}
 
 	// Create OpenJPEG image
+	startCopy := time.Now()
 	cimage := C.opj_image_create(4, &cmptparm[0], C.OPJ_CLRSPC_SRGB)
 	if cimage == nil {
-		return 0, errors.New("failed to create OpenJPEG image")
+		return nil, errors.New("failed to create OpenJPEG image")
 	}
 	defer C.opj_image_destroy(cimage)
 
@@ -78,7 +137,7 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 		))
 
 		if comp.data == nil {
-			return 0, fmt.Errorf("component %d data is nil", i)
+			return nil, fmt.Errorf("component %d data is nil", i)
 		}
 
 		// Safer approach with explicit scope
@@ -87,7 +146,7 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 			for j := 0; j < pixelCount; j++ {
 				pixIndex := j*4 + i
 				if pixIndex >= len(pix) {
-					return 0, fmt.Errorf("index out of range: pixel %d, component %d", j, i)
+					return nil, fmt.Errorf("index out of range: pixel %d, component %d", j, i)
 				}
 				data[j] = C.int(pix[pixIndex])
 			}
@@ -96,27 +155,192 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 		}
 	}
 
+	writeMetrics.CopyTime = time.Since(startCopy)
+
+	startEncode := time.Now()
+	stream := newOutputStream(out)
+	if err := encodeImage(cimage, C.OPJ_CODEC_JP2, stream, w.opts, threads); err != nil {
+		return nil, err
+	}
+	if err := stream.flush(); err != nil {
+		return nil, fmt.Errorf("failed to write JP2 output: %v", err)
+	}
+	writeMetrics.FileTime = stream.ioTime
+	writeMetrics.EncodeTime = time.Since(startEncode) - stream.ioTime
+
+	writeMetrics.Bytes = stream.size
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(pixelCount*4), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// EncodePaletted encodes a paletted image as a single 8-bit index component into out,
+// with pclr and cmap boxes that map the indices to the palette colors
+// Indices are encoded losslessly whatever the writer's options
+func (w *Writer) EncodePaletted(img *image.Paletted, out io.Writer, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
+
+	// Get image dimensions
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	// Configure the index component
+	var cmptparm C.opj_image_cmptparm_t
+	cmptparm.dx = 1
+	cmptparm.dy = 1
+	cmptparm.w = C.uint(width)
+	cmptparm.h = C.uint(height)
+	cmptparm.prec = 8
+	cmptparm.sgnd = 0
+
+	// Create OpenJPEG image
+	startCopy := time.Now()
+	cimage := C.opj_image_create(1, &cmptparm, C.OPJ_CLRSPC_GRAY)
+	if cimage == nil {
+		return nil, errors.New("failed to create OpenJPEG image")
+	}
+	defer C.opj_image_destroy(cimage)
+
+	cimage.x0 = 0
+	cimage.y0 = 0
+	cimage.x1 = C.uint(width)
+	cimage.y1 = C.uint(height)
+
+	// Copy palette indices row by row
+	if cimage.comps.data == nil {
+		return nil, errors.New("component 0 data is nil")
+	}
+	data := unsafe.Slice((*C.int)(cimage.comps.data), width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width]
+		for x, index := range row {
+			data[y*width+x] = C.int(index)
+		}
+	}
+	writeMetrics.CopyTime = time.Since(startCopy)
+
+	container := &jp2.Container{
+		Width:      width,
+		Height:     height,
+		Components: 1,
+		Precision:  8,
+		ColorSpace: jp2.EnumSRGB,
+		Palette:    img.Palette,
+	}
+	if err := encodeContainer(cimage, container, out, w.opts.Reversible(), threads, writeMetrics); err != nil {
+		return nil, err
+	}
+
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(width*height), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// EncodeQuantized losslessly encodes int16 samples as a signed 16-bit component into
+// out, with the quantization in an xml box
+func (w *Writer) EncodeQuantized(samples []int16, width, height int, q jp2.Quantization, out io.Writer, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
+
+	if len(samples) != width*height {
+		return nil, fmt.Errorf("got %d samples for a %dx%d image", len(samples), width, height)
+	}
+
+	// Configure the signed 16-bit component
+	var cmptparm C.opj_image_cmptparm_t
+	cmptparm.dx = 1
+	cmptparm.dy = 1
+	cmptparm.w = C.uint(width)
+	cmptparm.h = C.uint(height)
+	cmptparm.prec = 16
+	cmptparm.sgnd = 1
+
+	// Create OpenJPEG image
+	startCopy := time.Now()
+	cimage := C.opj_image_create(1, &cmptparm, C.OPJ_CLRSPC_GRAY)
+	if cimage == nil {
+		return nil, errors.New("failed to create OpenJPEG image")
+	}
+	defer C.opj_image_destroy(cimage)
+
+	cimage.x0 = 0
+	cimage.y0 = 0
+	cimage.x1 = C.uint(width)
+	cimage.y1 = C.uint(height)
+
+	// Copy samples
+	if cimage.comps.data == nil {
+		return nil, errors.New("component 0 data is nil")
+	}
+	data := unsafe.Slice((*C.int)(cimage.comps.data), width*height)
+	for i, sample := range samples {
+		data[i] = C.int(sample)
+	}
+	writeMetrics.CopyTime = time.Since(startCopy)
+
+	xmlBox, err := q.MarshalBox()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode quantization: %v", err)
+	}
+	container := &jp2.Container{
+		Width:      width,
+		Height:     height,
+		Components: 1,
+		Precision:  16,
+		Signed:     true,
+		ColorSpace: jp2.EnumGreyscale,
+		XML:        [][]byte{xmlBox},
+	}
+	if err := encodeContainer(cimage, container, out, w.opts.Reversible(), threads, writeMetrics); err != nil {
+		return nil, err
+	}
+
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(width*height*2), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// encodeContainer encodes an OpenJPEG image as a raw codestream in memory and writes
+// it to out wrapped in the container boxes, which OpenJPEG cannot write itself
+// Sets the encode time, file time and bytes written of writeMetrics
+func encodeContainer(cimage *C.opj_image_t, container *jp2.Container, out io.Writer, opts jp2.EncodeOptions, threads int, writeMetrics *metrics.WriteMetrics) error {
+	startEncode := time.Now()
+	codestream := &outputStream{}
+	if err := encodeImage(cimage, C.OPJ_CODEC_J2K, codestream, opts, threads); err != nil {
+		return err
+	}
+	writeMetrics.EncodeTime = time.Since(startEncode)
+
+	startFile := time.Now()
+	counter := &countingWriter{w: out}
+	if err := jp2.WriteContainer(counter, container, codestream.buf); err != nil {
+		return fmt.Errorf("failed to write JP2 output: %v", err)
+	}
+	writeMetrics.FileTime = time.Since(startFile)
+	writeMetrics.Bytes = counter.n
+	return nil
+}
+
+// newEncoder creates an OpenJPEG compression codec for an image with the given format
+// and options. The codec must be destroyed with opj_destroy_codec
+func newEncoder(cimage *C.opj_image_t, format C.OPJ_CODEC_FORMAT, opts jp2.EncodeOptions, threads int) (*C.opj_codec_t, error) {
 	// Configure encoder parameters
 	var parameters C.opj_cparameters_t
 	C.opj_set_default_encoder_parameters(&parameters)
+	setEncodeParameters(&parameters, opts)
 
-	// Configuration for JP2 encoding
-	parameters.tcp_numlayers = 1
-	parameters.tcp_rates[0] = 0
-	parameters.irreversible = 0
-	parameters.numresolution = 6
-	parameters.cp_disto_alloc = 1
-
-	// Create JP2 codec
-	codec := C.opj_create_compress(C.OPJ_CODEC_JP2)
+	// Create codec
+	codec := C.opj_create_compress(format)
 	if codec == nil {
-		return 0, errors.New("failed to create JP2 codec")
+		return nil, errors.New("failed to create JP2 codec")
 	}
-	defer C.opj_destroy_codec(codec)
 
 	// Set up the encoder with the image and parameters
 	if C.opj_setup_encoder(codec, &parameters, cimage) == C.OPJ_FALSE {
-		return 0, errors.New("failed to setup encoder")
+		C.opj_destroy_codec(codec)
+		return nil, errors.New("failed to setup encoder")
 	}
 
 	// Configure threads if more than 1 is specified
@@ -125,29 +349,88 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 			fmt.Printf("Warning: Could not configure %d threads for encoding\n", threads)
 		}
 	}
+	return codec, nil
+}
+
+// encodeImage encodes an OpenJPEG image into an output stream with the given codec
+// and options
+func encodeImage(cimage *C.opj_image_t, format C.OPJ_CODEC_FORMAT, out *outputStream, opts jp2.EncodeOptions, threads int) error {
+	codec, err := newEncoder(cimage, format, opts, threads)
+	if err != nil {
+		return err
+	}
+	defer C.opj_destroy_codec(codec)
 
-	// Create and configure a stream for writing
-	stream := C.opj_stream_create_default_file_stream(cfilename, 0)
-	if stream == nil {
-		return 0, fmt.Errorf("failed to create file stream for %s", w.outputPath)
+	// Create a stream writing through the Go callbacks
+	stream, err := out.open()
+	if err != nil {
+		return err
 	}
-	defer C.opj_stream_destroy(stream)
+	defer out.close(stream)
 
 	// Start the encoding process
 	if C.opj_start_compress(codec, cimage, stream) == C.OPJ_FALSE {
-		return 0, errors.New("failed to start compression")
+		return streamError("failed to start compression", out)
 	}
 
 	// Encode the image
 	if C.opj_encode(codec, stream) == C.OPJ_FALSE {
-		return 0, errors.New("failed to encode image")
+		return streamError("failed to encode image", out)
 	}
 
-	// End the encoding process
+	// End the encoding process, which flushes the stream buffer
 	if C.opj_end_compress(codec, stream) == C.OPJ_FALSE {
-		return 0, errors.New("failed to end compression")
+		return streamError("failed to end compression", out)
+	}
+
+	return nil
+}
+
+// streamError returns an encoding error, with the error of the output if it caused it
+func streamError(message string, out *outputStream) error {
+	if out.err != nil {
+		return fmt.Errorf("%s: %v", message, out.err)
+	}
+	return errors.New(message)
+}
+
+// setEncodeParameters applies encode options to OpenJPEG compression parameters
+func setEncodeParameters(parameters *C.opj_cparameters_t, opts jp2.EncodeOptions) {
+	parameters.tcp_numlayers = C.int(opts.NumLayers())
+	parameters.irreversible = 1
+	if opts.Lossless {
+		parameters.irreversible = 0
+	}
+
+	// Layers target a PSNR or a compression ratio; a ratio of 0 keeps every bit plane,
+	// as in the last layer of lossless encoding
+	if len(opts.PSNR) > 0 {
+		parameters.cp_fixed_quality = 1
+		for i, psnr := range opts.PSNR {
+			parameters.tcp_distoratio[i] = C.float(psnr)
+		}
+	} else {
+		parameters.cp_disto_alloc = 1
+		for i, rate := range opts.Rates {
+			parameters.tcp_rates[i] = C.float(rate)
+		}
 	}
 
-	saveTime := time.Since(startSave)
-	return saveTime, nil
+	parameters.numresolution = C.int(opts.Resolutions)
+	parameters.cblockw_init = C.int(opts.CodeblockSize)
+	parameters.cblockh_init = C.int(opts.CodeblockSize)
+	parameters.prog_order = C.OPJ_PROG_ORDER(opts.Progression)
+	if opts.TileSize > 0 {
+		parameters.tile_size_on = C.OPJ_TRUE
+		parameters.cp_tdx = C.int(opts.TileSize)
+		parameters.cp_tdy = C.int(opts.TileSize)
+	}
+	if sizes := opts.PrecinctSizes(); sizes != nil {
+		parameters.csty |= 0x01 // Custom precincts
+		parameters.res_spec = C.int(len(sizes))
+		for i, size := range sizes {
+			parameters.prcw_init[i] = C.int(size)
+			parameters.prch_init[i] = C.int(size)
+		}
+	}
 }
@@ -0,0 +1,355 @@
+package jp2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+)
+
+// geoJP2UUID identifies the uuid box holding a GeoJP2 degenerate GeoTIFF
+var geoJP2UUID = []byte{0xb1, 0x4b, 0xf8, 0xbd, 0x08, 0x3d, 0x4b, 0x43, 0xa5, 0xae, 0x8c, 0xd7, 0xd5, 0xa6, 0xce, 0x03}
+
+// GeoTIFF tags and keys read from GeoJP2 boxes
+const (
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagModelTransformation = 34264
+	tagGeoKeyDirectory     = 34735
+
+	keyRasterType      = 1025
+	keyGeographicType  = 2048
+	keyProjectedCSType = 3072
+
+	rasterPixelIsPoint = 2
+)
+
+// ReadGeoreference reads the georeferencing of a JP2 file from its GeoJP2 uuid box
+// or, failing that, from the RectifiedGrid of its GMLJP2 boxes
+// Returns nil without error when the file carries no georeferencing
+func ReadGeoreference(path string) (*geo.Georeference, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	var geoJP2 []byte
+	var gml [][]byte
+	for {
+		boxType, content, err := readBox(f, func(boxType string) bool {
+			return boxType == "uuid" || boxType == "asoc" || boxType == "xml "
+		})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch boxType {
+		case "uuid":
+			if len(content) > 16 && bytes.Equal(content[:16], geoJP2UUID) {
+				geoJP2 = content[16:]
+			}
+		case "asoc":
+			docs, err := asocXML(content)
+			if err != nil {
+				return nil, err
+			}
+			gml = append(gml, docs...)
+		case "xml ":
+			gml = append(gml, content)
+		}
+	}
+
+	if geoJP2 != nil {
+		g, err := parseGeoJP2(geoJP2)
+		if err != nil {
+			return nil, fmt.Errorf("GeoJP2 box: %v", err)
+		}
+		return g, nil
+	}
+	for _, doc := range gml {
+		g, err := parseGML(doc)
+		if err != nil {
+			return nil, fmt.Errorf("GMLJP2 box: %v", err)
+		}
+		if g != nil {
+			return g, nil
+		}
+	}
+	return nil, nil
+}
+
+// asocXML returns the contents of the xml boxes nested in an association box
+func asocXML(asoc []byte) ([][]byte, error) {
+	var docs [][]byte
+	for len(asoc) >= 8 {
+		length := int(binary.BigEndian.Uint32(asoc))
+		boxType := string(asoc[4:8])
+		if length == 0 {
+			length = len(asoc)
+		}
+		if length < 8 || length > len(asoc) {
+			return nil, fmt.Errorf("invalid %q box length %d", boxType, length)
+		}
+		content := asoc[8:length]
+		asoc = asoc[length:]
+
+		switch boxType {
+		case "asoc":
+			nested, err := asocXML(content)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, nested...)
+		case "xml ":
+			docs = append(docs, content)
+		}
+	}
+	return docs, nil
+}
+
+// parseGML reads the origin and offset vectors of the first gml:RectifiedGrid of a
+// GMLJP2 document; the origin is the center of the top-left pixel
+// Returns nil without error when the document has no RectifiedGrid
+func parseGML(doc []byte) (*geo.Georeference, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var text strings.Builder
+	var srsName string
+	var origin []float64
+	var offsets [][]float64
+	inGrid, inOrigin, found := false, false, false
+
+	for !found {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid GML: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "RectifiedGrid":
+				inGrid = true
+			case "origin":
+				inOrigin = inGrid
+			}
+			if inGrid && srsName == "" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "srsName" {
+						srsName = attr.Value
+					}
+				}
+			}
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if !inGrid {
+				continue
+			}
+			switch t.Name.Local {
+			case "pos", "coordinates":
+				if inOrigin {
+					if origin, err = parseFloats(text.String()); err != nil {
+						return nil, err
+					}
+				}
+			case "origin":
+				inOrigin = false
+			case "offsetVector":
+				v, err := parseFloats(text.String())
+				if err != nil {
+					return nil, err
+				}
+				offsets = append(offsets, v)
+			case "RectifiedGrid":
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	if len(origin) < 2 || len(offsets) < 2 || len(offsets[0]) < 2 || len(offsets[1]) < 2 {
+		return nil, fmt.Errorf("incomplete RectifiedGrid")
+	}
+
+	// EPSG geographic CRS given by URN or URL list latitude first
+	epsg := parseEPSG(srsName)
+	ox, oy := origin[0], origin[1]
+	colX, colY := offsets[0][0], offsets[0][1]
+	rowX, rowY := offsets[1][0], offsets[1][1]
+	if geo.IsGeographic(epsg) && !strings.HasPrefix(strings.ToUpper(srsName), "EPSG:") {
+		ox, oy = oy, ox
+		colX, colY = colY, colX
+		rowX, rowY = rowY, rowX
+	}
+
+	return &geo.Georeference{
+		EPSG: epsg,
+		GeoTransform: [6]float64{
+			ox - 0.5*colX - 0.5*rowX, colX, rowX,
+			oy - 0.5*colY - 0.5*rowY, colY, rowY,
+		},
+	}, nil
+}
+
+// parseEPSG extracts the EPSG code of a CRS name such as "urn:ogc:def:crs:EPSG::32630",
+// "EPSG:32630" or "http://www.opengis.net/def/crs/EPSG/0/32630", or returns 0
+func parseEPSG(name string) int {
+	if !strings.Contains(strings.ToUpper(name), "EPSG") {
+		return 0
+	}
+	code := name[strings.LastIndexAny(name, ":/#")+1:]
+	epsg, err := strconv.Atoi(code)
+	if err != nil {
+		return 0
+	}
+	return epsg
+}
+
+// parseFloats parses numbers separated by spaces or commas
+func parseFloats(s string) ([]float64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' })
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", field)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// parseGeoJP2 reads the model tags and GeoKeys of the degenerate GeoTIFF of a GeoJP2 box
+func parseGeoJP2(tiff []byte) (*geo.Georeference, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("truncated TIFF")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil, fmt.Errorf("unsupported TIFF version")
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return nil, fmt.Errorf("truncated TIFF directory")
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	if ifd+2+entries*12 > len(tiff) {
+		return nil, fmt.Errorf("truncated TIFF directory")
+	}
+
+	tags := make(map[uint16][]float64)
+	for i := 0; i < entries; i++ {
+		entry := tiff[ifd+2+i*12:]
+		tag := order.Uint16(entry)
+		switch tag {
+		case tagModelPixelScale, tagModelTiepoint, tagModelTransformation, tagGeoKeyDirectory:
+			values, err := tiffValues(tiff, order, entry)
+			if err != nil {
+				return nil, fmt.Errorf("tag %d: %v", tag, err)
+			}
+			tags[tag] = values
+		}
+	}
+
+	g := &geo.Georeference{}
+	scale, tiepoint, transform := tags[tagModelPixelScale], tags[tagModelTiepoint], tags[tagModelTransformation]
+	switch {
+	case len(transform) >= 16:
+		g.GeoTransform = [6]float64{transform[3], transform[0], transform[1], transform[7], transform[4], transform[5]}
+	case len(scale) >= 2 && len(tiepoint) >= 6:
+		g.GeoTransform = [6]float64{
+			tiepoint[3] - tiepoint[0]*scale[0], scale[0], 0,
+			tiepoint[4] + tiepoint[1]*scale[1], 0, -scale[1],
+		}
+	default:
+		return nil, fmt.Errorf("missing model tags")
+	}
+
+	// GeoKey directory: a header of 4 values followed by 4 values per key
+	keys := tags[tagGeoKeyDirectory]
+	for k := 4; k+3 < len(keys); k += 4 {
+		if keys[k+1] != 0 {
+			continue // Values stored in other tags are not needed
+		}
+		switch int(keys[k]) {
+		case keyProjectedCSType, keyGeographicType:
+			if g.EPSG == 0 && keys[k+3] != 32767 {
+				g.EPSG = int(keys[k+3])
+			}
+		case keyRasterType:
+			if keys[k+3] == rasterPixelIsPoint {
+				// Model coordinates refer to pixel centers
+				t := &g.GeoTransform
+				t[0] -= 0.5*t[1] + 0.5*t[2]
+				t[3] -= 0.5*t[4] + 0.5*t[5]
+			}
+		}
+	}
+	return g, nil
+}
+
+// tiffValues returns the SHORT, LONG or DOUBLE values of a TIFF directory entry
+func tiffValues(tiff []byte, order binary.ByteOrder, entry []byte) ([]float64, error) {
+	typ := order.Uint16(entry[2:])
+	count := int(order.Uint32(entry[4:]))
+
+	size := 0
+	switch typ {
+	case 3: // SHORT
+		size = 2
+	case 4: // LONG
+		size = 4
+	case 12: // DOUBLE
+		size = 8
+	default:
+		return nil, fmt.Errorf("unsupported type %d", typ)
+	}
+
+	data := entry[8:12]
+	if count*size > 4 {
+		offset := int(order.Uint32(entry[8:]))
+		if offset < 0 || count < 0 || offset+count*size > len(tiff) {
+			return nil, fmt.Errorf("value out of bounds")
+		}
+		data = tiff[offset : offset+count*size]
+	}
+
+	values := make([]float64, count)
+	for i := range values {
+		switch size {
+		case 2:
+			values[i] = float64(order.Uint16(data[i*2:]))
+		case 4:
+			values[i] = float64(order.Uint32(data[i*4:]))
+		case 8:
+			values[i] = math.Float64frombits(order.Uint64(data[i*8:]))
+		}
+	}
+	return values, nil
+}
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"image"
 	"os"
+	"path/filepath"
 	"time"
 	"unsafe"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+	"github.com/luismi/jp2_processing/pkg/output"
 )
 
 // #cgo LDFLAGS: -L/usr/local/cuda/lib64 -lcudart -lnvjpeg2k
@@ -16,53 +21,61 @@ import "C"
 
 // Writer implements jp2.Writer for GPU-based encoding
 type Writer struct {
-	outputPath string
+	opts jp2.EncodeOptions
 }
 
-// NewWriter creates a new GPU-based JP2 writer
-func NewWriter(outputPath string) *Writer {
-	return &Writer{outputPath: outputPath}
+// NewWriter creates a new GPU-based JP2 writer with the given encode options
+// nvJPEG2000 writes a single quality layer and targets PSNR only, so compression
+// ratios and several layers are rejected
+func NewWriter(opts jp2.EncodeOptions) (*Writer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Rates) > 0 || opts.NumLayers() > 1 {
+		return nil, fmt.Errorf("nvJPEG2000 encodes a single layer without compression ratio targets")
+	}
+	return &Writer{opts: opts}, nil
 }
 
-// Update the Write method to match the jp2.Writer interface
-func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Duration, error) {
+// Write encodes an RGBA image with the writer's encode options and saves it as JP2
+func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (*metrics.WriteMetrics, error) {
 	startSave := time.Now()
+	writeMetrics := &metrics.WriteMetrics{}
 
 	// Create directory if it doesn't exist
-	if err := os.MkdirAll("./go_jp2_direct", 0755); err != nil {
-		return 0, fmt.Errorf("failed to create directory: %v", err)
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	// Use the outputPath argument for the output filename
-	outputName := outputPath
-
 	// Initialize nvjpeg2k encoder
 	var encoder C.nvjpeg2kEncoder_t
 	if status := C.nvjpeg2kEncoderCreateSimple(&encoder); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to create encoder: %v", status)
+		return nil, fmt.Errorf("failed to create encoder: %v", status)
 	}
 	defer C.nvjpeg2kEncoderDestroy(encoder)
 
 	var encodeState C.nvjpeg2kEncodeState_t
 	if status := C.nvjpeg2kEncodeStateCreate(encoder, &encodeState); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to create encode state: %v", status)
+		return nil, fmt.Errorf("failed to create encode state: %v", status)
 	}
 	defer C.nvjpeg2kEncodeStateDestroy(encodeState)
 
 	var encodeParams C.nvjpeg2kEncodeParams_t
 	if status := C.nvjpeg2kEncodeParamsCreate(&encodeParams); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to create encode params: %v", status)
+		return nil, fmt.Errorf("failed to create encode params: %v", status)
 	}
 	defer C.nvjpeg2kEncodeParamsDestroy(encodeParams)
 
 	// Configure input format for RGBA data (interleaved)
 	if status := C.nvjpeg2kEncodeParamsSetInputFormat(encodeParams, C.NVJPEG2K_FORMAT_INTERLEAVED); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to set input format: %v", status)
+		return nil, fmt.Errorf("failed to set input format: %v", status)
 	}
 
 	// Configure quality (using PSNR - higher number = better quality)
-	if status := C.nvjpeg2kEncodeParamsSetQuality(encodeParams, 40.0); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to set quality: %v", status)
+	if len(w.opts.PSNR) > 0 {
+		if status := C.nvjpeg2kEncodeParamsSetQuality(encodeParams, C.double(w.opts.PSNR[0])); status != C.NVJPEG2K_STATUS_SUCCESS {
+			return nil, fmt.Errorf("failed to set quality: %v", status)
+		}
 	}
 
 	// Image dimensions
@@ -76,6 +89,10 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 	defer C.free(compInfoPtr)
 
 	// Configure encoding configuration structure
+	irreversible := 1 // Irreversible transform for better compression
+	if w.opts.Lossless {
+		irreversible = 0
+	}
 	encodeConfig := C.nvjpeg2kEncodeConfig_t{
 		stream_type:     C.NVJPEG2K_STREAM_JP2,      // JP2 format
 		color_space:     C.NVJPEG2K_COLORSPACE_SRGB, // RGB Color
@@ -83,13 +100,26 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 		image_height:    C.uint32_t(height),
 		num_components:  4, // RGBA
 		image_comp_info: (*C.nvjpeg2kImageComponentInfo_t)(compInfoPtr),
-		prog_order:      C.NVJPEG2K_LRCP, // Standard progression order
+		prog_order:      C.nvjpeg2kProgOrder(w.opts.Progression),
 		num_layers:      1,
-		mct_mode:        1,  // Color transform enabled
-		num_resolutions: 6,  // Number of resolution levels
-		code_block_w:    64, // Code block width
-		code_block_h:    64, // Code block height
-		irreversible:    1,  // Irreversible transform for better compression
+		mct_mode:        1, // Color transform enabled
+		num_resolutions: C.uint32_t(w.opts.Resolutions),
+		code_block_w:    C.uint32_t(w.opts.CodeblockSize),
+		code_block_h:    C.uint32_t(w.opts.CodeblockSize),
+		irreversible:    C.uint32_t(irreversible),
+	}
+	if w.opts.TileSize > 0 {
+		encodeConfig.enable_tiling = 1
+		encodeConfig.tile_width = C.uint32_t(w.opts.TileSize)
+		encodeConfig.tile_height = C.uint32_t(w.opts.TileSize)
+	}
+	// nvJPEG2000 lists precincts from the lowest resolution
+	if sizes := w.opts.PrecinctSizes(); sizes != nil {
+		encodeConfig.num_precincts_init = C.uint32_t(len(sizes))
+		for i, size := range sizes {
+			encodeConfig.precint_width[len(sizes)-1-i] = C.uint32_t(size)
+			encodeConfig.precint_height[len(sizes)-1-i] = C.uint32_t(size)
+		}
 	}
 
 	// Configure each component
@@ -105,7 +135,7 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 
 	// Apply encoding configuration
 	if status := C.nvjpeg2kEncodeParamsSetEncodeConfig(encodeParams, &encodeConfig); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to set encode config: %v", status)
+		return nil, fmt.Errorf("failed to set encode config: %v", status)
 	}
 
 	// Prepare image for GPU encoding
@@ -119,11 +149,12 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 	defer C.free(unsafe.Pointer(outputImage.pitch_in_bytes))
 
 	// Allocate GPU memory for image data
+	startCopy := time.Now()
 	var devicePtr unsafe.Pointer
 	totalSize := width * height * 4 // 4 bytes per RGBA pixel
 
 	if status := C.cudaMalloc(&devicePtr, C.size_t(totalSize)); status != C.cudaSuccess {
-		return 0, fmt.Errorf("cudaMalloc failed: %v", status)
+		return nil, fmt.Errorf("cudaMalloc failed: %v", status)
 	}
 	defer C.cudaFree(devicePtr)
 
@@ -134,9 +165,11 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 		C.size_t(totalSize),
 		C.cudaMemcpyHostToDevice,
 	); status != C.cudaSuccess {
-		return 0, fmt.Errorf("cudaMemcpy to device failed: %v", status)
+		return nil, fmt.Errorf("cudaMemcpy to device failed: %v", status)
 	}
 
+	writeMetrics.CopyTime = time.Since(startCopy)
+
 	// Configure pointer and pitch for the image
 	ptrArray := (*[4]*C.uchar)(unsafe.Pointer(outputImage.pixel_data))
 	ptrArray[0] = (*C.uchar)(devicePtr)
@@ -145,14 +178,15 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 	pitchArray[0] = C.size_t(width * 4) // 4 bytes per pixel (RGBA)
 
 	// Encode the image
+	startEncode := time.Now()
 	if status := C.nvjpeg2kEncode(encoder, encodeState, encodeParams, &outputImage, nil); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("encode failed: %v", status)
+		return nil, fmt.Errorf("encode failed: %v", status)
 	}
 
 	// Get encoded bitstream size
 	var length C.size_t
 	if status := C.nvjpeg2kEncodeRetrieveBitstream(encoder, encodeState, nil, &length, nil); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to get bitstream size: %v", status)
+		return nil, fmt.Errorf("failed to get bitstream size: %v", status)
 	}
 
 	// Allocate memory for the bitstream
@@ -166,14 +200,20 @@ func (w *Writer) Write(img *image.RGBA, outputPath string, threads int) (time.Du
 		&length,
 		nil,
 	); status != C.NVJPEG2K_STATUS_SUCCESS {
-		return 0, fmt.Errorf("failed to retrieve bitstream: %v", status)
+		return nil, fmt.Errorf("failed to retrieve bitstream: %v", status)
 	}
 
+	writeMetrics.EncodeTime = time.Since(startEncode)
+
 	// Save to file
-	if err := os.WriteFile(outputName, bitstreamData[:int(length)], 0644); err != nil {
-		return 0, fmt.Errorf("failed to write file: %v", err)
+	startFile := time.Now()
+	if err := output.WriteFile(outputPath, bitstreamData[:int(length)]); err != nil {
+		return nil, err
 	}
+	writeMetrics.FileTime = time.Since(startFile)
 
-	saveTime := time.Since(startSave)
-	return saveTime, nil
+	writeMetrics.Bytes = int64(length)
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(totalSize), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
 }
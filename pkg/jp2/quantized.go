@@ -0,0 +1,134 @@
+package jp2
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Quantization describes how the integer samples of a data product map to physical
+// values: value = raw*Scale + Offset, with NoData reserved for pixels without data
+type Quantization struct {
+	XMLName xml.Name `xml:"Quantization"`
+	Scale   float64  `xml:"Scale"`
+	Offset  float64  `xml:"Offset"`
+	NoData  int16    `xml:"NoData"`
+	Units   string   `xml:"Units,omitempty"`
+}
+
+// NDVIQuantization returns NDVI scaled by 10000 with -32768 as no data
+func NDVIQuantization() Quantization {
+	return Quantization{
+		Scale:  0.0001,
+		Offset: 0,
+		NoData: math.MinInt16,
+		Units:  "NDVI",
+	}
+}
+
+// Quantize returns the integer sample of a physical value, clamped to the int16 range
+// and never equal to the no-data code
+func (q Quantization) Quantize(value float64) int16 {
+	raw := math.Round((value - q.Offset) / q.Scale)
+	raw = math.Max(math.MinInt16, math.Min(math.MaxInt16, raw))
+	if int16(raw) == q.NoData {
+		if raw > math.MinInt16 {
+			raw--
+		} else {
+			raw++
+		}
+	}
+	return int16(raw)
+}
+
+// Dequantize returns the physical value of an integer sample
+func (q Quantization) Dequantize(raw int16) float64 {
+	return float64(raw)*q.Scale + q.Offset
+}
+
+// MarshalBox returns the content of the xml box describing the quantization
+func (q Quantization) MarshalBox() ([]byte, error) {
+	content, err := xml.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// ParseQuantization finds the quantization among the xml boxes of a container
+func ParseQuantization(c *Container) (*Quantization, error) {
+	for _, content := range c.XML {
+		var q Quantization
+		if err := xml.Unmarshal(content, &q); err == nil && q.Scale != 0 {
+			return &q, nil
+		}
+	}
+	return nil, fmt.Errorf("no quantization xml box")
+}
+
+// QuantizedWriter is implemented by writers that can save single-band int16 data products
+type QuantizedWriter interface {
+	// WriteQuantized losslessly encodes int16 samples as a signed 16-bit JPEG2000 with
+	// the quantization in an xml box
+	// Returns the time and size of each stage of the write and any error that occurred
+	WriteQuantized(samples []int16, width, height int, q Quantization, outputPath string, threads int) (*metrics.WriteMetrics, error)
+}
+
+// QuantizedBand is a dequantized single-band data product
+type QuantizedBand struct {
+	Width, Height int
+	Values        []float64 // Physical values, 0 where there is no data
+	Valid         []bool
+	Quantization  Quantization
+}
+
+// ReadQuantized reads a data product written by a QuantizedWriter with any reader and
+// returns its dequantized values
+func ReadQuantized(reader Reader, filePath string, threads int) (*QuantizedBand, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %v", err)
+	}
+	container, err := ReadContainer(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+	if container.Components != 1 || container.Precision > 16 {
+		return nil, fmt.Errorf("%s: expected a single band of at most 16 bits, got %d components of %d bits", filePath, container.Components, container.Precision)
+	}
+	q, err := ParseQuantization(container)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	band, err := reader.Read(filePath, threads)
+	if err != nil {
+		return nil, err
+	}
+	defer band.Free()
+
+	// Readers normalize samples by 2^(precision-1), which is exact for 16-bit samples in float32
+	factor := math.Pow(2, float64(container.Precision-1))
+	samples := band.Image.Data[0]
+	product := &QuantizedBand{
+		Width:        band.Image.Width,
+		Height:       band.Image.Height,
+		Values:       make([]float64, len(samples)),
+		Valid:        make([]bool, len(samples)),
+		Quantization: *q,
+	}
+	for i, s := range samples {
+		raw := int16(math.Round(float64(s) * factor))
+		if raw == q.NoData {
+			continue
+		}
+		product.Values[i] = q.Dequantize(raw)
+		product.Valid[i] = true
+	}
+
+	return product, nil
+}
@@ -1,13 +1,245 @@
 package jp2
 
 import (
+	"fmt"
 	"image"
-	"time"
+	"io"
+	"strings"
+
+	"github.com/luismi/jp2_processing/pkg/metrics"
 )
 
 // Writer is the interface for JPEG2000 image writers
 type Writer interface {
-	// Write encodes and saves an RGBA image as JPEG2000
-	// Returns the time taken to write the image and any error that occurred
-	Write(img *image.RGBA, resolution string, threads int) (time.Duration, error)
+	// Write encodes an RGBA image as JPEG2000 and saves it to outputPath, creating its
+	// directory if needed
+	// Returns the time and size of each stage of the write and any error that occurred
+	Write(img *image.RGBA, outputPath string, threads int) (*metrics.WriteMetrics, error)
+}
+
+// PalettedWriter is implemented by writers that can save paletted images as a single
+// 8-bit index component with a JP2 palette
+type PalettedWriter interface {
+	// WritePaletted encodes and saves a paletted image as JPEG2000; indices are always
+	// encoded losslessly
+	// Returns the time and size of each stage of the write and any error that occurred
+	WritePaletted(img *image.Paletted, outputPath string, threads int) (*metrics.WriteMetrics, error)
+}
+
+// StreamWriter is implemented by writers that can encode to any io.Writer, such as an
+// HTTP response, an in-memory buffer or an archive entry
+type StreamWriter interface {
+	// Encode encodes an RGBA image as JPEG2000 into w; writers that cannot seek get the
+	// output buffered in memory and written once encoding ends
+	// Returns the time and size of each stage of the write and any error that occurred
+	Encode(img *image.RGBA, w io.Writer, threads int) (*metrics.WriteMetrics, error)
+}
+
+// TileSource produces the RGBA pixels of an image one region at a time, so the image
+// can be encoded without ever holding all of its pixels
+type TileSource interface {
+	// Size returns the width and height of the image
+	Size() (width, height int)
+	// ReadTile fills dst, 4*r.Dx()*r.Dy() bytes, with the RGBA pixels of r row by row
+	ReadTile(r image.Rectangle, dst []uint8) error
+}
+
+// TiledWriter is implemented by writers that encode JPEG2000 tile by tile from a
+// TileSource, requesting each tile only when the encoder needs it
+type TiledWriter interface {
+	// WriteTiles encodes the tiles of src as JPEG2000 and saves them to outputPath
+	// Returns the time and size of each stage of the write and any error that occurred
+	WriteTiles(src TileSource, outputPath string, threads int) (*metrics.WriteMetrics, error)
+}
+
+// rgbaTiles is a TileSource reading the pixels of an RGBA image
+type rgbaTiles struct {
+	img *image.RGBA
+}
+
+// RGBATiles returns a TileSource reading the pixels of an RGBA image
+func RGBATiles(img *image.RGBA) TileSource {
+	return rgbaTiles{img}
+}
+
+func (t rgbaTiles) Size() (int, int) {
+	return t.img.Bounds().Dx(), t.img.Bounds().Dy()
+}
+
+func (t rgbaTiles) ReadTile(r image.Rectangle, dst []uint8) error {
+	rowBytes := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := t.img.PixOffset(t.img.Rect.Min.X+r.Min.X, t.img.Rect.Min.Y+y)
+		copy(dst[(y-r.Min.Y)*rowBytes:], t.img.Pix[i:i+rowBytes])
+	}
+	return nil
+}
+
+// ProgressionOrder is the order of the packets of a JPEG2000 codestream: by Layer,
+// Resolution, Component and Position (precinct)
+// The values match both OpenJPEG and nvJPEG2000
+type ProgressionOrder int
+
+// Progression orders
+const (
+	LRCP ProgressionOrder = iota
+	RLCP
+	RPCL
+	PCRL
+	CPRL
+)
+
+var progressionNames = []string{"LRCP", "RLCP", "RPCL", "PCRL", "CPRL"}
+
+// ParseProgressionOrder parses a progression order name such as RPCL
+func ParseProgressionOrder(name string) (ProgressionOrder, error) {
+	for i, n := range progressionNames {
+		if strings.EqualFold(name, n) {
+			return ProgressionOrder(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown progression order %q (use %s)", name, strings.Join(progressionNames, ", "))
+}
+
+// String returns the name of a progression order
+func (p ProgressionOrder) String() string {
+	if p < 0 || int(p) >= len(progressionNames) {
+		return fmt.Sprintf("ProgressionOrder(%d)", int(p))
+	}
+	return progressionNames[p]
+}
+
+// EncodeOptions configures JPEG2000 encoding for every writer
+// Lossless encoding uses the reversible 5/3 wavelet and color transforms, and its last
+// quality layer is lossless; lossy encoding uses the irreversible 9/7 ones and targets
+// a compression ratio or a PSNR per layer
+type EncodeOptions struct {
+	Lossless      bool
+	Rates         []float64        // Compression ratio of each layer, decreasing; for lossless encoding, of the layers before the lossless one
+	PSNR          []float64        // Target PSNR in dB of each layer, increasing, instead of Rates (lossy only)
+	Layers        int              // Number of quality layers, 0 to derive it from Rates or PSNR
+	Resolutions   int              // Number of resolution levels, one more than the wavelet decompositions
+	TileSize      int              // Tile width and height in pixels, 0 for a single tile
+	CodeblockSize int              // Codeblock width and height, a power of two from 4 to 64
+	Precincts     []int            // Precinct sizes from the highest resolution, powers of two; the last one is halved for lower resolutions. Empty for one precinct per resolution
+	Progression   ProgressionOrder // Packet order
+}
+
+// DefaultEncodeOptions returns lossless encoding in a single layer with 6 resolution
+// levels, one tile, 64x64 codeblocks and LRCP progression
+func DefaultEncodeOptions() EncodeOptions {
+	return EncodeOptions{
+		Lossless:      true,
+		Resolutions:   6,
+		CodeblockSize: 64,
+		Progression:   LRCP,
+	}
+}
+
+// NumLayers returns the number of quality layers of the options
+func (o EncodeOptions) NumLayers() int {
+	if o.Layers > 0 {
+		return o.Layers
+	}
+	switch {
+	case len(o.PSNR) > 0:
+		return len(o.PSNR)
+	case o.Lossless:
+		return len(o.Rates) + 1
+	case len(o.Rates) > 0:
+		return len(o.Rates)
+	}
+	return 1
+}
+
+// Validate checks that the options describe a valid JPEG2000 codestream
+func (o EncodeOptions) Validate() error {
+	if len(o.Rates) > 0 && len(o.PSNR) > 0 {
+		return fmt.Errorf("compression ratios and PSNR targets are exclusive")
+	}
+	if o.Lossless && len(o.PSNR) > 0 {
+		return fmt.Errorf("PSNR targets need lossy encoding")
+	}
+	for i, rate := range o.Rates {
+		if rate < 1 || (i > 0 && rate >= o.Rates[i-1]) {
+			return fmt.Errorf("compression ratios must be at least 1 and decreasing, got %v", o.Rates)
+		}
+	}
+	for i, psnr := range o.PSNR {
+		if psnr <= 0 || (i > 0 && psnr <= o.PSNR[i-1]) {
+			return fmt.Errorf("PSNR targets must be positive and increasing, got %v", o.PSNR)
+		}
+	}
+
+	layers := o.NumLayers()
+	targets := len(o.Rates) + len(o.PSNR)
+	if o.Lossless {
+		targets++
+	}
+	if layers > 100 {
+		return fmt.Errorf("at most 100 quality layers are supported, got %d", layers)
+	}
+	if (layers > 1 || targets > 1) && layers != targets {
+		return fmt.Errorf("%d quality layers need a compression ratio or PSNR for each lossy layer", layers)
+	}
+
+	if o.Resolutions < 1 || o.Resolutions > 33 {
+		return fmt.Errorf("resolution levels must be between 1 and 33, got %d", o.Resolutions)
+	}
+	if o.TileSize < 0 {
+		return fmt.Errorf("invalid tile size %d", o.TileSize)
+	}
+	if o.CodeblockSize < 4 || o.CodeblockSize > 64 || o.CodeblockSize&(o.CodeblockSize-1) != 0 {
+		return fmt.Errorf("codeblock size must be a power of two from 4 to 64, got %d", o.CodeblockSize)
+	}
+	for _, size := range o.Precincts {
+		if size < 2 || size > 1<<15 || size&(size-1) != 0 {
+			return fmt.Errorf("precinct sizes must be powers of two from 2 to 32768, got %v", o.Precincts)
+		}
+	}
+	if o.Progression < LRCP || o.Progression > CPRL {
+		return fmt.Errorf("invalid progression order %d", int(o.Progression))
+	}
+	return nil
+}
+
+// PrecinctSizes returns the precinct size of every resolution level from the highest,
+// halving the last given size for the remaining levels as OpenJPEG does, or nil for
+// one precinct per resolution
+func (o EncodeOptions) PrecinctSizes() []int {
+	if len(o.Precincts) == 0 {
+		return nil
+	}
+	sizes := make([]int, o.Resolutions)
+	last := len(o.Precincts) - 1
+	for i := range sizes {
+		if i <= last {
+			sizes[i] = o.Precincts[i]
+		} else {
+			sizes[i] = max(o.Precincts[last]>>(i-last), 1)
+		}
+	}
+	return sizes
+}
+
+// Reversible returns the options with lossless encoding, dropping PSNR targets, for
+// samples such as palette indices and data products that must be preserved exactly
+func (o EncodeOptions) Reversible() EncodeOptions {
+	if o.Lossless {
+		return o
+	}
+	o.Lossless = true
+	o.PSNR = nil
+	o.Rates = nil
+	o.Layers = 1
+	return o
+}
+
+// CompressionRatio returns the size of the uncompressed samples over the bytes written,
+// or 0 when nothing was written
+func CompressionRatio(rawBytes, written int64) float64 {
+	if written <= 0 {
+		return 0
+	}
+	return float64(rawBytes) / float64(written)
 }
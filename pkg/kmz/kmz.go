@@ -0,0 +1,212 @@
+package kmz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+	"github.com/luismi/jp2_processing/pkg/ndvi"
+	"github.com/luismi/jp2_processing/pkg/output"
+	"github.com/luismi/jp2_processing/pkg/tiles"
+)
+
+// Paths of the files inside the KMZ archive
+const (
+	overlayFile = "files/overlay.png"
+	legendFile  = "files/legend.png"
+)
+
+// Options configures a KMZ ground overlay
+type Options struct {
+	Name    string
+	MaxSize int         // Largest dimension of the overlay image in pixels
+	Legend  image.Image // Legend shown as a screen overlay in the lower left corner, nil for none
+	Stats   *Stats      // NDVI statistics shown as the overlay description, nil for none
+}
+
+// DefaultOptions returns an overlay named NDVI of at most 4096 pixels
+func DefaultOptions() Options {
+	return Options{Name: "NDVI", MaxSize: 4096}
+}
+
+// Stats are NDVI statistics of the map shown in the overlay description
+type Stats struct {
+	Min, Max, Mean float64
+	TotalPixels    int
+	NoDataPixels   int
+}
+
+// entry is a file of the KMZ archive
+type entry struct {
+	name  string
+	data  []byte
+	store bool // PNG files are already compressed
+}
+
+// Write reprojects a georeferenced map to WGS84 longitude and latitude and writes
+// it to path as a KMZ with a PNG ground overlay placed by its LatLonBox
+// Pixels whose valid flag is false are transparent; a nil valid slice keeps every pixel
+func Write(img *image.RGBA, valid []bool, georef *geo.Georeference, opts Options, path string, numThreads int) (*metrics.KMZMetrics, error) {
+	if opts.MaxSize <= 0 {
+		return nil, fmt.Errorf("invalid KMZ overlay size %d", opts.MaxSize)
+	}
+	proj, err := geo.ProjectionFor(georef.EPSG)
+	if err != nil {
+		return nil, err
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	box := tiles.LonLatBounds(proj, georef, width, height)
+
+	// Overlay grid with the resolution of the map at its center, capped at MaxSize
+	resLon, resLat, err := centerResolution(georef, width, height)
+	if err != nil {
+		return nil, err
+	}
+	outWidth := max(1, int(math.Ceil((box.East-box.West)/resLon)))
+	outHeight := max(1, int(math.Ceil((box.North-box.South)/resLat)))
+	reduction := math.Max(1, float64(max(outWidth, outHeight))/float64(opts.MaxSize))
+	outWidth = max(1, int(math.Round(float64(outWidth)/reduction)))
+	outHeight = max(1, int(math.Round(float64(outHeight)/reduction)))
+	overlayGeoref := geo.NewGeoreference(geo.EPSGWGS84, box.West, box.North,
+		(box.East-box.West)/float64(outWidth), -(box.North-box.South)/float64(outHeight))
+
+	// Average the map down to the overlay resolution before sampling it
+	src, srcGeoref := ndvi.MaskInvalid(img, valid, numThreads), georef
+	if reduction > 1 {
+		src = ndvi.Quicklook(src, int(math.Round(float64(max(width, height))/reduction)), numThreads)
+		srcGeoref = georef.Resized(width, height, src.Bounds().Dx(), src.Bounds().Dy())
+	}
+	overlay := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	if err := geo.WarpParallel(overlay, overlayGeoref, src, srcGeoref, numThreads); err != nil {
+		return nil, err
+	}
+
+	// KML document and images
+	files := []entry{{name: "doc.kml", data: []byte(document(opts, box, georef))}}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, overlay); err != nil {
+		return nil, fmt.Errorf("error encoding overlay: %v", err)
+	}
+	files = append(files, entry{overlayFile, buf.Bytes(), true})
+	if opts.Legend != nil {
+		var legendBuf bytes.Buffer
+		if err := png.Encode(&legendBuf, opts.Legend); err != nil {
+			return nil, fmt.Errorf("error encoding legend: %v", err)
+		}
+		files = append(files, entry{legendFile, legendBuf.Bytes(), true})
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, f := range files {
+		header := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		if f.store {
+			header.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("error creating KMZ entry %s: %v", f.name, err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, fmt.Errorf("error writing KMZ entry %s: %v", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing KMZ: %v", err)
+	}
+
+	if err := output.WriteFile(path, archive.Bytes()); err != nil {
+		return nil, fmt.Errorf("error writing KMZ: %v", err)
+	}
+
+	return &metrics.KMZMetrics{
+		Width:  outWidth,
+		Height: outHeight,
+		Size:   int64(archive.Len()),
+	}, nil
+}
+
+// centerResolution returns the longitude and latitude steps in degrees of one pixel
+// at the center of a georeferenced grid
+func centerResolution(georef *geo.Georeference, width, height int) (float64, float64, error) {
+	proj, err := geo.ProjectionFor(georef.EPSG)
+	if err != nil {
+		return 0, 0, err
+	}
+	cx, cy := float64(width)/2, float64(height)/2
+	lon0, lat0 := proj.Inverse(georef.PixelToWorld(cx, cy))
+	lonX, latX := proj.Inverse(georef.PixelToWorld(cx+1, cy))
+	lonY, latY := proj.Inverse(georef.PixelToWorld(cx, cy+1))
+	resLon := math.Hypot(lonX-lon0, lonY-lon0)
+	resLat := math.Hypot(latX-lat0, latY-lat0)
+	if resLon == 0 || resLat == 0 {
+		return 0, 0, fmt.Errorf("degenerate georeference %s", georef)
+	}
+	return resLon, resLat, nil
+}
+
+// document returns the KML document of the ground overlay and its legend
+func document(opts Options, box tiles.Bounds, georef *geo.Georeference) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2">` + "\n")
+	b.WriteString("<Document>\n")
+	fmt.Fprintf(&b, "  <name>%s</name>\n", escape(opts.Name))
+	b.WriteString("  <GroundOverlay>\n")
+	fmt.Fprintf(&b, "    <name>%s</name>\n", escape(opts.Name))
+	fmt.Fprintf(&b, "    <description><![CDATA[%s]]></description>\n", description(opts.Stats, georef))
+	fmt.Fprintf(&b, "    <Icon><href>%s</href></Icon>\n", overlayFile)
+	b.WriteString("    <LatLonBox>\n")
+	fmt.Fprintf(&b, "      <north>%.8f</north>\n", box.North)
+	fmt.Fprintf(&b, "      <south>%.8f</south>\n", box.South)
+	fmt.Fprintf(&b, "      <east>%.8f</east>\n", box.East)
+	fmt.Fprintf(&b, "      <west>%.8f</west>\n", box.West)
+	b.WriteString("    </LatLonBox>\n")
+	b.WriteString("  </GroundOverlay>\n")
+	if opts.Legend != nil {
+		b.WriteString("  <ScreenOverlay>\n")
+		b.WriteString("    <name>Legend</name>\n")
+		fmt.Fprintf(&b, "    <Icon><href>%s</href></Icon>\n", legendFile)
+		b.WriteString(`    <overlayXY x="0" y="0" xunits="fraction" yunits="fraction"/>` + "\n")
+		b.WriteString(`    <screenXY x="10" y="30" xunits="pixels" yunits="pixels"/>` + "\n")
+		b.WriteString(`    <size x="0" y="0" xunits="pixels" yunits="pixels"/>` + "\n")
+		b.WriteString("  </ScreenOverlay>\n")
+	}
+	b.WriteString("</Document>\n")
+	b.WriteString("</kml>\n")
+	return b.String()
+}
+
+// description returns an HTML table of the NDVI statistics and source grid
+func description(stats *Stats, georef *geo.Georeference) string {
+	var b strings.Builder
+	b.WriteString("<table>")
+	if stats != nil {
+		validPixels := stats.TotalPixels - stats.NoDataPixels
+		fmt.Fprintf(&b, "<tr><td>Mean NDVI</td><td>%.4f</td></tr>", stats.Mean)
+		fmt.Fprintf(&b, "<tr><td>Min NDVI</td><td>%.4f</td></tr>", stats.Min)
+		fmt.Fprintf(&b, "<tr><td>Max NDVI</td><td>%.4f</td></tr>", stats.Max)
+		if stats.TotalPixels > 0 {
+			fmt.Fprintf(&b, "<tr><td>Valid pixels</td><td>%d (%.1f%%)</td></tr>",
+				validPixels, 100*float64(validPixels)/float64(stats.TotalPixels))
+		}
+		fmt.Fprintf(&b, "<tr><td>No data pixels</td><td>%d</td></tr>", stats.NoDataPixels)
+	}
+	fmt.Fprintf(&b, "<tr><td>Source grid</td><td>%s</td></tr>", escape(georef.String()))
+	b.WriteString("</table>")
+	return b.String()
+}
+
+// escape escapes text for XML content
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
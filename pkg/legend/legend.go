@@ -0,0 +1,268 @@
+package legend
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/luismi/jp2_processing/config"
+)
+
+// Options configures the rendering of legends and colorbars
+type Options struct {
+	Title      string
+	Units      string // Appended to the title in parentheses when not empty
+	Ticks      int    // Number of labeled ticks of a colorbar, at least 2
+	Scale      int    // Integer magnification of the whole legend, at least 1
+	Foreground color.Color
+	Background color.Color
+	TickValue  func(float64) float64 // Maps a colormap value to the value printed at its tick, e.g. to undo a stretch
+}
+
+// DefaultOptions returns an NDVI colorbar with 5 ticks, black text on translucent white
+func DefaultOptions() Options {
+	return Options{
+		Title:      "NDVI",
+		Ticks:      5,
+		Scale:      1,
+		Foreground: color.Black,
+		Background: color.NRGBA{255, 255, 255, 220},
+	}
+}
+
+// Corner selects where a legend is placed on an image
+type Corner int
+
+const (
+	BottomRight Corner = iota
+	BottomLeft
+	TopRight
+	TopLeft
+)
+
+// ParseCorner converts a flag value (br, bl, tr or tl) into a Corner
+func ParseCorner(name string) (Corner, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "br":
+		return BottomRight, nil
+	case "bl":
+		return BottomLeft, nil
+	case "tr":
+		return TopRight, nil
+	case "tl":
+		return TopLeft, nil
+	default:
+		return 0, fmt.Errorf("unknown legend corner: %s (use br, bl, tr or tl)", name)
+	}
+}
+
+// Layout sizes in unscaled pixels
+const (
+	padding     = 6
+	lineHeight  = glyphHeight + 3
+	barWidth    = 16
+	barHeight   = 160
+	tickLength  = 4
+	swatchWidth = 14
+)
+
+// Colorbar renders a vertical colorbar of a continuous colormap over its domain,
+// with the highest value at the top
+func Colorbar(cmap *config.Colormap, opts Options) *image.RGBA {
+	opts = normalize(opts)
+	s := opts.Scale
+	lo, hi := cmap.Domain()
+
+	// Tick values and labels, top to bottom
+	ticks := make([]float64, opts.Ticks)
+	labels := make([]string, opts.Ticks)
+	labelWidth := 0
+	for i := range ticks {
+		ticks[i] = hi - (hi-lo)*float64(i)/float64(opts.Ticks-1)
+		if opts.TickValue != nil {
+			labels[i] = formatValue(opts.TickValue(ticks[i]))
+		} else {
+			labels[i] = formatValue(ticks[i])
+		}
+		labelWidth = max(labelWidth, TextWidth(labels[i], 1))
+	}
+
+	title := titleText(opts)
+	barTop := padding + lineHeight + padding/2
+	width := max(padding+barWidth+tickLength+2+labelWidth+padding, padding+TextWidth(title, 1)+padding)
+	height := barTop + barHeight + glyphHeight/2 + padding
+
+	img := image.NewRGBA(image.Rect(0, 0, width*s, height*s))
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	DrawText(img, padding*s, padding*s, title, opts.Foreground, s)
+
+	// Color ramp, one row per scaled pixel
+	for y := 0; y < barHeight*s; y++ {
+		value := hi - (hi-lo)*float64(y)/float64(barHeight*s-1)
+		c := cmap.Color(value)
+		row := image.Rect(padding*s, (barTop)*s+y, (padding+barWidth)*s, (barTop)*s+y+1)
+		draw.Draw(img, row, image.NewUniform(c), image.Point{}, draw.Over)
+	}
+	drawFrame(img, image.Rect(padding*s, barTop*s, (padding+barWidth)*s, (barTop+barHeight)*s), opts.Foreground, s)
+
+	// Tick marks and labels
+	for i, label := range labels {
+		y := barTop*s + int(math.Round(float64(barHeight*s-1)*float64(i)/float64(opts.Ticks-1)))
+		tick := image.Rect((padding+barWidth)*s, y, (padding+barWidth+tickLength)*s, y+s)
+		draw.Draw(img, tick, image.NewUniform(opts.Foreground), image.Point{}, draw.Src)
+		DrawText(img, (padding+barWidth+tickLength+2)*s, y-glyphHeight*s/2, label, opts.Foreground, s)
+	}
+
+	return img
+}
+
+// ClassLegend renders a legend with a color swatch, label and value range per class,
+// followed by a no-data entry when the scheme's no-data color is not transparent
+func ClassLegend(scheme *config.ClassScheme, opts Options) *image.RGBA {
+	opts = normalize(opts)
+	s := opts.Scale
+
+	type entry struct {
+		text  string
+		color color.Color
+	}
+	var entries []entry
+	for _, class := range scheme.Legend() {
+		entries = append(entries, entry{fmt.Sprintf("%s (%s)", class.Label, formatRange(class.Min, class.Max)), class.Color})
+	}
+	if scheme.NoData.A > 0 {
+		entries = append(entries, entry{"No data", scheme.NoData})
+	}
+
+	title := titleText(opts)
+	textWidth := TextWidth(title, 1)
+	for _, e := range entries {
+		textWidth = max(textWidth, swatchWidth+4+TextWidth(e.text, 1))
+	}
+	width := padding + textWidth + padding
+	height := padding + lineHeight + padding/2 + len(entries)*(lineHeight+2) + padding
+
+	img := image.NewRGBA(image.Rect(0, 0, width*s, height*s))
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	DrawText(img, padding*s, padding*s, title, opts.Foreground, s)
+
+	y := padding + lineHeight + padding/2
+	for _, e := range entries {
+		swatch := image.Rect(padding*s, y*s, (padding+swatchWidth)*s, (y+lineHeight-1)*s)
+		draw.Draw(img, swatch, image.NewUniform(e.color), image.Point{}, draw.Over)
+		drawFrame(img, swatch, opts.Foreground, s)
+		DrawText(img, (padding+swatchWidth+4)*s, (y+1)*s, e.text, opts.Foreground, s)
+		y += lineHeight + 2
+	}
+
+	return img
+}
+
+// Overlay composites a legend onto an image at the given corner, margin pixels from its edges
+// On paletted images the legend colors are replaced by the nearest palette colors
+func Overlay(dst draw.Image, legend image.Image, corner Corner, margin int) {
+	bounds := dst.Bounds()
+	size := legend.Bounds().Size()
+
+	x := bounds.Max.X - margin - size.X
+	y := bounds.Max.Y - margin - size.Y
+	if corner == BottomLeft || corner == TopLeft {
+		x = bounds.Min.X + margin
+	}
+	if corner == TopRight || corner == TopLeft {
+		y = bounds.Min.Y + margin
+	}
+
+	target := image.Rect(x, y, x+size.X, y+size.Y)
+	draw.Draw(dst, target, legend, legend.Bounds().Min, draw.Over)
+}
+
+// DrawText draws text with its top-left corner at (x, y), magnified by scale
+func DrawText(dst *image.RGBA, x, y int, text string, c color.Color, scale int) {
+	for _, r := range text {
+		columns := glyph(r)
+		for col, bits := range columns {
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(dst, px, image.NewUniform(c), image.Point{}, draw.Over)
+			}
+		}
+		x += (glyphWidth + 1) * scale
+	}
+}
+
+// TextWidth returns the width in pixels of text drawn with DrawText
+func TextWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*(glyphWidth+1) - 1) * scale
+}
+
+// normalize fills in unset options
+func normalize(opts Options) Options {
+	if opts.Ticks < 2 {
+		opts.Ticks = 2
+	}
+	if opts.Scale < 1 {
+		opts.Scale = 1
+	}
+	if opts.Foreground == nil {
+		opts.Foreground = color.Black
+	}
+	if opts.Background == nil {
+		opts.Background = color.Transparent
+	}
+	return opts
+}
+
+// titleText returns the title followed by the units in parentheses
+func titleText(opts Options) string {
+	if opts.Units == "" {
+		return opts.Title
+	}
+	if opts.Title == "" {
+		return "(" + opts.Units + ")"
+	}
+	return opts.Title + " (" + opts.Units + ")"
+}
+
+// drawFrame draws a one pixel (scaled) border inside rect
+func drawFrame(dst *image.RGBA, rect image.Rectangle, c color.Color, scale int) {
+	fg := image.NewUniform(c)
+	draw.Draw(dst, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+scale), fg, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(rect.Min.X, rect.Max.Y-scale, rect.Max.X, rect.Max.Y), fg, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+scale, rect.Max.Y), fg, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(rect.Max.X-scale, rect.Min.Y, rect.Max.X, rect.Max.Y), fg, image.Point{}, draw.Src)
+}
+
+// formatValue formats a tick value with at most 3 significant decimals
+func formatValue(v float64) string {
+	v = math.Round(v*1000) / 1000
+	if v == 0 {
+		v = 0 // Avoid "-0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatRange formats the value range of a class
+func formatRange(lo, hi float64) string {
+	switch {
+	case math.IsInf(lo, -1) && math.IsInf(hi, 1):
+		return "all"
+	case math.IsInf(lo, -1):
+		return "< " + formatValue(hi)
+	case math.IsInf(hi, 1):
+		return ">= " + formatValue(lo)
+	default:
+		return formatValue(lo) + " - " + formatValue(hi)
+	}
+}
@@ -64,11 +64,110 @@ func (c *Collector) SetNDVIMetrics(ndviMetrics *NDVIMetrics, time time.Duration)
 func (c *Collector) SetColorMetrics(colorMetrics *ColorMetrics, time time.Duration) {
 	c.metrics.ColorTime = time
 	c.metrics.ImageSize = colorMetrics.ImageSize
+	c.metrics.LUTSize = colorMetrics.LUTSize
+	c.metrics.ColorMode = "exact"
+	if colorMetrics.LUTSize > 0 {
+		c.metrics.ColorMode = "lut"
+	}
+	if colorMetrics.Classes != nil {
+		c.metrics.ColorMode = "classes"
+		c.metrics.Classes = *colorMetrics.Classes
+	}
+}
+
+// SetProductMetrics sets metrics related to the quantized NDVI data product
+func (c *Collector) SetProductMetrics(productMetrics *ProductMetrics) {
+	c.metrics.Product = *productMetrics
+}
+
+// SetStretchMetrics sets the parameters of the stretch applied before colorization
+func (c *Collector) SetStretchMetrics(stretchMetrics *StretchMetrics, time time.Duration) {
+	c.metrics.Stretch = *stretchMetrics
+	c.metrics.Stretch.Time = time
+}
+
+// SetOutput sets the save mode, the output format and the size in bytes of the saved file
+func (c *Collector) SetOutput(saveMode, format string, size int64) {
+	c.metrics.SaveMode = saveMode
+	c.metrics.OutputFormat = format
+	c.metrics.OutputSize = size
+}
+
+// SetColorError sets the largest channel difference of the LUT image against exact interpolation
+func (c *Collector) SetColorError(maxError int) {
+	c.metrics.ColorMaxError = maxError
+}
+
+// SetWriteMetrics sets metrics related to saving the image
+func (c *Collector) SetWriteMetrics(writeMetrics *WriteMetrics) {
+	c.metrics.SaveTime = writeMetrics.TotalTime
+	c.metrics.Write = *writeMetrics
+}
+
+// SetTileColorTime records the time spent colorizing tiles on demand while saving,
+// moving it from the save time to the colorization time
+func (c *Collector) SetTileColorTime(time time.Duration) {
+	c.metrics.ColorTime += time
+	c.metrics.SaveTime -= time
+}
+
+// SetQuickLookMetrics sets metrics related to the PNG quicklook
+func (c *Collector) SetQuickLookMetrics(quickLookMetrics *QuickLookMetrics, time time.Duration) {
+	c.metrics.QuickLookTime = time
+	c.metrics.QuickLook = *quickLookMetrics
+}
+
+// SetTileMetrics sets metrics related to the XYZ tile pyramid
+func (c *Collector) SetTileMetrics(tileMetrics *TileMetrics, time time.Duration) {
+	c.metrics.Tiles = *tileMetrics
+	c.metrics.Tiles.Time = time
+}
+
+// SetKMZMetrics sets metrics related to the KMZ ground overlay
+func (c *Collector) SetKMZMetrics(kmzMetrics *KMZMetrics, time time.Duration) {
+	c.metrics.KMZ = *kmzMetrics
+	c.metrics.KMZ.Time = time
+}
+
+// SetVectorMetrics sets metrics related to the polygonisation of the class raster
+func (c *Collector) SetVectorMetrics(vectorMetrics *VectorMetrics, time time.Duration) {
+	c.metrics.Vector = *vectorMetrics
+	c.metrics.Vector.Time = time
+}
+
+// SetRawMetrics sets metrics related to the raw array export
+func (c *Collector) SetRawMetrics(rawMetrics *RawMetrics, time time.Duration) {
+	c.metrics.Raw = *rawMetrics
+	c.metrics.Raw.Time = time
+}
+
+// SetChangeMetrics sets metrics related to NDVI change detection
+func (c *Collector) SetChangeMetrics(changeMetrics *ChangeMetrics, time time.Duration) {
+	c.metrics.ChangeTime = time
+	c.metrics.Change = *changeMetrics
+}
+
+// SetCompositeMetrics sets metrics related to multi-date compositing
+func (c *Collector) SetCompositeMetrics(compositeMetrics *CompositeMetrics, time time.Duration) {
+	c.metrics.CompositeTime = time
+	c.metrics.Composite = *compositeMetrics
+}
+
+// SetCubeMetrics sets metrics related to the temporal data cube
+func (c *Collector) SetCubeMetrics(cubeMetrics *CubeMetrics) {
+	c.metrics.Cube = *cubeMetrics
+}
+
+// SetPhenologyMetrics sets metrics related to phenology extraction
+func (c *Collector) SetPhenologyMetrics(phenoMetrics *PhenologyMetrics, time time.Duration) {
+	c.metrics.Phenology = *phenoMetrics
+	c.metrics.Phenology.Time = time
 }
 
-// SetSaveTime sets the time spent saving the image
-func (c *Collector) SetSaveTime(time time.Duration) {
-	c.metrics.SaveTime = time
+// SetAnomalyMetrics sets metrics related to NDVI anomaly detection
+func (c *Collector) SetAnomalyMetrics(anomalyMetrics *AnomalyMetrics, time time.Duration) {
+	c.metrics.Anomaly = *anomalyMetrics
+	c.metrics.Anomaly.Time = time
 }
 
 // SetCPUMetrics sets CPU-specific time metrics
@@ -10,16 +10,17 @@ import (
 // PrintMetricsTable prints a table with performance metrics
 func PrintMetricsTable(metricas []*Metrics) {
 	// Bottleneck Analysis
-	fmt.Println("┌ Bottleneck Analysis ────────────┬──────────────────┬──────────────────┬──────────────────┬──────────────────┬──────────────────┐")
-	fmt.Printf("│ %-12s │ %-16s │ %-16s │ %-16s │ %-16s │ %-16s │ %-16s │\n",
-		"Res",
+	fmt.Println("┌ Bottleneck Analysis ─────────────────────────────────────┬──────────────────┬──────────────────┬──────────────────┬──────────────────┬──────────────────┐")
+	fmt.Printf("│ %-10s │ %-24s │ %-16s │ %-16s │ %-16s │ %-16s │ %-16s │ %-16s │\n",
+		"Processor",
+		"Config",
 		"TTR NIR",
 		"TTR RED",
 		"NDVI",
 		"Color Proc.",
 		"Saving",
 		"Total")
-	fmt.Println("├──────────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┤")
+	fmt.Println("├────────────┼──────────────────────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┼─────────┬────────┤")
 
 	for _, m := range metricas {
 		nirMag, nirUnit := getMagnitudeAndUnit(m.FileTimeNIR + m.DecodeTimeNIR)
@@ -35,8 +36,9 @@ func PrintMetricsTable(metricas []*Metrics) {
 		porcColor := float64(m.ColorTime) / float64(m.TotalTime) * 100
 		porcSave := float64(m.SaveTime) / float64(m.TotalTime) * 100
 
-		fmt.Printf("│ %-12s │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │\n",
-			"",
+		fmt.Printf("│ %-10s │ %-24s │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │ %s%-2s │ %s%% │\n",
+			processorLabel(m),
+			configLabel(m),
 			formatNumber(nirMag, 5), nirUnit, formatNumber(porcNIR, 5),
 			formatNumber(redMag, 5), redUnit, formatNumber(porcRED, 5),
 			formatNumber(ndviMag, 5), ndviUnit, formatNumber(porcNDVI, 5),
@@ -44,19 +46,20 @@ func PrintMetricsTable(metricas []*Metrics) {
 			formatNumber(saveMag, 5), saveUnit, formatNumber(porcSave, 5),
 			formatNumber(totalMag, 5), totalUnit, "100.0") // Total is always 100%
 	}
-	fmt.Println("└──────────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┘")
+	fmt.Println("└────────────┴──────────────────────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┴─────────┴────────┘")
 	fmt.Println()
 
 	// Image Reading Breakdown table
-	fmt.Println("┌ Image Reading Breakdown ────────┬───────────┬───────────┬──────────┬──────────┐")
-	fmt.Printf("│ %-12s │ %-16s │ %-9s │ %-9s │ %-8s │ %-8s │\n",
-		"Res",
+	fmt.Println("┌ Image Reading Breakdown ─────────────────────────────────┬───────────┬───────────┬──────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-24s │ %-16s │ %-9s │ %-9s │ %-8s │ %-8s │\n",
+		"Processor",
+		"Config",
 		"Uncovered Region",
 		"NIR Tiles",
 		"RED Tiles",
 		"Total MP",
 		"Img Size")
-	fmt.Println("├──────────────┼─────────┬────────┼───────────┼───────────┼──────────┼──────────┤")
+	fmt.Println("├────────────┼──────────────────────────┼─────────┬────────┼───────────┼───────────┼──────────┼──────────┤")
 
 	for _, m := range metricas {
 		porcNoData := float64(m.NoDataPixels) / float64(m.Pixels) * 100
@@ -78,8 +81,9 @@ func PrintMetricsTable(metricas []*Metrics) {
 
 		sizeMB := float64(m.ImageSize) / (1024 * 1024)
 
-		fmt.Printf("│ %-12s │ %s%-2s │ %s%% │ %-3d tiles │ %-3d tiles │ %s %-2s │ %s MB │\n",
-			"",
+		fmt.Printf("│ %-10s │ %-24s │ %s%-2s │ %s%% │ %-3d tiles │ %-3d tiles │ %s %-2s │ %s MB │\n",
+			processorLabel(m),
+			configLabel(m),
 			formatNumber(pixelesSinDatosMP, 5), "MP", formatNumber(porcNoData, 5),
 			m.NumTilesNIR,
 			m.NumTilesRED,
@@ -87,10 +91,12 @@ func PrintMetricsTable(metricas []*Metrics) {
 			formatNumber(sizeMB, 5),
 		)
 	}
-	fmt.Println("└──────────────┴─────────┴────────┴───────────┴───────────┴──────────┴──────────┘")
+	fmt.Println("└────────────┴──────────────────────────┴─────────┴────────┴───────────┴───────────┴──────────┴──────────┘")
 }
 
 // PrintScalabilityAnalysis prints a table with scalability information
+// Runs are grouped by color mode, save mode and output format, and each group is
+// compared with its own single-threaded CPU run
 func PrintScalabilityAnalysis(metricas []*Metrics, groupByResolution bool) {
 	fmt.Println("\n--- SCALABILITY ANALYSIS ---")
 
@@ -107,46 +113,56 @@ func PrintScalabilityAnalysis(metricas []*Metrics, groupByResolution bool) {
 	for res, ms := range metricsByResolution {
 		if len(ms) > 0 {
 			fmt.Printf("\nResolution: %s\n", res)
-			fmt.Println("┌────────────┬────────────┬─────────┬────────────┐")
-			fmt.Printf("│ %-10s │ %-10s │ %-7s │ %-10s │\n",
-				"Processor", "Time (s)", "Speedup", "Efficiency")
-			fmt.Println("├────────────┼────────────┼─────────┼────────────┤")
-
-			// Find reference metric (1 core)
-			var baseTime float64
+			fmt.Println("┌────────────┬──────────────────────────┬────────────┬─────────┬────────────┐")
+			fmt.Printf("│ %-10s │ %-24s │ %-10s │ %-7s │ %-10s │\n",
+				"Processor", "Config", "Time (s)", "Speedup", "Efficiency")
+			fmt.Println("├────────────┼──────────────────────────┼────────────┼─────────┼────────────┤")
+
+			// Group runs by configuration, in order of appearance
+			var configs []string
+			runsByConfig := make(map[string][]*Metrics)
 			for _, m := range ms {
-				if m.ProcessorType == "CPU" && m.NumThreads == 1 {
-					baseTime = m.TotalTime.Seconds()
-					break
+				config := configLabel(m)
+				if _, ok := runsByConfig[config]; !ok {
+					configs = append(configs, config)
 				}
+				runsByConfig[config] = append(runsByConfig[config], m)
 			}
 
-			// If no single-core metric found, use the first one
-			if baseTime == 0 && len(ms) > 0 {
-				baseTime = ms[0].TotalTime.Seconds()
-			}
+			for _, config := range configs {
+				runs := runsByConfig[config]
 
-			// Show metrics ordered by processor
-			for _, m := range ms {
-				time := m.TotalTime.Seconds()
-				speedup := baseTime / time
-				efficiency := 0.0
-
-				if m.ProcessorType == "CPU" {
-					efficiency = speedup / float64(m.NumThreads)
-				} else {
-					efficiency = speedup // For GPU we don't calculate efficiency
+				// Find reference metric (1 core) of the configuration
+				var baseTime float64
+				for _, m := range runs {
+					if m.ProcessorType == "CPU" && m.NumThreads == 1 {
+						baseTime = m.TotalTime.Seconds()
+						break
+					}
 				}
 
-				procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
-				if m.ProcessorType == "GPU" {
-					procLabel = "GPU"
+				// If no single-core metric found, use the first one
+				if baseTime == 0 {
+					baseTime = runs[0].TotalTime.Seconds()
 				}
 
-				fmt.Printf("│ %-10s │ %10.3f │ %7.2f │ %10.2f │\n",
-					procLabel, time, speedup, efficiency)
+				// Show metrics ordered by processor
+				for _, m := range runs {
+					time := m.TotalTime.Seconds()
+					speedup := baseTime / time
+					efficiency := 0.0
+
+					if m.ProcessorType == "CPU" {
+						efficiency = speedup / float64(m.NumThreads)
+					} else {
+						efficiency = speedup // For GPU we don't calculate efficiency
+					}
+
+					fmt.Printf("│ %-10s │ %-24s │ %10.3f │ %7.2f │ %10.2f │\n",
+						processorLabel(m), config, time, speedup, efficiency)
+				}
 			}
-			fmt.Println("└────────────┴────────────┴─────────┴────────────┘")
+			fmt.Println("└────────────┴──────────────────────────┴────────────┴─────────┴────────────┘")
 		}
 	}
 }
@@ -171,6 +187,635 @@ func PrintCPUMetricsTable(metrics []*Metrics) {
 	fmt.Println("└──────────────┴───────────────┴───────────────┴───────────────┘")
 }
 
+// PrintColorModeTable prints a table comparing exact interpolation and LUT colorization
+func PrintColorModeTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Colorization Modes ───────┬──────────────────┬──────────┬──────────┬──────────────┬───────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-16s │ %-8s │ %-8s │ %-12s │ %-9s │\n",
+		"Processor", "Mode", "Output", "Entries", "Speedup", "Color Proc.", "Max Error")
+	fmt.Println("├────────────┼──────────────┼──────────────────┼──────────┼──────────┼──────────────┼───────────┤")
+
+	// Exact colorization time per processor configuration, save mode and format is the reference
+	exactTimes := make(map[string]time.Duration)
+	for _, m := range metricas {
+		if m.ColorMode != "lut" {
+			exactTimes[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.SaveMode, m.OutputFormat)] = m.ColorTime
+		}
+	}
+
+	for _, m := range metricas {
+		speedup := "-"
+		if exact, ok := exactTimes[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.SaveMode, m.OutputFormat)]; ok && m.ColorTime > 0 {
+			speedup = fmt.Sprintf("%.2f", float64(exact)/float64(m.ColorTime))
+		}
+
+		entries := "-"
+		maxError := "-"
+		if m.ColorMode == "lut" {
+			entries = strconv.Itoa(m.LUTSize)
+			maxError = strconv.Itoa(m.ColorMaxError)
+		}
+
+		colorMag, colorUnit := getMagnitudeAndUnit(m.ColorTime)
+		fmt.Printf("│ %-10s │ %-12s │ %-16s │ %8s │ %8s │ %-12s │ %9s │\n",
+			processorLabel(m),
+			m.ColorMode,
+			m.SaveMode+" "+m.OutputFormat,
+			entries,
+			speedup,
+			formatNumber(colorMag, 5)+colorUnit,
+			maxError)
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────────┴──────────┴──────────┴──────────────┴───────────┘")
+}
+
+// PrintWriteTable prints a table with the stages of saving the map and its compression
+func PrintWriteTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Write Stages ─────────────────────────┬──────────────┬──────────────┬──────────────┬──────────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-24s │ %-12s │ %-12s │ %-12s │ %-12s │ %-8s │\n",
+		"Processor", "Config", "Copy", "Encode", "File", "Size", "Ratio")
+	fmt.Println("├────────────┼──────────────────────────┼──────────────┼──────────────┼──────────────┼──────────────┼──────────┤")
+
+	for _, m := range metricas {
+		wm := m.Write
+		if wm.TotalTime == 0 {
+			continue
+		}
+
+		copyMag, copyUnit := getMagnitudeAndUnit(wm.CopyTime)
+		encodeMag, encodeUnit := getMagnitudeAndUnit(wm.EncodeTime)
+		fileMag, fileUnit := getMagnitudeAndUnit(wm.FileTime)
+		fmt.Printf("│ %-10s │ %-24s │ %-12s │ %-12s │ %-12s │ %-12s │ %8s │\n",
+			processorLabel(m),
+			configLabel(m),
+			formatNumber(copyMag, 5)+copyUnit,
+			formatNumber(encodeMag, 5)+encodeUnit,
+			formatNumber(fileMag, 5)+fileUnit,
+			formatNumber(float64(wm.Bytes)/(1024*1024), 5)+" MB",
+			fmt.Sprintf("%.2f", wm.CompressionRatio))
+	}
+	fmt.Println("└────────────┴──────────────────────────┴──────────────┴──────────────┴──────────────┴──────────────┴──────────┘")
+}
+
+// PrintSaveModeTable prints a table comparing RGBA and paletted saving
+func PrintSaveModeTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Save Modes ───────────────┬──────────────┬──────────┬──────────────┬──────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-12s │ %-8s │ %-12s │\n",
+		"Processor", "Color Mode", "Save Mode", "Format", "Save Time", "Speedup", "File Size")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────────┼──────────┼──────────────┤")
+
+	// RGBA saving time per processor configuration, color mode and format is the reference
+	rgbaTimes := make(map[string]time.Duration)
+	for _, m := range metricas {
+		if m.SaveMode == "rgba" {
+			rgbaTimes[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.ColorMode, m.OutputFormat)] = m.SaveTime
+		}
+	}
+
+	for _, m := range metricas {
+		speedup := "-"
+		if rgba, ok := rgbaTimes[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.ColorMode, m.OutputFormat)]; ok && m.SaveTime > 0 {
+			speedup = fmt.Sprintf("%.2f", float64(rgba)/float64(m.SaveTime))
+		}
+
+		saveMag, saveUnit := getMagnitudeAndUnit(m.SaveTime)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-12s │ %8s │ %-12s │\n",
+			processorLabel(m),
+			m.ColorMode,
+			m.SaveMode,
+			m.OutputFormat,
+			formatNumber(saveMag, 5)+saveUnit,
+			speedup,
+			formatNumber(float64(m.OutputSize)/(1024*1024), 5)+" MB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────────┴──────────┴──────────────┘")
+}
+
+// PrintFormatTable prints a table comparing the saving time and size of each output format
+func PrintFormatTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Output Formats ───────────┬──────────────┬──────────┬──────────────┬──────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-12s │ %-8s │ %-12s │\n",
+		"Processor", "Color Mode", "Save Mode", "Format", "Save Time", "Speedup", "File Size")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────────┼──────────┼──────────────┤")
+
+	// JP2 saving time per processor configuration, color mode and save mode is the reference
+	jp2Times := make(map[string]time.Duration)
+	for _, m := range metricas {
+		if m.OutputFormat == "jp2" {
+			jp2Times[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.ColorMode, m.SaveMode)] = m.SaveTime
+		}
+	}
+
+	for _, m := range metricas {
+		speedup := "-"
+		if jp2, ok := jp2Times[fmt.Sprintf("%s %d %s %s", m.ProcessorType, m.NumThreads, m.ColorMode, m.SaveMode)]; ok && m.SaveTime > 0 {
+			speedup = fmt.Sprintf("%.2f", float64(jp2)/float64(m.SaveTime))
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		saveMag, saveUnit := getMagnitudeAndUnit(m.SaveTime)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-12s │ %8s │ %-12s │\n",
+			procLabel,
+			m.ColorMode,
+			m.SaveMode,
+			m.OutputFormat,
+			formatNumber(saveMag, 5)+saveUnit,
+			speedup,
+			formatNumber(float64(m.OutputSize)/(1024*1024), 5)+" MB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────────┴──────────┴──────────────┘")
+}
+
+// PrintQuickLookTable prints a table with the time and size of the PNG quicklook
+func PrintQuickLookTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Quicklook ────────────────┬──────────────┬──────────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %-12s │\n",
+		"Processor", "Color Mode", "Time", "Size", "File Size")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────────┼──────────────┤")
+
+	for _, m := range metricas {
+		q := m.QuickLook
+		if q.Width == 0 {
+			continue
+		}
+
+		quickLookMag, quickLookUnit := getMagnitudeAndUnit(m.QuickLookTime)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %-12s │\n",
+			processorLabel(m),
+			m.ColorMode,
+			formatNumber(quickLookMag, 5)+quickLookUnit,
+			fmt.Sprintf("%dx%d", q.Width, q.Height),
+			formatNumber(float64(q.Size)/1024, 5)+" KB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────────┴──────────────┘")
+}
+
+// PrintTileTable prints a table with the time, tile counts and size of the XYZ tile pyramid
+func PrintTileTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Tiles ────────────────────┬──────────────┬──────────┬──────────┬──────────┬──────────┬──────────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-8s │ %-8s │ %-8s │ %-12s │ %-12s │\n",
+		"Processor", "Color Mode", "Time", "Zoom", "Tiles", "Empty", "Unique", "Size", "Archive")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────┼──────────┼──────────┼──────────────┼──────────────┤")
+
+	for _, m := range metricas {
+		t := m.Tiles
+		if t.Tiles == 0 && t.EmptyTiles == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		// Unique contents and archive size only apply to PMTiles archives
+		unique, archive := "-", "-"
+		if t.ArchiveSize > 0 {
+			unique = fmt.Sprintf("%d", t.UniqueTiles)
+			archive = formatNumber(float64(t.ArchiveSize)/(1024*1024), 5) + " MB"
+		}
+
+		tileMag, tileUnit := getMagnitudeAndUnit(t.Time)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %8d │ %8d │ %8s │ %-12s │ %-12s │\n",
+			procLabel,
+			m.ColorMode,
+			formatNumber(tileMag, 5)+tileUnit,
+			fmt.Sprintf("%d-%d", t.MinZoom, t.MaxZoom),
+			t.Tiles,
+			t.EmptyTiles,
+			unique,
+			formatNumber(float64(t.Size)/(1024*1024), 5)+" MB",
+			archive)
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────┴──────────┴──────────┴──────────────┴──────────────┘")
+}
+
+// PrintKMZTable prints a table with the time and size of the KMZ ground overlay
+func PrintKMZTable(metricas []*Metrics) {
+	fmt.Println("\n┌ KMZ ──────────────────────┬──────────────┬──────────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %-12s │\n",
+		"Processor", "Color Mode", "Time", "Overlay", "File Size")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────────┼──────────────┤")
+
+	for _, m := range metricas {
+		k := m.KMZ
+		if k.Width == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		kmzMag, kmzUnit := getMagnitudeAndUnit(k.Time)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %-12s │\n",
+			procLabel,
+			m.ColorMode,
+			formatNumber(kmzMag, 5)+kmzUnit,
+			fmt.Sprintf("%dx%d", k.Width, k.Height),
+			formatNumber(float64(k.Size)/(1024*1024), 5)+" MB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────────┴──────────────┘")
+}
+
+// PrintRawTable prints a table with the time and size of the raw array export
+func PrintRawTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Raw Arrays ───────────────┬──────────────┬──────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-12s │\n",
+		"Processor", "Time", "Formats", "Files", "Size")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────────┤")
+
+	for _, m := range metricas {
+		r := m.Raw
+		if r.Files == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		rawMag, rawUnit := getMagnitudeAndUnit(r.Time)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %8d │ %-12s │\n",
+			procLabel,
+			formatNumber(rawMag, 5)+rawUnit,
+			r.Formats,
+			r.Files,
+			formatNumber(float64(r.Size)/(1024*1024), 5)+" MB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────────┘")
+}
+
+// PrintVectorTable prints a table with the polygons traced from the class raster
+func PrintVectorTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Polygons ─────────────────┬──────────────┬──────────┬──────────┬──────────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-8s │ %-12s │ %-12s │\n",
+		"Processor", "Time", "Regions", "Polygons", "Holes", "Vertices", "GeoJSON")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────┼──────────────┼──────────────┤")
+
+	for _, m := range metricas {
+		v := m.Vector
+		if v.Size == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		vectorMag, vectorUnit := getMagnitudeAndUnit(v.Time)
+		fmt.Printf("│ %-10s │ %-12s │ %12d │ %8d │ %8d │ %12d │ %-12s │\n",
+			procLabel,
+			formatNumber(vectorMag, 5)+vectorUnit,
+			v.Regions,
+			v.Polygons,
+			v.Holes,
+			v.Vertices,
+			formatNumber(float64(v.Size)/(1024*1024), 5)+" MB")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────┴──────────────┴──────────────┘")
+}
+
+// PrintProductTable prints a table with the quantized NDVI product round trip
+func PrintProductTable(metricas []*Metrics) {
+	fmt.Println("\n┌ NDVI Product ─────────────┬──────────────┬──────────────┬──────────┬────────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %-8s │ %-10s │ %-8s │\n",
+		"Processor", "Write", "Read", "File Size", "Step", "Max Error", "Mismatch")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────────┼──────────┼────────────┼──────────┤")
+
+	for _, m := range metricas {
+		p := m.Product
+		if p.Size == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		writeMag, writeUnit := getMagnitudeAndUnit(p.WriteTime)
+		if p.ReadTime == 0 {
+			// Products that are not read back have no round trip error
+			fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %8g │ %10s │ %8s │\n",
+				procLabel,
+				formatNumber(writeMag, 5)+writeUnit,
+				"-",
+				formatNumber(float64(p.Size)/(1024*1024), 5)+" MB",
+				p.Step,
+				"-",
+				"-")
+			continue
+		}
+		readMag, readUnit := getMagnitudeAndUnit(p.ReadTime)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-12s │ %8g │ %10.2e │ %8d │\n",
+			procLabel,
+			formatNumber(writeMag, 5)+writeUnit,
+			formatNumber(readMag, 5)+readUnit,
+			formatNumber(float64(p.Size)/(1024*1024), 5)+" MB",
+			p.Step,
+			p.MaxError,
+			p.ValidMismatches)
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────────┴──────────┴────────────┴──────────┘")
+}
+
+// PrintStretchTable prints the stretch parameters applied before colorization
+func PrintStretchTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Stretch ──────────────────┬──────────────┬──────────┬──────────┬──────────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-8s │ %-16s │\n",
+		"Processor", "Time", "Method", "Min", "Max", "Percentiles")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼──────────┼──────────────────┤")
+
+	for _, m := range metricas {
+		st := m.Stretch
+		if st.Method == "" {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		percentiles := "-"
+		if st.Method == "percentile" {
+			percentiles = fmt.Sprintf("%g%% - %g%%", st.LowPercentile, st.HighPercentile)
+		}
+
+		stretchMag, stretchUnit := getMagnitudeAndUnit(st.Time)
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %8.4f │ %8.4f │ %-16s │\n",
+			procLabel,
+			formatNumber(stretchMag, 5)+stretchUnit,
+			st.Method,
+			st.Min,
+			st.Max,
+			percentiles)
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴──────────┴──────────────────┘")
+}
+
+// PrintClassTable prints the legend and pixel statistics of a classified colorization
+func PrintClassTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Classes ──────────────────┬──────────────────────┬──────────────┬─────────┬──────────┬──────────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-20s │ %-12s │ %-7s │ %-8s │ %-12s │\n",
+		"Processor", "Scheme", "Class", "Range", "Color", "Pixels", "Area")
+	fmt.Println("├────────────┼──────────────┼──────────────────────┼──────────────┼─────────┼──────────┼──────────────┤")
+
+	for _, m := range metricas {
+		c := m.Classes
+		if c.TotalPixels == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+		total := float64(c.TotalPixels)
+
+		for i, class := range c.Classes {
+			var valueRange string
+			switch {
+			case i == 0 && i == len(c.Classes)-1:
+				valueRange = "all"
+			case i == 0:
+				valueRange = fmt.Sprintf("< %g", class.Max)
+			case i == len(c.Classes)-1:
+				valueRange = fmt.Sprintf(">= %g", class.Min)
+			default:
+				valueRange = fmt.Sprintf("[%g, %g)", class.Min, class.Max)
+			}
+
+			fmt.Printf("│ %-10s │ %-12s │ %-20.20s │ %-12s │ %-7s │ %s%%   │ %-12s │\n",
+				procLabel,
+				c.Scheme,
+				class.Label,
+				valueRange,
+				class.Color,
+				formatNumber(float64(class.Pixels)/total*100, 5),
+				formatNumber(class.Area, 5)+" ha")
+			procLabel = ""
+		}
+		fmt.Printf("│ %-10s │ %-12s │ %-20s │ %-12s │ %-7s │ %s%%   │ %-12s │\n",
+			"", "", "No data", "", "",
+			formatNumber(float64(c.NoDataPixels)/total*100, 5), "")
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────────────┴──────────────┴─────────┴──────────┴──────────────┘")
+}
+
+// PrintChangeTable prints a table with NDVI change detection statistics
+func PrintChangeTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Change Detection ─────────┬────────┬──────────┬────────┬────────┬──────────┬──────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-6s │ %-8s │ %-6s │ %-6s │ %-8s │ %-8s │ %-8s │\n",
+		"Processor", "Time", "Loss %", "Loss ha", "Stable", "Gain %", "Gain ha", "Mean Δ", "No Data")
+	fmt.Println("├────────────┼──────────────┼────────┼──────────┼────────┼────────┼──────────┼──────────┼──────────┤")
+
+	for _, m := range metricas {
+		c := m.Change
+		if c.TotalPixels == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		changeMag, changeUnit := getMagnitudeAndUnit(m.ChangeTime)
+		porcLoss := float64(c.LossPixels) / float64(c.TotalPixels) * 100
+		porcStable := float64(c.StablePixels) / float64(c.TotalPixels) * 100
+		porcGain := float64(c.GainPixels) / float64(c.TotalPixels) * 100
+		porcNoData := float64(c.NoDataPixels) / float64(c.TotalPixels) * 100
+
+		fmt.Printf("│ %-10s │ %-12s │ %s%% │ %-8s │ %s%% │ %s%% │ %-8s │ %8.4f │ %s%%   │\n",
+			procLabel,
+			formatNumber(changeMag, 5)+changeUnit,
+			formatNumber(porcLoss, 5), formatNumber(c.LossArea, 5)+" ha",
+			formatNumber(porcStable, 5),
+			formatNumber(porcGain, 5), formatNumber(c.GainArea, 5)+" ha",
+			c.MeanDelta,
+			formatNumber(porcNoData, 5))
+	}
+	fmt.Println("└────────────┴──────────────┴────────┴──────────┴────────┴────────┴──────────┴──────────┴──────────┘")
+}
+
+// PrintCompositeTable prints a table with multi-date compositing statistics
+func PrintCompositeTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Compositing ──────────────┬──────────┬────────┬──────────┬──────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-8s │ %-6s │ %-8s │ %-8s │ %-8s │\n",
+		"Processor", "Time", "Method", "Scenes", "Average", "No Data", "Share")
+	fmt.Println("├────────────┼──────────────┼──────────┼────────┼──────────┼──────────┼──────────┤")
+
+	for _, m := range metricas {
+		c := m.Composite
+		if c.TotalPixels == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		compositeMag, compositeUnit := getMagnitudeAndUnit(m.CompositeTime)
+		porcNoData := float64(c.NoDataPixels) / float64(c.TotalPixels) * 100
+
+		fmt.Printf("│ %-10s │ %-12s │ %-8s │ %-6d │ %8.4f │ %s%%   │ %-8s │\n",
+			procLabel,
+			formatNumber(compositeMag, 5)+compositeUnit,
+			c.Method,
+			c.Scenes,
+			c.Average,
+			formatNumber(porcNoData, 5),
+			"")
+
+		// One row per scene with the share of pixels it contributed
+		for scene, count := range c.SceneContributions {
+			porcScene := float64(count) / float64(c.TotalPixels) * 100
+			fmt.Printf("│ %-10s │ %-12s │ %-8s │ %-6d │ %-8s │ %-8s │ %s%%   │\n",
+				"", "", "scene", scene, "", "", formatNumber(porcScene, 5))
+		}
+	}
+	fmt.Println("└────────────┴──────────────┴──────────┴────────┴──────────┴──────────┴──────────┘")
+}
+
+// PrintCubeTable prints a table with temporal data cube statistics
+func PrintCubeTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Data Cube ────────────────┬──────────────┬──────────┬───────┬──────────┬──────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-5s │ %-8s │ %-8s │ %-8s │\n",
+		"Processor", "Build", "Smooth", "Method", "Dates", "Valid", "Filled", "Masked")
+	fmt.Println("├────────────┼──────────────┼──────────────┼──────────┼───────┼──────────┼──────────┼──────────┤")
+
+	for _, m := range metricas {
+		c := m.Cube
+		if c.Dates == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		buildMag, buildUnit := getMagnitudeAndUnit(c.Time)
+		smoothMag, smoothUnit := getMagnitudeAndUnit(c.SmoothTime)
+		observations := float64(c.Dates * c.TotalPixels)
+		porcValid := float64(c.ValidObservations) / observations * 100
+		porcFilled := float64(c.FilledObservations) / observations * 100
+		porcMasked := float64(c.MaskedObservations) / observations * 100
+
+		fmt.Printf("│ %-10s │ %-12s │ %-12s │ %-8s │ %-5d │ %s%%   │ %s%%   │ %s%%   │\n",
+			procLabel,
+			formatNumber(buildMag, 5)+buildUnit,
+			formatNumber(smoothMag, 5)+smoothUnit,
+			c.Smoothing,
+			c.Dates,
+			formatNumber(porcValid, 5),
+			formatNumber(porcFilled, 5),
+			formatNumber(porcMasked, 5))
+	}
+	fmt.Println("└────────────┴──────────────┴──────────────┴──────────┴───────┴──────────┴──────────┴──────────┘")
+}
+
+// PrintPhenologyTable prints a table with phenology metrics, overall and per field
+func PrintPhenologyTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Phenology ────────────────┬──────────┬────────┬────────┬────────┬────────┬────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-8s │ %-6s │ %-6s │ %-6s │ %-6s │ %-6s │ %-8s │\n",
+		"Processor", "Time", "Season", "SOS", "POS", "EOS", "Length", "Peak", "Integral")
+	fmt.Println("├────────────┼──────────────┼──────────┼────────┼────────┼────────┼────────┼────────┼──────────┤")
+
+	for _, m := range metricas {
+		p := m.Phenology
+		if p.TotalPixels == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		phenoMag, phenoUnit := getMagnitudeAndUnit(p.Time)
+		porcSeason := float64(p.SeasonPixels) / float64(p.TotalPixels) * 100
+
+		fmt.Printf("│ %-10s │ %-12s │ %s%%   │ %6.1f │ %6.1f │ %6.1f │ %6.1f │ %6.3f │ %8.2f │\n",
+			procLabel,
+			formatNumber(phenoMag, 5)+phenoUnit,
+			formatNumber(porcSeason, 5),
+			p.MeanSOS, p.MeanPOS, p.MeanEOS, p.MeanLength, p.MeanPeak, p.MeanIntegral)
+
+		// One row per field
+		for _, f := range p.Fields {
+			porcField := 0.0
+			if f.Pixels > 0 {
+				porcField = float64(f.SeasonPixels) / float64(f.Pixels) * 100
+			}
+			fmt.Printf("│ %-10s │ %-12s │ %s%%   │ %6.1f │ %6.1f │ %6.1f │ %6.1f │ %6.3f │ %8.2f │\n",
+				"",
+				fmt.Sprintf("field %d", f.ID),
+				formatNumber(porcField, 5),
+				f.MeanSOS, f.MeanPOS, f.MeanEOS, f.MeanLength, f.MeanPeak, f.MeanIntegral)
+		}
+	}
+	fmt.Println("└────────────┴──────────────┴──────────┴────────┴────────┴────────┴────────┴────────┴──────────┘")
+}
+
+// PrintAnomalyTable prints a table with NDVI anomaly statistics
+func PrintAnomalyTable(metricas []*Metrics) {
+	fmt.Println("\n┌ Anomaly Detection ────────┬───────┬──────────┬──────────┬──────────┬──────────┬──────────┬──────────┬──────────┐")
+	fmt.Printf("│ %-10s │ %-12s │ %-5s │ %-8s │ %-8s │ %-8s │ %-8s │ %-8s │ %-8s │ %-8s │\n",
+		"Processor", "Time", "Years", "z ≤ -S", "z ≤ -M", "Normal", "z ≥ M", "z ≥ S", "Mean z", "No Data")
+	fmt.Println("├────────────┼──────────────┼───────┼──────────┼──────────┼──────────┼──────────┼──────────┼──────────┼──────────┤")
+
+	for _, m := range metricas {
+		a := m.Anomaly
+		if a.TotalPixels == 0 {
+			continue
+		}
+
+		procLabel := fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+		if m.ProcessorType == "GPU" {
+			procLabel = "GPU"
+		}
+
+		anomalyMag, anomalyUnit := getMagnitudeAndUnit(a.Time)
+		total := float64(a.TotalPixels)
+
+		fmt.Printf("│ %-10s │ %-12s │ %-5d │ %s%%   │ %s%%   │ %s%%   │ %s%%   │ %s%%   │ %8.3f │ %s%%   │\n",
+			procLabel,
+			formatNumber(anomalyMag, 5)+anomalyUnit,
+			a.BaselineScenes,
+			formatNumber(float64(a.SevereNegativePixels)/total*100, 5),
+			formatNumber(float64(a.NegativePixels)/total*100, 5),
+			formatNumber(float64(a.NormalPixels)/total*100, 5),
+			formatNumber(float64(a.PositivePixels)/total*100, 5),
+			formatNumber(float64(a.SeverePositivePixels)/total*100, 5),
+			a.MeanZ,
+			formatNumber(float64(a.NoDataPixels)/total*100, 5))
+	}
+	fmt.Println("└────────────┴──────────────┴───────┴──────────┴──────────┴──────────┴──────────┴──────────┴──────────┴──────────┘")
+}
+
+// processorLabel returns the processor of a run, with its threads on the CPU
+func processorLabel(m *Metrics) string {
+	if m.ProcessorType == "GPU" {
+		return "GPU"
+	}
+	return fmt.Sprintf("%s %d", m.ProcessorType, m.NumThreads)
+}
+
+// configLabel returns the color mode, save mode and output format of a run
+func configLabel(m *Metrics) string {
+	return m.ColorMode + " " + m.SaveMode + " " + m.OutputFormat
+}
+
 // getMagnitudeAndUnit returns the appropriate magnitude and unit for a duration
 func getMagnitudeAndUnit(d time.Duration) (float64, string) {
 	if d < time.Microsecond {
@@ -212,6 +857,35 @@ func AggregateMetrics(accumulated, new *Metrics) {
 	accumulated.NDVITime += new.NDVITime
 	accumulated.ColorTime += new.ColorTime
 	accumulated.SaveTime += new.SaveTime
+	accumulated.Write.CopyTime += new.Write.CopyTime
+	accumulated.Write.EncodeTime += new.Write.EncodeTime
+	accumulated.Write.FileTime += new.Write.FileTime
+	accumulated.Write.TotalTime += new.Write.TotalTime
+	accumulated.ChangeTime += new.ChangeTime
+	accumulated.Change.TotalPixels += new.Change.TotalPixels
+	accumulated.Change.NoDataPixels += new.Change.NoDataPixels
+	accumulated.Change.LossPixels += new.Change.LossPixels
+	accumulated.Change.StablePixels += new.Change.StablePixels
+	accumulated.Change.GainPixels += new.Change.GainPixels
+	accumulated.Change.LossArea += new.Change.LossArea
+	accumulated.Change.GainArea += new.Change.GainArea
+	accumulated.Change.MinDelta = math.Min(accumulated.Change.MinDelta, new.Change.MinDelta)
+	accumulated.Change.MaxDelta = math.Max(accumulated.Change.MaxDelta, new.Change.MaxDelta)
+	accumulated.Change.MeanDelta += new.Change.MeanDelta
+	accumulated.CompositeTime += new.CompositeTime
+	accumulated.QuickLookTime += new.QuickLookTime
+	accumulated.Tiles.Time += new.Tiles.Time
+	accumulated.KMZ.Time += new.KMZ.Time
+	accumulated.Raw.Time += new.Raw.Time
+	accumulated.Vector.Time += new.Vector.Time
+	accumulated.Cube.Time += new.Cube.Time
+	accumulated.Cube.SmoothTime += new.Cube.SmoothTime
+	accumulated.Phenology.Time += new.Phenology.Time
+	accumulated.Anomaly.Time += new.Anomaly.Time
+	accumulated.Stretch.Time += new.Stretch.Time
+	accumulated.Product.WriteTime += new.Product.WriteTime
+	accumulated.Product.ReadTime += new.Product.ReadTime
+	accumulated.Product.MaxError = math.Max(accumulated.Product.MaxError, new.Product.MaxError)
 	accumulated.FileTimeNIR += new.FileTimeNIR
 	accumulated.DecodeTimeNIR += new.DecodeTimeNIR
 	accumulated.FileTimeRED += new.FileTimeRED
@@ -220,6 +894,7 @@ func AggregateMetrics(accumulated, new *Metrics) {
 	accumulated.NDVIMin = math.Min(accumulated.NDVIMin, new.NDVIMin)
 	accumulated.NDVIMax = math.Max(accumulated.NDVIMax, new.NDVIMax)
 	accumulated.NDVIAverage += new.NDVIAverage
+	accumulated.ColorMaxError = max(accumulated.ColorMaxError, new.ColorMaxError)
 }
 
 // AverageMetrics calculates the average of accumulated metrics
@@ -232,6 +907,24 @@ func AverageMetrics(accumulated *Metrics, numRuns int) *Metrics {
 	result.NDVITime /= time.Duration(numRuns)
 	result.ColorTime /= time.Duration(numRuns)
 	result.SaveTime /= time.Duration(numRuns)
+	result.Write.CopyTime /= time.Duration(numRuns)
+	result.Write.EncodeTime /= time.Duration(numRuns)
+	result.Write.FileTime /= time.Duration(numRuns)
+	result.Write.TotalTime /= time.Duration(numRuns)
+	result.ChangeTime /= time.Duration(numRuns)
+	result.CompositeTime /= time.Duration(numRuns)
+	result.QuickLookTime /= time.Duration(numRuns)
+	result.Tiles.Time /= time.Duration(numRuns)
+	result.KMZ.Time /= time.Duration(numRuns)
+	result.Raw.Time /= time.Duration(numRuns)
+	result.Vector.Time /= time.Duration(numRuns)
+	result.Cube.Time /= time.Duration(numRuns)
+	result.Cube.SmoothTime /= time.Duration(numRuns)
+	result.Phenology.Time /= time.Duration(numRuns)
+	result.Anomaly.Time /= time.Duration(numRuns)
+	result.Stretch.Time /= time.Duration(numRuns)
+	result.Product.WriteTime /= time.Duration(numRuns)
+	result.Product.ReadTime /= time.Duration(numRuns)
 	result.FileTimeNIR /= time.Duration(numRuns)
 	result.DecodeTimeNIR /= time.Duration(numRuns)
 	result.FileTimeRED /= time.Duration(numRuns)
@@ -240,6 +933,14 @@ func AverageMetrics(accumulated *Metrics, numRuns int) *Metrics {
 	// Average numeric values
 	result.NoDataPixels /= numRuns
 	result.NDVIAverage /= float64(numRuns)
+	result.Change.TotalPixels /= numRuns
+	result.Change.NoDataPixels /= numRuns
+	result.Change.LossPixels /= numRuns
+	result.Change.StablePixels /= numRuns
+	result.Change.GainPixels /= numRuns
+	result.Change.LossArea /= float64(numRuns)
+	result.Change.GainArea /= float64(numRuns)
+	result.Change.MeanDelta /= float64(numRuns)
 
 	// Min/Max and other static values remain the same
 
@@ -24,7 +24,30 @@ type Metrics struct {
 	NDVIAverage   float64
 	NumTilesNIR   int
 	NumTilesRED   int
+	ColorMode     string // "exact", "lut" or "classes"
+	LUTSize       int
+	ColorMaxError int // Largest channel difference of the LUT image against exact interpolation
+	Classes       ClassMetrics
+	Stretch       StretchMetrics
+	SaveMode      string // "rgba", "paletted" or "tiled"
+	OutputFormat  string // "jp2", "jp2tile" or "gtiff"
+	OutputSize    int64  // Size in bytes of the saved file
+	Write         WriteMetrics
+	Product       ProductMetrics
+	QuickLookTime time.Duration
+	QuickLook     QuickLookMetrics
+	Tiles         TileMetrics
+	KMZ           KMZMetrics
+	Raw           RawMetrics
+	Vector        VectorMetrics
 	CPUMetrics    CPUMetrics
+	ChangeTime    time.Duration
+	Change        ChangeMetrics
+	CompositeTime time.Duration
+	Composite     CompositeMetrics
+	Cube          CubeMetrics
+	Phenology     PhenologyMetrics
+	Anomaly       AnomalyMetrics
 }
 
 // ReadMetrics contains metrics associated with reading a JP2 file
@@ -37,6 +60,18 @@ type ReadMetrics struct {
 	GetInfoTime time.Duration
 }
 
+// WriteMetrics contains metrics associated with writing an image file
+// Stages a writer interleaves, such as OpenJPEG writing the file while encoding, are
+// counted in the first of them
+type WriteMetrics struct {
+	CopyTime         time.Duration // Copying the pixels to the encoder, including host to device transfers
+	EncodeTime       time.Duration
+	FileTime         time.Duration // Writing the encoded file
+	TotalTime        time.Duration
+	Bytes            int64   // Bytes written
+	CompressionRatio float64 // Uncompressed sample size over bytes written
+}
+
 // NDVIMetrics contains metrics for NDVI calculation
 type NDVIMetrics struct {
 	Time         time.Duration
@@ -51,6 +86,89 @@ type NDVIMetrics struct {
 type ColorMetrics struct {
 	Time      time.Duration
 	ImageSize int64
+	LUTSize   int           // Lookup table entries, 0 for exact interpolation
+	Classes   *ClassMetrics // Class statistics of a classified colorization, nil otherwise
+}
+
+// ProductMetrics contains metrics for the quantized NDVI data product
+type ProductMetrics struct {
+	WriteTime       time.Duration
+	ReadTime        time.Duration // Time to read the product back and dequantize it, 0 when it is not read back
+	Size            int64         // Size in bytes of the product file
+	Step            float64       // Quantization step
+	MaxError        float64       // Largest absolute difference after the round trip
+	ValidMismatches int           // Pixels whose no-data flag changed in the round trip
+}
+
+// StretchMetrics contains the parameters of the stretch applied before colorization,
+// so a colorized output can be reproduced
+type StretchMetrics struct {
+	Time           time.Duration
+	Method         string
+	Min            float64 // NDVI value mapped to the start of the colormap
+	Max            float64 // NDVI value mapped to the end of the colormap
+	LowPercentile  float64 // Percentiles of the percentile method
+	HighPercentile float64
+}
+
+// QuickLookMetrics contains metrics for the PNG quicklook of the NDVI map
+type QuickLookMetrics struct {
+	Width, Height int
+	Size          int64 // Size in bytes of the PNG file
+}
+
+// TileMetrics contains metrics for the XYZ tile pyramid of the NDVI map
+type TileMetrics struct {
+	Time             time.Duration
+	MinZoom, MaxZoom int
+	Tiles            int   // Tiles written
+	EmptyTiles       int   // Tiles of the extent without data, not written
+	Size             int64 // Total size in bytes of the written tiles
+	UniqueTiles      int   // Distinct tile contents stored in a PMTiles archive
+	ArchiveSize      int64 // Size in bytes of the PMTiles archive, 0 for z/x/y directories
+}
+
+// KMZMetrics contains metrics for the KMZ ground overlay of the NDVI map
+type KMZMetrics struct {
+	Time          time.Duration
+	Width, Height int   // Size of the overlay image reprojected to WGS84
+	Size          int64 // Size in bytes of the KMZ file
+}
+
+// RawMetrics contains metrics for the raw array export of the NDVI values and bands
+type RawMetrics struct {
+	Time    time.Duration
+	Formats string // Comma-separated formats, "npy" and/or "envi"
+	Files   int
+	Size    int64 // Total size in bytes of the written files
+}
+
+// VectorMetrics contains metrics for the polygonisation of the class raster
+type VectorMetrics struct {
+	Time     time.Duration
+	Regions  int   // Connected regions traced from the class raster
+	Polygons int   // Polygons kept by the minimum area
+	Holes    int   // Holes kept in the polygons
+	Vertices int   // Vertices after simplification, without closing vertices
+	Size     int64 // Size in bytes of the GeoJSON file
+}
+
+// ClassMetrics contains per-class statistics of a classified colorization
+type ClassMetrics struct {
+	Scheme       string
+	TotalPixels  int
+	NoDataPixels int
+	Classes      []ClassCount
+}
+
+// ClassCount contains the legend entry, pixel count and area of one class
+type ClassCount struct {
+	Label  string
+	Min    float64 // Inclusive lower bound, -Inf for the first class
+	Max    float64 // Exclusive upper bound, +Inf for the last class
+	Color  string  // #rrggbb
+	Pixels int
+	Area   float64 // Hectares
 }
 
 // CPUMetrics contains CPU-specific time metrics
@@ -59,3 +177,86 @@ type CPUMetrics struct {
 	DecodeTime time.Duration
 	TotalTime  time.Duration
 }
+
+// ChangeMetrics contains metrics for NDVI change detection between two dates
+type ChangeMetrics struct {
+	TotalPixels  int
+	NoDataPixels int // Pixels without data in at least one date
+	LossPixels   int
+	StablePixels int
+	GainPixels   int
+	LossArea     float64 // Hectares
+	GainArea     float64 // Hectares
+	MinDelta     float64
+	MaxDelta     float64
+	MeanDelta    float64
+}
+
+// CompositeMetrics contains metrics for multi-date NDVI compositing
+type CompositeMetrics struct {
+	Time               time.Duration
+	Method             string
+	Scenes             int
+	TotalPixels        int
+	NoDataPixels       int   // Pixels without data in every scene
+	SceneContributions []int // Pixels taken from each scene
+	Min                float64
+	Max                float64
+	Average            float64
+}
+
+// CubeMetrics contains metrics for the temporal NDVI data cube
+type CubeMetrics struct {
+	Time               time.Duration // Time spent reading scenes and stacking them
+	SmoothTime         time.Duration // Time spent filling gaps and smoothing
+	Smoothing          string
+	Dates              int
+	TotalPixels        int
+	ValidObservations  int
+	MaskedObservations int // Observations still masked after gap filling
+	FilledObservations int
+}
+
+// PhenologyMetrics contains metrics for phenology extraction
+// Dates are days of year of the first cube date's year
+type PhenologyMetrics struct {
+	Time         time.Duration
+	TotalPixels  int
+	SeasonPixels int // Pixels where a season could be extracted
+	MeanSOS      float64
+	MeanEOS      float64
+	MeanPOS      float64
+	MeanLength   float64
+	MeanPeak     float64
+	MeanIntegral float64
+	Fields       []FieldPhenology
+}
+
+// FieldPhenology contains the mean phenology metrics of one field
+type FieldPhenology struct {
+	ID           int
+	Pixels       int
+	SeasonPixels int
+	MeanSOS      float64
+	MeanEOS      float64
+	MeanPOS      float64
+	MeanLength   float64
+	MeanPeak     float64
+	MeanIntegral float64
+}
+
+// AnomalyMetrics contains metrics for NDVI anomaly detection against a baseline
+type AnomalyMetrics struct {
+	Time                 time.Duration
+	BaselineScenes       int
+	TotalPixels          int
+	NoDataPixels         int // Pixels without current data or enough history
+	SevereNegativePixels int
+	NegativePixels       int
+	NormalPixels         int
+	PositivePixels       int
+	SeverePositivePixels int
+	MinZ                 float64
+	MaxZ                 float64
+	MeanZ                float64
+}
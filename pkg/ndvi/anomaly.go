@@ -0,0 +1,311 @@
+package ndvi
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Anomaly classes stored in Anomaly.Classes
+const (
+	AnomalyNoData         uint8 = iota // No current data or not enough history
+	AnomalySevereNegative              // z-score at or below -SevereZ
+	AnomalyNegative                    // z-score at or below -ModerateZ
+	AnomalyNormal                      // z-score within ±ModerateZ
+	AnomalyPositive                    // z-score at or above ModerateZ
+	AnomalySeverePositive              // z-score at or above SevereZ
+)
+
+// AnomalyOptions configures the z-score classification
+type AnomalyOptions struct {
+	ModerateZ       float64 // Absolute z-score of a moderate anomaly (e.g. 1)
+	SevereZ         float64 // Absolute z-score of a severe anomaly (e.g. 2)
+	MinObservations int     // Pixels with fewer historical observations are left as no data
+	MinStd          float64 // Lower bound of the standard deviation to avoid huge z-scores on stable pixels
+}
+
+// DefaultAnomalyOptions returns moderate and severe anomalies at 1 and 2 standard deviations
+func DefaultAnomalyOptions() AnomalyOptions {
+	return AnomalyOptions{
+		ModerateZ:       1,
+		SevereZ:         2,
+		MinObservations: 3,
+		MinStd:          0.01,
+	}
+}
+
+// Baseline holds the per-pixel NDVI statistics of a set of historical rasters
+type Baseline struct {
+	Width, Height int
+	Mean          []float64
+	Std           []float64 // Sample standard deviation
+	Count         []uint16  // Valid historical observations per pixel
+}
+
+// BaselineBuilder accumulates historical NDVI rasters one at a time using
+// Welford's online algorithm, so memory does not grow with the number of years
+type BaselineBuilder struct {
+	width, height int
+	numThreads    int
+	count         []uint16
+	mean          []float64
+	m2            []float64
+}
+
+// NewBaselineBuilder creates a baseline builder for rasters of the given grid size
+func NewBaselineBuilder(width, height, numThreads int) *BaselineBuilder {
+	pixelCount := width * height
+	return &BaselineBuilder{
+		width:      width,
+		height:     height,
+		numThreads: numThreads,
+		count:      make([]uint16, pixelCount),
+		mean:       make([]float64, pixelCount),
+		m2:         make([]float64, pixelCount),
+	}
+}
+
+// Add accumulates a historical NDVI raster into the baseline
+func (b *BaselineBuilder) Add(result *Result) error {
+	if result.Width != b.width || result.Height != b.height {
+		return &ResultDimensionError{
+			BeforeWidth:  b.width,
+			BeforeHeight: b.height,
+			AfterWidth:   result.Width,
+			AfterHeight:  result.Height,
+		}
+	}
+
+	pixelCount := b.width * b.height
+
+	// Setup parallel processing
+	numWorkers := b.numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if !result.Valid[i] || b.count[i] == math.MaxUint16 {
+					continue
+				}
+				b.count[i]++
+				delta := result.Values[i] - b.mean[i]
+				b.mean[i] += delta / float64(b.count[i])
+				b.m2[i] += delta * (result.Values[i] - b.mean[i])
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// Finish returns the baseline mean and standard deviation
+func (b *BaselineBuilder) Finish() *Baseline {
+	pixelCount := b.width * b.height
+	std := make([]float64, pixelCount)
+	for i, n := range b.count {
+		if n > 1 {
+			std[i] = math.Sqrt(b.m2[i] / float64(n-1))
+		}
+	}
+	b.m2 = nil
+
+	return &Baseline{
+		Width:  b.width,
+		Height: b.height,
+		Mean:   b.mean,
+		Std:    std,
+		Count:  b.count,
+	}
+}
+
+// Anomaly holds the per-pixel comparison of an NDVI result with a baseline
+type Anomaly struct {
+	Width, Height int
+	ZScore        []float64 // (value - mean) / std, 0 where there is no data
+	Classes       []uint8   // One of the Anomaly* classes
+}
+
+// DetectAnomaly compares the current NDVI result with a historical baseline
+// Returns anomaly metrics, the anomaly result, and any error
+func DetectAnomaly(current *Result, baseline *Baseline, opts AnomalyOptions, numThreads int) (*metrics.AnomalyMetrics, *Anomaly, error) {
+	if current.Width != baseline.Width || current.Height != baseline.Height {
+		return nil, nil, &ResultDimensionError{
+			BeforeWidth:  baseline.Width,
+			BeforeHeight: baseline.Height,
+			AfterWidth:   current.Width,
+			AfterHeight:  current.Height,
+		}
+	}
+	if opts.ModerateZ <= 0 || opts.SevereZ < opts.ModerateZ {
+		return nil, nil, fmt.Errorf("invalid anomaly thresholds: moderate %v, severe %v", opts.ModerateZ, opts.SevereZ)
+	}
+
+	pixelCount := current.Width * current.Height
+	anomaly := &Anomaly{
+		Width:   current.Width,
+		Height:  current.Height,
+		ZScore:  make([]float64, pixelCount),
+		Classes: make([]uint8, pixelCount),
+	}
+
+	// Setup parallel processing
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	minVals := make([]float64, numWorkers)
+	maxVals := make([]float64, numWorkers)
+	sums := make([]float64, numWorkers)
+	classCounts := make([][6]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localMin := math.MaxFloat64
+			localMax := -math.MaxFloat64
+			localSum := 0.0
+			var localCounts [6]int
+
+			for i := start; i < end; i++ {
+				if !current.Valid[i] || int(baseline.Count[i]) < opts.MinObservations {
+					anomaly.Classes[i] = AnomalyNoData
+					localCounts[AnomalyNoData]++
+					continue
+				}
+
+				z := (current.Values[i] - baseline.Mean[i]) / math.Max(baseline.Std[i], opts.MinStd)
+				anomaly.ZScore[i] = z
+
+				class := AnomalyNormal
+				switch {
+				case z <= -opts.SevereZ:
+					class = AnomalySevereNegative
+				case z <= -opts.ModerateZ:
+					class = AnomalyNegative
+				case z >= opts.SevereZ:
+					class = AnomalySeverePositive
+				case z >= opts.ModerateZ:
+					class = AnomalyPositive
+				}
+				anomaly.Classes[i] = class
+				localCounts[class]++
+
+				if z < localMin {
+					localMin = z
+				}
+				if z > localMax {
+					localMax = z
+				}
+				localSum += z
+			}
+
+			minVals[worker] = localMin
+			maxVals[worker] = localMax
+			sums[worker] = localSum
+			classCounts[worker] = localCounts
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results
+	anomalyMetrics := &metrics.AnomalyMetrics{
+		TotalPixels: pixelCount,
+		MinZ:        math.MaxFloat64,
+		MaxZ:        -math.MaxFloat64,
+	}
+	totalSum := 0.0
+	for i := 0; i < numWorkers; i++ {
+		anomalyMetrics.MinZ = math.Min(anomalyMetrics.MinZ, minVals[i])
+		anomalyMetrics.MaxZ = math.Max(anomalyMetrics.MaxZ, maxVals[i])
+		totalSum += sums[i]
+		anomalyMetrics.NoDataPixels += classCounts[i][AnomalyNoData]
+		anomalyMetrics.SevereNegativePixels += classCounts[i][AnomalySevereNegative]
+		anomalyMetrics.NegativePixels += classCounts[i][AnomalyNegative]
+		anomalyMetrics.NormalPixels += classCounts[i][AnomalyNormal]
+		anomalyMetrics.PositivePixels += classCounts[i][AnomalyPositive]
+		anomalyMetrics.SeverePositivePixels += classCounts[i][AnomalySeverePositive]
+	}
+
+	validPixels := pixelCount - anomalyMetrics.NoDataPixels
+	if validPixels > 0 {
+		anomalyMetrics.MeanZ = totalSum / float64(validPixels)
+	} else {
+		anomalyMetrics.MinZ = 0
+		anomalyMetrics.MaxZ = 0
+	}
+
+	return anomalyMetrics, anomaly, nil
+}
+
+// BuildBaseline reads the historical scenes one at a time and accumulates those
+// whose day of year lies within window days of date; a window of 0 keeps every scene
+// Scenes without a Date take the date parsed from their NIR file name
+// Returns the baseline, the number of scenes used, and any error
+func BuildBaseline(reader jp2.Reader, scenes []ScenePair, date time.Time, window int, numThreads int) (*Baseline, int, error) {
+	var builder *BaselineBuilder
+	used := 0
+
+	for i, scene := range scenes {
+		if window > 0 {
+			sceneDate := scene.Date
+			if sceneDate.IsZero() {
+				parsed, err := ParseSceneDate(scene.NIR)
+				if err != nil {
+					return nil, 0, fmt.Errorf("scene %d: %v", i, err)
+				}
+				sceneDate = parsed
+			}
+			if dayOfYearDistance(date, sceneDate) > window {
+				continue
+			}
+		}
+
+		result, err := readSceneNDVI(reader, scene, numThreads)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scene %d: %v", i, err)
+		}
+
+		if builder == nil {
+			builder = NewBaselineBuilder(result.Width, result.Height, numThreads)
+		}
+		if err := builder.Add(result); err != nil {
+			return nil, 0, fmt.Errorf("scene %d: %v", i, err)
+		}
+		used++
+	}
+
+	if builder == nil {
+		return nil, 0, fmt.Errorf("no historical scenes within %d days of day %d", window, date.YearDay())
+	}
+
+	return builder.Finish(), used, nil
+}
+
+// dayOfYearDistance returns the number of days between the days of year of a and b,
+// wrapping around the turn of the year
+func dayOfYearDistance(a, b time.Time) int {
+	d := a.YearDay() - b.YearDay()
+	if d < 0 {
+		d = -d
+	}
+	return min(d, 365-d)
+}
@@ -8,9 +8,46 @@ import (
 	"github.com/luismi/jp2_processing/pkg/metrics"
 )
 
+// Result holds NDVI values together with the grid they cover and their validity
+type Result struct {
+	Width, Height int
+	Values        []float64 // NDVI value per pixel, 0 where there is no data
+	Valid         []bool    // false where NIR+RED had no signal
+}
+
 // Calculate computes NDVI values from NIR and RED bands
 // Returns NDVI metrics, float64 array with NDVI values, and any error
 func Calculate(nirBand, redBand *jp2.BandResult, numThreads int) (*metrics.NDVIMetrics, []float64, error) {
+	return calculate(nirBand, redBand, nil, numThreads)
+}
+
+// CalculateResult computes NDVI values like Calculate and also records the no-data mask
+func CalculateResult(nirBand, redBand *jp2.BandResult, numThreads int) (*metrics.NDVIMetrics, *Result, error) {
+	if nirBand.Image.Width != redBand.Image.Width || nirBand.Image.Height != redBand.Image.Height {
+		return nil, nil, &ImageDimensionError{
+			NIRWidth:  nirBand.Image.Width,
+			NIRHeight: nirBand.Image.Height,
+			REDWidth:  redBand.Image.Width,
+			REDHeight: redBand.Image.Height,
+		}
+	}
+
+	valid := make([]bool, nirBand.Image.Width*nirBand.Image.Height)
+	ndviMetrics, ndviData, err := calculate(nirBand, redBand, valid, numThreads)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ndviMetrics, &Result{
+		Width:  nirBand.Image.Width,
+		Height: nirBand.Image.Height,
+		Values: ndviData,
+		Valid:  valid,
+	}, nil
+}
+
+// calculate computes NDVI values, filling valid when it is not nil
+func calculate(nirBand, redBand *jp2.BandResult, valid []bool, numThreads int) (*metrics.NDVIMetrics, []float64, error) {
 	ndviMetrics := &metrics.NDVIMetrics{
 		Min: math.MaxFloat64,
 		Max: -math.MaxFloat64,
@@ -73,6 +110,9 @@ func Calculate(nirBand, redBand *jp2.BandResult, numThreads int) (*metrics.NDVIM
 					localNoData++
 				}
 				ndviData[i] = ndviValue
+				if valid != nil {
+					valid[i] = sum > 0
+				}
 
 				if ndviValue < localMin {
 					localMin = ndviValue
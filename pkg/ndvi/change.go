@@ -0,0 +1,169 @@
+package ndvi
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Change classes stored in ChangeResult.Classes
+const (
+	ChangeNoData uint8 = iota // No data in at least one of the dates
+	ChangeLoss                // NDVI decreased beyond the loss threshold
+	ChangeStable              // NDVI difference within the thresholds
+	ChangeGain                // NDVI increased beyond the gain threshold
+)
+
+// ChangeOptions configures NDVI change detection
+type ChangeOptions struct {
+	LossThreshold float64 // Delta at or below this value is classified as loss (e.g. -0.1)
+	GainThreshold float64 // Delta at or above this value is classified as gain (e.g. 0.1)
+	PixelArea     float64 // Ground area of a pixel in square meters, used for area statistics
+}
+
+// DefaultChangeOptions returns thresholds of ±0.1 for Sentinel-2 10m pixels
+func DefaultChangeOptions() ChangeOptions {
+	return ChangeOptions{
+		LossThreshold: -0.1,
+		GainThreshold: 0.1,
+		PixelArea:     100,
+	}
+}
+
+// ChangeResult holds the per-pixel outcome of comparing two NDVI results
+type ChangeResult struct {
+	Width, Height int
+	Delta         []float64 // after - before, 0 where there is no data
+	Relative      []float64 // (after - before) / |before|, 0 where undefined
+	Classes       []uint8   // One of ChangeNoData, ChangeLoss, ChangeStable, ChangeGain
+}
+
+// DetectChange compares two NDVI results computed on the same grid
+// Returns change metrics, the change result, and any error
+func DetectChange(before, after *Result, opts ChangeOptions, numThreads int) (*metrics.ChangeMetrics, *ChangeResult, error) {
+	if before.Width != after.Width || before.Height != after.Height {
+		return nil, nil, &ResultDimensionError{
+			BeforeWidth:  before.Width,
+			BeforeHeight: before.Height,
+			AfterWidth:   after.Width,
+			AfterHeight:  after.Height,
+		}
+	}
+	if opts.LossThreshold > opts.GainThreshold {
+		return nil, nil, fmt.Errorf("loss threshold %v is greater than gain threshold %v", opts.LossThreshold, opts.GainThreshold)
+	}
+
+	pixelCount := before.Width * before.Height
+	result := &ChangeResult{
+		Width:    before.Width,
+		Height:   before.Height,
+		Delta:    make([]float64, pixelCount),
+		Relative: make([]float64, pixelCount),
+		Classes:  make([]uint8, pixelCount),
+	}
+
+	// Setup parallel processing
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	minVals := make([]float64, numWorkers)
+	maxVals := make([]float64, numWorkers)
+	sums := make([]float64, numWorkers)
+	classCounts := make([][4]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localMin := math.MaxFloat64
+			localMax := -math.MaxFloat64
+			localSum := 0.0
+			var localCounts [4]int
+
+			for i := start; i < end; i++ {
+				if !before.Valid[i] || !after.Valid[i] {
+					result.Classes[i] = ChangeNoData
+					localCounts[ChangeNoData]++
+					continue
+				}
+
+				delta := after.Values[i] - before.Values[i]
+				result.Delta[i] = delta
+				if base := math.Abs(before.Values[i]); base > 0 {
+					result.Relative[i] = delta / base
+				}
+
+				class := ChangeStable
+				if delta <= opts.LossThreshold {
+					class = ChangeLoss
+				} else if delta >= opts.GainThreshold {
+					class = ChangeGain
+				}
+				result.Classes[i] = class
+				localCounts[class]++
+
+				if delta < localMin {
+					localMin = delta
+				}
+				if delta > localMax {
+					localMax = delta
+				}
+				localSum += delta
+			}
+
+			minVals[worker] = localMin
+			maxVals[worker] = localMax
+			sums[worker] = localSum
+			classCounts[worker] = localCounts
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results
+	changeMetrics := &metrics.ChangeMetrics{
+		TotalPixels: pixelCount,
+		MinDelta:    math.MaxFloat64,
+		MaxDelta:    -math.MaxFloat64,
+	}
+	totalSum := 0.0
+	for i := 0; i < numWorkers; i++ {
+		changeMetrics.MinDelta = math.Min(changeMetrics.MinDelta, minVals[i])
+		changeMetrics.MaxDelta = math.Max(changeMetrics.MaxDelta, maxVals[i])
+		totalSum += sums[i]
+		changeMetrics.NoDataPixels += classCounts[i][ChangeNoData]
+		changeMetrics.LossPixels += classCounts[i][ChangeLoss]
+		changeMetrics.StablePixels += classCounts[i][ChangeStable]
+		changeMetrics.GainPixels += classCounts[i][ChangeGain]
+	}
+
+	validPixels := pixelCount - changeMetrics.NoDataPixels
+	if validPixels > 0 {
+		changeMetrics.MeanDelta = totalSum / float64(validPixels)
+	} else {
+		changeMetrics.MinDelta = 0
+		changeMetrics.MaxDelta = 0
+	}
+
+	// Areas are reported in hectares
+	changeMetrics.LossArea = float64(changeMetrics.LossPixels) * opts.PixelArea / 10000
+	changeMetrics.GainArea = float64(changeMetrics.GainPixels) * opts.PixelArea / 10000
+
+	return changeMetrics, result, nil
+}
+
+// ResultDimensionError is returned when two NDVI results are not on the same grid
+type ResultDimensionError struct {
+	BeforeWidth, BeforeHeight, AfterWidth, AfterHeight int
+}
+
+func (e *ResultDimensionError) Error() string {
+	return fmt.Sprintf("NDVI results have different dimensions: %dx%d and %dx%d",
+		e.BeforeWidth, e.BeforeHeight, e.AfterWidth, e.AfterHeight)
+}
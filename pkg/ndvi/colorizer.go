@@ -1,15 +1,17 @@
 package ndvi
 
 import (
+	"fmt"
 	"image"
+	"math"
 	"sync"
 
 	"github.com/luismi/jp2_processing/config"
 	"github.com/luismi/jp2_processing/pkg/metrics"
 )
 
-// Colorize converts NDVI values to a color image
-func Colorize(ndviData []float64, width, height int, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
+// Colorize converts NDVI values to a color image using the given colormap
+func Colorize(ndviData []float64, width, height int, cmap *config.Colormap, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
 	colorMetrics := &metrics.ColorMetrics{}
 
 	// Create output RGBA image
@@ -32,12 +34,12 @@ func Colorize(ndviData []float64, width, height int, numThreads int) (*metrics.C
 		go func(start, end int) {
 			defer wg.Done()
 			for i := start; i < end; i++ {
-				rgba := config.GetNDVIColor(ndviData[i])
+				rgba := cmap.Color(ndviData[i])
 				idx := i * 4
 				colorPix[idx] = rgba.R
 				colorPix[idx+1] = rgba.G
 				colorPix[idx+2] = rgba.B
-				colorPix[idx+3] = 255 // Full opacity
+				colorPix[idx+3] = rgba.A
 			}
 		}(start, end)
 	}
@@ -49,3 +51,431 @@ func Colorize(ndviData []float64, width, height int, numThreads int) (*metrics.C
 
 	return colorMetrics, ndviColorImg
 }
+
+// ColorizeChange converts an NDVI change result to a color image using a diverging colormap
+// Pixels without data in either date take the colormap's no-data color
+func ColorizeChange(change *ChangeResult, cmap *config.Colormap, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
+	colorMetrics := &metrics.ColorMetrics{}
+
+	width, height := change.Width, change.Height
+	changeColorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	colorPix := changeColorImg.Pix
+
+	pixelCount := width * height
+
+	// Process color in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				rgba := cmap.NoData
+				if change.Classes[i] != ChangeNoData {
+					rgba = cmap.Color(change.Delta[i])
+				}
+				idx := i * 4
+				colorPix[idx] = rgba.R
+				colorPix[idx+1] = rgba.G
+				colorPix[idx+2] = rgba.B
+				colorPix[idx+3] = rgba.A
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	// Set image size in bytes (4 bytes per pixel RGBA)
+	colorMetrics.ImageSize = int64(width * height * 4)
+
+	return colorMetrics, changeColorImg
+}
+
+// ColorizeAnomaly converts NDVI z-scores to a color image using a diverging colormap
+// Pixels without data or history take the colormap's no-data color
+func ColorizeAnomaly(anomaly *Anomaly, cmap *config.Colormap, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
+	colorMetrics := &metrics.ColorMetrics{}
+
+	width, height := anomaly.Width, anomaly.Height
+	anomalyColorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	colorPix := anomalyColorImg.Pix
+
+	pixelCount := width * height
+
+	// Process color in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				rgba := cmap.NoData
+				if anomaly.Classes[i] != AnomalyNoData {
+					rgba = cmap.Color(anomaly.ZScore[i])
+				}
+				idx := i * 4
+				colorPix[idx] = rgba.R
+				colorPix[idx+1] = rgba.G
+				colorPix[idx+2] = rgba.B
+				colorPix[idx+3] = rgba.A
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	// Set image size in bytes (4 bytes per pixel RGBA)
+	colorMetrics.ImageSize = int64(width * height * 4)
+
+	return colorMetrics, anomalyColorImg
+}
+
+// ColorizeLayer converts a layer with an arbitrary value range to a color image by mapping
+// [lo, hi] linearly onto the [-1, 1] range of an NDVI colormap
+// Invalid pixels take the colormap's no-data color and a zero span maps to the midpoint
+func ColorizeLayer(layer *Result, lo, hi float64, cmap *config.Colormap, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
+	colorMetrics := &metrics.ColorMetrics{}
+
+	width, height := layer.Width, layer.Height
+	layerColorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	colorPix := layerColorImg.Pix
+
+	pixelCount := width * height
+	span := hi - lo
+
+	// Process color in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				rgba := cmap.NoData
+				if layer.Valid[i] {
+					scaled := 0.0
+					if span != 0 {
+						scaled = math.Max(-1, math.Min(1, 2*(layer.Values[i]-lo)/span-1))
+					}
+					rgba = cmap.Color(scaled)
+				}
+				idx := i * 4
+				colorPix[idx] = rgba.R
+				colorPix[idx+1] = rgba.G
+				colorPix[idx+2] = rgba.B
+				colorPix[idx+3] = rgba.A
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	// Set image size in bytes (4 bytes per pixel RGBA)
+	colorMetrics.ImageSize = int64(width * height * 4)
+
+	return colorMetrics, layerColorImg
+}
+
+// ColorizeLUT converts NDVI values to a color image using a precomputed lookup table
+func ColorizeLUT(ndviData []float64, width, height int, lut *config.ColorLUT, numThreads int) (*metrics.ColorMetrics, *image.RGBA) {
+	colorMetrics := &metrics.ColorMetrics{}
+
+	// Create output RGBA image
+	ndviColorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	colorPix := ndviColorImg.Pix
+	lutPix := lut.Pix
+	lastIdx := lut.Size() - 1
+
+	pixelCount := width * height
+
+	// Process color in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				// Quantize to the nearest entry and copy its 4 bytes
+				k := int((ndviData[i]-lut.Min)*lut.Scale + 0.5)
+				if k < 0 {
+					k = 0
+				} else if k > lastIdx {
+					k = lastIdx
+				}
+				copy(colorPix[i*4:i*4+4], lutPix[k*4:k*4+4])
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	// Set image size in bytes (4 bytes per pixel RGBA)
+	colorMetrics.ImageSize = int64(width * height * 4)
+	colorMetrics.LUTSize = lut.Size()
+
+	return colorMetrics, ndviColorImg
+}
+
+// MaxColorError returns the largest absolute channel difference between two images of the same size
+func MaxColorError(a, b *image.RGBA, numThreads int) int {
+	pixCount := min(len(a.Pix), len(b.Pix))
+
+	// Compare in parallel
+	numWorkers := numThreads
+	chunkSize := (pixCount + numWorkers - 1) / numWorkers
+	maxErrors := make([]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localMax := 0
+			for i := start; i < end; i++ {
+				diff := int(a.Pix[i]) - int(b.Pix[i])
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > localMax {
+					localMax = diff
+				}
+			}
+			maxErrors[worker] = localMax
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	maxError := 0
+	for _, e := range maxErrors {
+		if e > maxError {
+			maxError = e
+		}
+	}
+	return maxError
+}
+
+// ColorizeClasses converts NDVI values to a color image of discrete classes
+// Pixels whose valid flag is false take the scheme's no-data color; a nil valid slice
+// treats every pixel as valid. The returned class raster holds the class index plus
+// one, with 0 for no data. pixelArea is the ground area of a pixel in square meters
+func ColorizeClasses(ndviData []float64, valid []bool, width, height int, scheme *config.ClassScheme, pixelArea float64, numThreads int) (*metrics.ColorMetrics, *image.RGBA, *image.Gray) {
+	colorMetrics := &metrics.ColorMetrics{}
+
+	// Create output RGBA image and class raster
+	classColorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	classImg := image.NewGray(image.Rect(0, 0, width, height))
+	colorPix := classColorImg.Pix
+	classPix := classImg.Pix
+
+	numClasses := len(scheme.Classes)
+	pixelCount := width * height
+
+	// Process classes in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	classCounts := make([][]int, numWorkers)
+	noDataCounts := make([]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localCounts := make([]int, numClasses)
+			localNoData := 0
+
+			for i := start; i < end; i++ {
+				idx := i * 4
+				if valid != nil && !valid[i] {
+					colorPix[idx] = scheme.NoData.R
+					colorPix[idx+1] = scheme.NoData.G
+					colorPix[idx+2] = scheme.NoData.B
+					colorPix[idx+3] = scheme.NoData.A
+					localNoData++
+					continue
+				}
+
+				class := scheme.Classify(ndviData[i])
+				rgba := scheme.Classes[class].Color
+				colorPix[idx] = rgba.R
+				colorPix[idx+1] = rgba.G
+				colorPix[idx+2] = rgba.B
+				colorPix[idx+3] = rgba.A
+				classPix[i] = uint8(class + 1)
+				localCounts[class]++
+			}
+
+			classCounts[worker] = localCounts
+			noDataCounts[worker] = localNoData
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results with the legend
+	classMetrics := &metrics.ClassMetrics{
+		Scheme:      scheme.Name,
+		TotalPixels: pixelCount,
+		Classes:     make([]metrics.ClassCount, numClasses),
+	}
+	for i, entry := range scheme.Legend() {
+		classMetrics.Classes[i] = metrics.ClassCount{
+			Label: entry.Label,
+			Min:   entry.Min,
+			Max:   entry.Max,
+			Color: fmt.Sprintf("#%02x%02x%02x", entry.Color.R, entry.Color.G, entry.Color.B),
+		}
+	}
+	for w := 0; w < numWorkers; w++ {
+		classMetrics.NoDataPixels += noDataCounts[w]
+		for class, count := range classCounts[w] {
+			classMetrics.Classes[class].Pixels += count
+		}
+	}
+	for i := range classMetrics.Classes {
+		classMetrics.Classes[i].Area = float64(classMetrics.Classes[i].Pixels) * pixelArea / 10000
+	}
+
+	// Set image size in bytes (4 bytes per pixel RGBA)
+	colorMetrics.ImageSize = int64(width * height * 4)
+	colorMetrics.Classes = classMetrics
+
+	return colorMetrics, classColorImg, classImg
+}
+
+// ColorizePaletted converts NDVI values to a paletted image whose palette is the
+// lookup table, which must have at most 256 entries
+func ColorizePaletted(ndviData []float64, width, height int, lut *config.ColorLUT, numThreads int) (*metrics.ColorMetrics, *image.Paletted, error) {
+	if lut.Size() > 256 {
+		return nil, nil, fmt.Errorf("lookup table of %d entries does not fit an 8-bit palette", lut.Size())
+	}
+
+	colorMetrics := &metrics.ColorMetrics{}
+
+	// Create output paletted image
+	ndviPalettedImg := image.NewPaletted(image.Rect(0, 0, width, height), lut.Palette())
+	indexPix := ndviPalettedImg.Pix
+	lastIdx := lut.Size() - 1
+
+	pixelCount := width * height
+
+	// Process indices in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				k := int((ndviData[i]-lut.Min)*lut.Scale + 0.5)
+				if k < 0 {
+					k = 0
+				} else if k > lastIdx {
+					k = lastIdx
+				}
+				indexPix[i] = uint8(k)
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	// Set image size in bytes (1 byte per pixel index)
+	colorMetrics.ImageSize = int64(width * height)
+	colorMetrics.LUTSize = lut.Size()
+
+	return colorMetrics, ndviPalettedImg, nil
+}
+
+// ClassPaletted wraps a class raster from ColorizeClasses as a paletted image
+// using the scheme's palette, without copying the pixels
+func ClassPaletted(classImg *image.Gray, scheme *config.ClassScheme) *image.Paletted {
+	return &image.Paletted{
+		Pix:     classImg.Pix,
+		Stride:  classImg.Stride,
+		Rect:    classImg.Rect,
+		Palette: scheme.Palette(),
+	}
+}
+
+// MaskInvalid returns a copy of a colorized image with the pixels whose valid flag is
+// false made transparent, or img itself when valid is nil
+func MaskInvalid(img *image.RGBA, valid []bool, numThreads int) *image.RGBA {
+	if valid == nil {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	masked := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Process rows in parallel
+	numWorkers := numThreads
+	chunkSize := (height + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, height)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				src := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+				dst := masked.Pix[y*masked.Stride:]
+				for x := 0; x < width; x++ {
+					if valid[y*width+x] {
+						copy(dst[x*4:x*4+4], src[x*4:x*4+4])
+					}
+				}
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return masked
+}
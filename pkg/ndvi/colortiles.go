@@ -0,0 +1,62 @@
+package ndvi
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync/atomic"
+	"time"
+)
+
+// Colorer maps NDVI values to colors, as colormaps and lookup tables do
+type Colorer interface {
+	Color(value float64) color.RGBA
+}
+
+// ColorTiles colorizes NDVI values one tile at a time for tiled encoding, so the
+// colorized map is never held whole
+// It implements jp2.TileSource
+type ColorTiles struct {
+	ndviData      []float64
+	width, height int
+	colors        Colorer
+	colorTime     atomic.Int64 // Nanoseconds spent colorizing tiles
+}
+
+// NewColorTiles returns a tile source colorizing NDVI values with a colormap or lookup
+// table as each tile is requested
+func NewColorTiles(ndviData []float64, width, height int, colors Colorer) *ColorTiles {
+	return &ColorTiles{ndviData: ndviData, width: width, height: height, colors: colors}
+}
+
+// Size returns the width and height of the map
+func (t *ColorTiles) Size() (int, int) {
+	return t.width, t.height
+}
+
+// ColorTime returns the time spent colorizing the tiles read so far
+func (t *ColorTiles) ColorTime() time.Duration {
+	return time.Duration(t.colorTime.Load())
+}
+
+// ReadTile fills dst with the colors of the NDVI values of r row by row
+func (t *ColorTiles) ReadTile(r image.Rectangle, dst []uint8) error {
+	if !r.In(image.Rect(0, 0, t.width, t.height)) {
+		return fmt.Errorf("tile %v outside the %dx%d map", r, t.width, t.height)
+	}
+	startColor := time.Now()
+	defer func() { t.colorTime.Add(int64(time.Since(startColor))) }()
+	i := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := t.ndviData[y*t.width+r.Min.X : y*t.width+r.Max.X]
+		for _, value := range row {
+			rgba := t.colors.Color(value)
+			dst[i] = rgba.R
+			dst[i+1] = rgba.G
+			dst[i+2] = rgba.B
+			dst[i+3] = rgba.A
+			i += 4
+		}
+	}
+	return nil
+}
@@ -0,0 +1,482 @@
+package ndvi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// CompositeMethod selects how several NDVI scenes are merged into one
+type CompositeMethod int
+
+const (
+	CompositeMax    CompositeMethod = iota // Highest valid NDVI per pixel
+	CompositeMedian                        // Median of the valid NDVI values per pixel
+	CompositeLatest                        // Last valid NDVI per pixel in scene order
+)
+
+// String returns the flag name of the method
+func (m CompositeMethod) String() string {
+	switch m {
+	case CompositeMax:
+		return "max"
+	case CompositeMedian:
+		return "median"
+	case CompositeLatest:
+		return "latest"
+	default:
+		return fmt.Sprintf("CompositeMethod(%d)", int(m))
+	}
+}
+
+// ParseCompositeMethod converts a flag value into a CompositeMethod
+func ParseCompositeMethod(name string) (CompositeMethod, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "max":
+		return CompositeMax, nil
+	case "median":
+		return CompositeMedian, nil
+	case "latest":
+		return CompositeLatest, nil
+	default:
+		return 0, fmt.Errorf("unknown composite method: %s", name)
+	}
+}
+
+// ScenePair holds the band files of one acquisition
+type ScenePair struct {
+	NIR, RED string
+	Date     time.Time // Acquisition date, optional for compositing
+}
+
+// Composite is a multi-date NDVI composite
+type Composite struct {
+	Result         // Composited NDVI values and validity
+	Source []int16 // Index of the scene that contributed each pixel, -1 where no scene had data
+}
+
+// DefaultMedianMemory is the default memory budget in bytes of the median stack
+const DefaultMedianMemory = 1 << 30
+
+// Compositor merges NDVI results scene by scene so only one scene is held at a time
+// The median method keeps a float32 copy of every scene until Finish, in memory while
+// the copies fit in its memory budget and in a temporary file once they do not; the
+// file is then reduced in blocks of rows of every scene that fit in the budget
+type Compositor struct {
+	method        CompositeMethod
+	width, height int
+	numThreads    int
+	scenes        int
+	composite     *Composite
+	medianMemory  int64
+	stack         [][]float32 // Per-scene values for the median method, NaN where invalid
+	spill         *os.File    // Per-scene values once the stack exceeds the memory budget
+	plane         []float32   // Values of the scene being spilled
+	buf           []byte      // Encoding buffer of the spill file
+}
+
+// NewCompositor creates a compositor for scenes of the given grid size
+// medianMemory bounds the bytes of scene values the median method holds in memory
+func NewCompositor(method CompositeMethod, width, height int, medianMemory int64, numThreads int) *Compositor {
+	pixelCount := width * height
+	composite := &Composite{
+		Result: Result{
+			Width:  width,
+			Height: height,
+			Values: make([]float64, pixelCount),
+			Valid:  make([]bool, pixelCount),
+		},
+		Source: make([]int16, pixelCount),
+	}
+	for i := range composite.Source {
+		composite.Source[i] = -1
+	}
+
+	return &Compositor{
+		method:       method,
+		width:        width,
+		height:       height,
+		numThreads:   numThreads,
+		composite:    composite,
+		medianMemory: medianMemory,
+	}
+}
+
+// Add merges the next scene into the composite
+func (c *Compositor) Add(scene *Result) error {
+	if scene.Width != c.width || scene.Height != c.height {
+		return &ResultDimensionError{
+			BeforeWidth:  c.width,
+			BeforeHeight: c.height,
+			AfterWidth:   scene.Width,
+			AfterHeight:  scene.Height,
+		}
+	}
+	if c.scenes >= math.MaxInt16 {
+		return fmt.Errorf("too many scenes for composite: %d", c.scenes+1)
+	}
+
+	sceneIndex := int16(c.scenes)
+	c.scenes++
+
+	pixelCount := c.width * c.height
+	values := c.composite.Values
+	valid := c.composite.Valid
+	source := c.composite.Source
+
+	var stackValues []float32
+	if c.method == CompositeMedian {
+		var err error
+		if stackValues, err = c.stackPlane(); err != nil {
+			return err
+		}
+	}
+
+	// Setup parallel processing
+	numWorkers := c.numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				switch c.method {
+				case CompositeMax:
+					if scene.Valid[i] && (!valid[i] || scene.Values[i] > values[i]) {
+						values[i] = scene.Values[i]
+						valid[i] = true
+						source[i] = sceneIndex
+					}
+				case CompositeLatest:
+					if scene.Valid[i] {
+						values[i] = scene.Values[i]
+						valid[i] = true
+						source[i] = sceneIndex
+					}
+				case CompositeMedian:
+					if scene.Valid[i] {
+						stackValues[i] = float32(scene.Values[i])
+					} else {
+						stackValues[i] = float32(math.NaN())
+					}
+				}
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	if c.spill != nil {
+		return c.writeSpill(stackValues)
+	}
+	return nil
+}
+
+// stackPlane returns the plane where the median method stores the next scene, moving
+// the stack to the spill file when another scene would exceed the memory budget
+func (c *Compositor) stackPlane() ([]float32, error) {
+	pixelCount := c.width * c.height
+	if c.spill == nil && int64(len(c.stack)+1)*int64(pixelCount)*4 <= c.medianMemory {
+		plane := make([]float32, pixelCount)
+		c.stack = append(c.stack, plane)
+		return plane, nil
+	}
+
+	if c.spill == nil {
+		spill, err := os.CreateTemp("", "ndvi-median-*.bin")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create median stack file: %v", err)
+		}
+		c.spill = spill
+		for _, plane := range c.stack {
+			if err := c.writeSpill(plane); err != nil {
+				return nil, err
+			}
+		}
+		if len(c.stack) > 0 {
+			c.plane = c.stack[0]
+		} else {
+			c.plane = make([]float32, pixelCount)
+		}
+		c.stack = nil
+	}
+	return c.plane, nil
+}
+
+// writeSpill appends the values of a scene to the spill file
+func (c *Compositor) writeSpill(values []float32) error {
+	if len(c.buf) < len(values)*4 {
+		c.buf = make([]byte, len(values)*4)
+	}
+	buf := c.buf[:len(values)*4]
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+	}
+	if _, err := c.spill.Write(buf); err != nil {
+		return fmt.Errorf("failed to write median stack file: %v", err)
+	}
+	return nil
+}
+
+// Close removes the spill file of the median stack, if any
+func (c *Compositor) Close() {
+	if c.spill != nil {
+		c.spill.Close()
+		os.Remove(c.spill.Name())
+		c.spill = nil
+	}
+	c.stack = nil
+	c.plane = nil
+	c.buf = nil
+}
+
+// Finish completes the composite and returns its metrics
+func (c *Compositor) Finish() (*metrics.CompositeMetrics, *Composite, error) {
+	if c.method == CompositeMedian {
+		defer c.Close()
+		if c.spill != nil {
+			if err := c.reduceSpilledMedian(); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			c.reduceMedian(c.stack, 0, c.width*c.height)
+		}
+	}
+
+	pixelCount := c.width * c.height
+	composite := c.composite
+
+	// Setup parallel processing
+	numWorkers := c.numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	minVals := make([]float64, numWorkers)
+	maxVals := make([]float64, numWorkers)
+	sums := make([]float64, numWorkers)
+	contributions := make([][]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localMin := math.MaxFloat64
+			localMax := -math.MaxFloat64
+			localSum := 0.0
+			localContributions := make([]int, c.scenes)
+
+			for i := start; i < end; i++ {
+				if !composite.Valid[i] {
+					continue
+				}
+				value := composite.Values[i]
+				if value < localMin {
+					localMin = value
+				}
+				if value > localMax {
+					localMax = value
+				}
+				localSum += value
+				localContributions[composite.Source[i]]++
+			}
+
+			minVals[worker] = localMin
+			maxVals[worker] = localMax
+			sums[worker] = localSum
+			contributions[worker] = localContributions
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results
+	compositeMetrics := &metrics.CompositeMetrics{
+		Method:             c.method.String(),
+		Scenes:             c.scenes,
+		TotalPixels:        pixelCount,
+		SceneContributions: make([]int, c.scenes),
+		Min:                math.MaxFloat64,
+		Max:                -math.MaxFloat64,
+	}
+	totalSum := 0.0
+	validPixels := 0
+	for i := 0; i < numWorkers; i++ {
+		compositeMetrics.Min = math.Min(compositeMetrics.Min, minVals[i])
+		compositeMetrics.Max = math.Max(compositeMetrics.Max, maxVals[i])
+		totalSum += sums[i]
+		for scene, count := range contributions[i] {
+			compositeMetrics.SceneContributions[scene] += count
+			validPixels += count
+		}
+	}
+
+	compositeMetrics.NoDataPixels = pixelCount - validPixels
+	if validPixels > 0 {
+		compositeMetrics.Average = totalSum / float64(validPixels)
+	} else {
+		compositeMetrics.Min = 0
+		compositeMetrics.Max = 0
+	}
+
+	return compositeMetrics, composite, nil
+}
+
+// reduceSpilledMedian computes the per-pixel median of the scenes in the spill file,
+// reading the same block of rows of every scene at a time
+func (c *Compositor) reduceSpilledMedian() error {
+	pixelCount := c.width * c.height
+	rowBytes := int64(c.scenes) * int64(c.width) * 4
+	blockRows := c.height
+	if rows := c.medianMemory / rowBytes; rows < int64(c.height) {
+		blockRows = max(int(rows), 1)
+	}
+
+	c.plane, c.buf = nil, nil
+	block := make([][]float32, c.scenes)
+	for scene := range block {
+		block[scene] = make([]float32, blockRows*c.width)
+	}
+	buf := make([]byte, blockRows*c.width*4)
+
+	for y := 0; y < c.height; y += blockRows {
+		count := min(blockRows, c.height-y) * c.width
+		for scene, values := range block {
+			offset := (int64(scene)*int64(pixelCount) + int64(y*c.width)) * 4
+			if _, err := c.spill.ReadAt(buf[:count*4], offset); err != nil {
+				return fmt.Errorf("failed to read median stack file: %v", err)
+			}
+			for i := 0; i < count; i++ {
+				values[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+			}
+		}
+		c.reduceMedian(block, y*c.width, count)
+	}
+	return nil
+}
+
+// reduceMedian computes the per-pixel median of pixelCount pixels from offset, whose values
+// in each scene start at the beginning of the stack planes
+// For an even number of values the mean of the two middle values is used and
+// the lower one is recorded as the source scene
+func (c *Compositor) reduceMedian(stack [][]float32, offset, pixelCount int) {
+	composite := c.composite
+
+	// Setup parallel processing
+	numWorkers := c.numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			samples := make([]medianSample, 0, c.scenes)
+
+			for i := start; i < end; i++ {
+				samples = samples[:0]
+				for scene, values := range stack {
+					if v := values[i]; v == v { // Skip NaN
+						samples = append(samples, medianSample{value: v, scene: int16(scene)})
+					}
+				}
+				if len(samples) == 0 {
+					continue
+				}
+
+				sort.Slice(samples, func(a, b int) bool { return samples[a].value < samples[b].value })
+				mid := (len(samples) - 1) / 2
+				value := float64(samples[mid].value)
+				if len(samples)%2 == 0 {
+					value = (value + float64(samples[mid+1].value)) / 2
+				}
+
+				composite.Values[offset+i] = value
+				composite.Valid[offset+i] = true
+				composite.Source[offset+i] = samples[mid].scene
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+}
+
+// medianSample is a valid value of one scene at a pixel
+type medianSample struct {
+	value float32
+	scene int16
+}
+
+// CompositeScenes reads the scenes one at a time and composites their NDVI
+// medianMemory bounds the scene values the median method holds in memory
+// Returns composite metrics, the composite, and any error
+func CompositeScenes(reader jp2.Reader, scenes []ScenePair, method CompositeMethod, medianMemory int64, numThreads int) (*metrics.CompositeMetrics, *Composite, error) {
+	if len(scenes) == 0 {
+		return nil, nil, fmt.Errorf("no scenes to composite")
+	}
+
+	var compositor *Compositor
+	defer func() {
+		if compositor != nil {
+			compositor.Close()
+		}
+	}()
+	for i, scene := range scenes {
+		result, err := readSceneNDVI(reader, scene, numThreads)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scene %d: %v", i, err)
+		}
+
+		if compositor == nil {
+			compositor = NewCompositor(method, result.Width, result.Height, medianMemory, numThreads)
+		}
+		if err := compositor.Add(result); err != nil {
+			return nil, nil, fmt.Errorf("scene %d: %v", i, err)
+		}
+	}
+
+	return compositor.Finish()
+}
+
+// readSceneNDVI decodes both bands of a scene and returns its NDVI result
+// The decoded bands are released before returning
+func readSceneNDVI(reader jp2.Reader, scene ScenePair, numThreads int) (*Result, error) {
+	nirBand, err := reader.Read(scene.NIR, numThreads)
+	if err != nil {
+		return nil, fmt.Errorf("reading NIR band %s: %v", scene.NIR, err)
+	}
+	defer nirBand.Free()
+
+	redBand, err := reader.Read(scene.RED, numThreads)
+	if err != nil {
+		return nil, fmt.Errorf("reading RED band %s: %v", scene.RED, err)
+	}
+	defer redBand.Free()
+
+	_, result, err := CalculateResult(nirBand, redBand, numThreads)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
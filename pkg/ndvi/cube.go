@@ -0,0 +1,457 @@
+package ndvi
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Cube is a time-indexed stack of NDVI rasters on the same grid
+// Layers are kept sorted by date; values are stored as float32 to halve memory
+type Cube struct {
+	Width, Height int
+	Dates         []time.Time
+	Values        [][]float32 // One layer per date, one value per pixel
+	Valid         [][]bool    // One layer per date, false where the observation is masked
+}
+
+// Series is the time series of a single pixel
+type Series struct {
+	X, Y   int
+	Dates  []time.Time
+	Values []float64
+	Valid  []bool
+}
+
+// NewCube creates an empty cube for the given grid size
+func NewCube(width, height int) *Cube {
+	return &Cube{Width: width, Height: height}
+}
+
+// Len returns the number of dates in the cube
+func (c *Cube) Len() int {
+	return len(c.Dates)
+}
+
+// Add inserts an NDVI result at its date, keeping the layers in date order
+func (c *Cube) Add(date time.Time, result *Result) error {
+	if result.Width != c.Width || result.Height != c.Height {
+		return &ResultDimensionError{
+			BeforeWidth:  c.Width,
+			BeforeHeight: c.Height,
+			AfterWidth:   result.Width,
+			AfterHeight:  result.Height,
+		}
+	}
+
+	values := make([]float32, len(result.Values))
+	for i, v := range result.Values {
+		values[i] = float32(v)
+	}
+	valid := make([]bool, len(result.Valid))
+	copy(valid, result.Valid)
+
+	pos := sort.Search(len(c.Dates), func(i int) bool { return c.Dates[i].After(date) })
+	c.Dates = append(c.Dates[:pos], append([]time.Time{date}, c.Dates[pos:]...)...)
+	c.Values = append(c.Values[:pos], append([][]float32{values}, c.Values[pos:]...)...)
+	c.Valid = append(c.Valid[:pos], append([][]bool{valid}, c.Valid[pos:]...)...)
+
+	return nil
+}
+
+// Series returns the time series of the pixel at (x, y)
+func (c *Cube) Series(x, y int) (*Series, error) {
+	if x < 0 || y < 0 || x >= c.Width || y >= c.Height {
+		return nil, fmt.Errorf("pixel (%d, %d) outside cube of %dx%d", x, y, c.Width, c.Height)
+	}
+
+	i := y*c.Width + x
+	series := &Series{
+		X:      x,
+		Y:      y,
+		Dates:  append([]time.Time(nil), c.Dates...),
+		Values: make([]float64, c.Len()),
+		Valid:  make([]bool, c.Len()),
+	}
+	for t := range c.Dates {
+		series.Values[t] = float64(c.Values[t][i])
+		series.Valid[t] = c.Valid[t][i]
+	}
+
+	return series, nil
+}
+
+// Layer returns the NDVI result stored at date index t
+func (c *Cube) Layer(t int) *Result {
+	values := make([]float64, len(c.Values[t]))
+	for i, v := range c.Values[t] {
+		values[i] = float64(v)
+	}
+
+	valid := make([]bool, len(c.Valid[t]))
+	copy(valid, c.Valid[t])
+
+	return &Result{
+		Width:  c.Width,
+		Height: c.Height,
+		Values: values,
+		Valid:  valid,
+	}
+}
+
+// FillGaps replaces masked observations by linear interpolation in time between
+// the nearest valid observations; gaps at the start or end of a series are left masked
+// Returns the number of filled observations
+func (c *Cube) FillGaps(numThreads int) int {
+	days := c.dayOffsets()
+
+	return c.forEachPixel(numThreads, func(values []float64, valid []bool, _ []float64) int {
+		filled := 0
+		prev := -1
+		for t := range values {
+			if !valid[t] {
+				continue
+			}
+			if prev >= 0 && t-prev > 1 {
+				span := days[t] - days[prev]
+				for g := prev + 1; g < t; g++ {
+					w := (days[g] - days[prev]) / span
+					values[g] = values[prev] + w*(values[t]-values[prev])
+					valid[g] = true
+					filled++
+				}
+			}
+			prev = t
+		}
+		return filled
+	})
+}
+
+// SmoothMovingAverage replaces each valid observation by the mean of the valid
+// observations in a centered window of the given odd size
+func (c *Cube) SmoothMovingAverage(window, numThreads int) error {
+	if window < 1 || window%2 == 0 {
+		return fmt.Errorf("moving average window must be a positive odd number, got %d", window)
+	}
+	half := window / 2
+
+	c.forEachPixel(numThreads, func(values []float64, valid []bool, smoothed []float64) int {
+		for t := range values {
+			if !valid[t] {
+				continue
+			}
+			sum, count := 0.0, 0
+			for k := max(0, t-half); k <= min(len(values)-1, t+half); k++ {
+				if valid[k] {
+					sum += values[k]
+					count++
+				}
+			}
+			smoothed[t] = sum / float64(count)
+		}
+		for t := range values {
+			if valid[t] {
+				values[t] = smoothed[t]
+			}
+		}
+		return 0
+	})
+
+	return nil
+}
+
+// SmoothSavitzkyGolay fits a polynomial of the given order over a sliding window
+// of the given odd size and replaces each observation by the fitted value
+// The filter assumes evenly spaced dates; windows containing masked observations
+// are left unchanged, so FillGaps should be run first
+func (c *Cube) SmoothSavitzkyGolay(window, order, numThreads int) error {
+	if window < 3 || window%2 == 0 {
+		return fmt.Errorf("Savitzky-Golay window must be an odd number of at least 3, got %d", window)
+	}
+	if order < 0 || order >= window {
+		return fmt.Errorf("Savitzky-Golay order must be between 0 and %d, got %d", window-1, order)
+	}
+	if c.Len() < window {
+		return fmt.Errorf("Savitzky-Golay window of %d is longer than the %d dates in the cube", window, c.Len())
+	}
+
+	weights, err := savitzkyGolayWeights(window, order)
+	if err != nil {
+		return err
+	}
+	half := window / 2
+
+	c.forEachPixel(numThreads, func(values []float64, valid []bool, smoothed []float64) int {
+		for t := range values {
+			// Near the ends the window is clamped and evaluated off-center
+			start := min(max(0, t-half), len(values)-window)
+			offset := t - start
+
+			complete := true
+			fitted := 0.0
+			for k := 0; k < window; k++ {
+				if !valid[start+k] {
+					complete = false
+					break
+				}
+				fitted += weights[offset][k] * values[start+k]
+			}
+			if complete {
+				smoothed[t] = fitted
+			} else {
+				smoothed[t] = values[t]
+			}
+		}
+		copy(values, smoothed)
+		return 0
+	})
+
+	return nil
+}
+
+// savitzkyGolayWeights returns, for every position in the window, the weights that
+// evaluate the least squares polynomial fit at that position
+func savitzkyGolayWeights(window, order int) ([][]float64, error) {
+	terms := order + 1
+	half := window / 2
+
+	// Normal equations (AᵀA) of the Vandermonde matrix A[i][k] = (i-half)^k
+	ata := make([][]float64, terms)
+	for r := range ata {
+		ata[r] = make([]float64, terms)
+		for col := range ata[r] {
+			for i := 0; i < window; i++ {
+				ata[r][col] += math.Pow(float64(i-half), float64(r+col))
+			}
+		}
+	}
+
+	inv, err := invertMatrix(ata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Projection (AᵀA)⁻¹Aᵀ gives the polynomial coefficients for a window
+	proj := make([][]float64, terms)
+	for r := range proj {
+		proj[r] = make([]float64, window)
+		for i := 0; i < window; i++ {
+			for k := 0; k < terms; k++ {
+				proj[r][i] += inv[r][k] * math.Pow(float64(i-half), float64(k))
+			}
+		}
+	}
+
+	weights := make([][]float64, window)
+	for pos := range weights {
+		weights[pos] = make([]float64, window)
+		x := float64(pos - half)
+		for i := 0; i < window; i++ {
+			for k := 0; k < terms; k++ {
+				weights[pos][i] += math.Pow(x, float64(k)) * proj[k][i]
+			}
+		}
+	}
+
+	return weights, nil
+}
+
+// invertMatrix inverts a square matrix with Gauss-Jordan elimination
+func invertMatrix(m [][]float64) ([][]float64, error) {
+	n := len(m)
+	a := make([][]float64, n)
+	for r := range a {
+		a[r] = make([]float64, 2*n)
+		copy(a[r], m[r])
+		a[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		scale := a[col][col]
+		for k := range a[col] {
+			a[col][k] /= scale
+		}
+		for r := 0; r < n; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			factor := a[r][col]
+			for k := range a[r] {
+				a[r][k] -= factor * a[col][k]
+			}
+		}
+	}
+
+	inv := make([][]float64, n)
+	for r := range inv {
+		inv[r] = a[r][n:]
+	}
+	return inv, nil
+}
+
+// dayOffsets returns the days elapsed since the first date for every date
+func (c *Cube) dayOffsets() []float64 {
+	days := make([]float64, c.Len())
+	for t, date := range c.Dates {
+		days[t] = date.Sub(c.Dates[0]).Hours() / 24
+	}
+	return days
+}
+
+// forEachPixel runs fn on the series of every pixel in parallel and writes the
+// series back into the cube; fn also gets a per-worker scratch slice of the same length
+// Returns the sum of the values returned by fn
+func (c *Cube) forEachPixel(numThreads int, fn func(values []float64, valid []bool, scratch []float64) int) int {
+	pixelCount := c.Width * c.Height
+	dates := c.Len()
+
+	// Setup parallel processing
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	counts := make([]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			values := make([]float64, dates)
+			valid := make([]bool, dates)
+			scratch := make([]float64, dates)
+			localCount := 0
+
+			for i := start; i < end; i++ {
+				for t := 0; t < dates; t++ {
+					values[t] = float64(c.Values[t][i])
+					valid[t] = c.Valid[t][i]
+				}
+
+				localCount += fn(values, valid, scratch)
+
+				for t := 0; t < dates; t++ {
+					c.Values[t][i] = float32(values[t])
+					c.Valid[t][i] = valid[t]
+				}
+			}
+
+			counts[worker] = localCount
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// Metrics summarizes the observations held by the cube
+func (c *Cube) Metrics() *metrics.CubeMetrics {
+	cubeMetrics := &metrics.CubeMetrics{
+		Dates:       c.Len(),
+		TotalPixels: c.Width * c.Height,
+	}
+	for _, layer := range c.Valid {
+		for _, valid := range layer {
+			if valid {
+				cubeMetrics.ValidObservations++
+			} else {
+				cubeMetrics.MaskedObservations++
+			}
+		}
+	}
+	return cubeMetrics
+}
+
+// WriteSeriesCSV exports the time series of the given pixels as CSV rows
+// with columns x, y, date, ndvi and valid
+func (c *Cube) WriteSeriesCSV(w io.Writer, pixels []image.Point) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "x,y,date,ndvi,valid")
+
+	for _, p := range pixels {
+		series, err := c.Series(p.X, p.Y)
+		if err != nil {
+			return err
+		}
+		for t, date := range series.Dates {
+			fmt.Fprintf(bw, "%d,%d,%s,%.6f,%t\n", p.X, p.Y, date.Format("2006-01-02"), series.Values[t], series.Valid[t])
+		}
+	}
+
+	return bw.Flush()
+}
+
+// sceneDatePattern matches the acquisition date in product file names (e.g. T30SUH_20230615T105629_B08.jp2)
+var sceneDatePattern = regexp.MustCompile(`(\d{8})(T\d{6})?`)
+
+// ParseSceneDate extracts the acquisition date from a band file name
+func ParseSceneDate(path string) (time.Time, error) {
+	for _, match := range sceneDatePattern.FindAllStringSubmatch(filepath.Base(path), -1) {
+		if date, err := time.Parse("20060102", match[1]); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no date found in file name: %s", path)
+}
+
+// BuildCube reads the scenes one at a time and stacks their NDVI by date
+// Scenes without a Date take the date parsed from their NIR file name
+func BuildCube(reader jp2.Reader, scenes []ScenePair, numThreads int) (*Cube, error) {
+	if len(scenes) == 0 {
+		return nil, fmt.Errorf("no scenes to build the cube")
+	}
+
+	var cube *Cube
+	for i, scene := range scenes {
+		date := scene.Date
+		if date.IsZero() {
+			parsed, err := ParseSceneDate(scene.NIR)
+			if err != nil {
+				return nil, fmt.Errorf("scene %d: %v", i, err)
+			}
+			date = parsed
+		}
+
+		result, err := readSceneNDVI(reader, scene, numThreads)
+		if err != nil {
+			return nil, fmt.Errorf("scene %d: %v", i, err)
+		}
+
+		if cube == nil {
+			cube = NewCube(result.Width, result.Height)
+		}
+		if err := cube.Add(date, result); err != nil {
+			return nil, fmt.Errorf("scene %d: %v", i, err)
+		}
+	}
+
+	return cube, nil
+}
@@ -0,0 +1,412 @@
+package ndvi
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// PhenologyOptions configures the threshold-of-amplitude season extraction
+type PhenologyOptions struct {
+	SOSThreshold float64 // Fraction of the left amplitude that marks the start of season (e.g. 0.2)
+	EOSThreshold float64 // Fraction of the right amplitude that marks the end of season (e.g. 0.2)
+	MinAmplitude float64 // Seasons with a smaller NDVI amplitude are discarded
+}
+
+// DefaultPhenologyOptions returns 20% amplitude thresholds and a minimum amplitude of 0.1
+func DefaultPhenologyOptions() PhenologyOptions {
+	return PhenologyOptions{
+		SOSThreshold: 0.2,
+		EOSThreshold: 0.2,
+		MinAmplitude: 0.1,
+	}
+}
+
+// Phenology holds per-pixel season metrics extracted from a data cube
+// Dates are expressed as day of year of the first cube date's year, so seasons
+// that cross into the next year have values above 365
+type Phenology struct {
+	Width, Height int
+	SOS           []float64 // Start of season
+	EOS           []float64 // End of season
+	POS           []float64 // Peak of season
+	Length        []float64 // Season length in days
+	Peak          []float64 // NDVI at the peak of season
+	Integral      []float64 // Area under the NDVI curve between SOS and EOS, in NDVI·days
+	Valid         []bool    // false where no season could be extracted
+}
+
+// PhenologyMetric selects one of the phenology layers
+type PhenologyMetric int
+
+const (
+	PhenologySOS PhenologyMetric = iota
+	PhenologyEOS
+	PhenologyPOS
+	PhenologyLength
+	PhenologyPeak
+	PhenologyIntegral
+)
+
+// phenologyMetricNames are the flag names of the phenology layers
+var phenologyMetricNames = []string{"sos", "eos", "pos", "length", "peak", "integral"}
+
+// String returns the flag name of the layer
+func (m PhenologyMetric) String() string {
+	if int(m) < len(phenologyMetricNames) {
+		return phenologyMetricNames[m]
+	}
+	return fmt.Sprintf("PhenologyMetric(%d)", int(m))
+}
+
+// ParsePhenologyMetric converts a flag value into a PhenologyMetric
+func ParsePhenologyMetric(name string) (PhenologyMetric, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range phenologyMetricNames {
+		if n == name {
+			return PhenologyMetric(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown phenology layer: %s", name)
+}
+
+// Layer returns the selected phenology layer as a Result
+func (p *Phenology) Layer(metric PhenologyMetric) *Result {
+	var values []float64
+	switch metric {
+	case PhenologySOS:
+		values = p.SOS
+	case PhenologyEOS:
+		values = p.EOS
+	case PhenologyPOS:
+		values = p.POS
+	case PhenologyLength:
+		values = p.Length
+	case PhenologyPeak:
+		values = p.Peak
+	case PhenologyIntegral:
+		values = p.Integral
+	}
+
+	return &Result{
+		Width:  p.Width,
+		Height: p.Height,
+		Values: values,
+		Valid:  p.Valid,
+	}
+}
+
+// ExtractPhenology extracts season metrics from every pixel series of the cube
+// Returns phenology metrics, the phenology layers, and any error
+func ExtractPhenology(cube *Cube, opts PhenologyOptions, numThreads int) (*metrics.PhenologyMetrics, *Phenology, error) {
+	if cube.Len() < 3 {
+		return nil, nil, fmt.Errorf("phenology needs at least 3 dates, cube has %d", cube.Len())
+	}
+	if opts.SOSThreshold <= 0 || opts.SOSThreshold >= 1 || opts.EOSThreshold <= 0 || opts.EOSThreshold >= 1 {
+		return nil, nil, fmt.Errorf("amplitude thresholds must be between 0 and 1")
+	}
+
+	pixelCount := cube.Width * cube.Height
+	dates := cube.Len()
+	firstDay := float64(cube.Dates[0].YearDay())
+	days := cube.dayOffsets()
+
+	pheno := &Phenology{
+		Width:    cube.Width,
+		Height:   cube.Height,
+		SOS:      make([]float64, pixelCount),
+		EOS:      make([]float64, pixelCount),
+		POS:      make([]float64, pixelCount),
+		Length:   make([]float64, pixelCount),
+		Peak:     make([]float64, pixelCount),
+		Integral: make([]float64, pixelCount),
+		Valid:    make([]bool, pixelCount),
+	}
+
+	// Setup parallel processing
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	seasonCounts := make([]int, numWorkers)
+	sums := make([][6]float64, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			times := make([]float64, 0, dates)
+			values := make([]float64, 0, dates)
+			localSeasons := 0
+			var localSums [6]float64
+
+			for i := start; i < end; i++ {
+				// Gather the valid observations of the pixel
+				times = times[:0]
+				values = values[:0]
+				for t := 0; t < dates; t++ {
+					if cube.Valid[t][i] {
+						times = append(times, days[t])
+						values = append(values, float64(cube.Values[t][i]))
+					}
+				}
+
+				season, ok := extractSeason(times, values, opts)
+				if !ok {
+					continue
+				}
+
+				pheno.SOS[i] = firstDay + season.sos
+				pheno.EOS[i] = firstDay + season.eos
+				pheno.POS[i] = firstDay + season.pos
+				pheno.Length[i] = season.eos - season.sos
+				pheno.Peak[i] = season.peak
+				pheno.Integral[i] = season.integral
+				pheno.Valid[i] = true
+
+				localSeasons++
+				localSums[PhenologySOS] += pheno.SOS[i]
+				localSums[PhenologyEOS] += pheno.EOS[i]
+				localSums[PhenologyPOS] += pheno.POS[i]
+				localSums[PhenologyLength] += pheno.Length[i]
+				localSums[PhenologyPeak] += pheno.Peak[i]
+				localSums[PhenologyIntegral] += pheno.Integral[i]
+			}
+
+			seasonCounts[worker] = localSeasons
+			sums[worker] = localSums
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results
+	phenoMetrics := &metrics.PhenologyMetrics{
+		TotalPixels: pixelCount,
+	}
+	var totals [6]float64
+	for i := 0; i < numWorkers; i++ {
+		phenoMetrics.SeasonPixels += seasonCounts[i]
+		for k := range totals {
+			totals[k] += sums[i][k]
+		}
+	}
+	if phenoMetrics.SeasonPixels > 0 {
+		n := float64(phenoMetrics.SeasonPixels)
+		phenoMetrics.MeanSOS = totals[PhenologySOS] / n
+		phenoMetrics.MeanEOS = totals[PhenologyEOS] / n
+		phenoMetrics.MeanPOS = totals[PhenologyPOS] / n
+		phenoMetrics.MeanLength = totals[PhenologyLength] / n
+		phenoMetrics.MeanPeak = totals[PhenologyPeak] / n
+		phenoMetrics.MeanIntegral = totals[PhenologyIntegral] / n
+	}
+
+	return phenoMetrics, pheno, nil
+}
+
+// season holds the metrics of one pixel, with dates in days since the first cube date
+type season struct {
+	sos, eos, pos float64
+	peak          float64
+	integral      float64
+}
+
+// extractSeason finds the main season of a series with the threshold-of-amplitude method
+func extractSeason(times, values []float64, opts PhenologyOptions) (season, bool) {
+	if len(values) < 3 {
+		return season{}, false
+	}
+
+	// Peak of season and the minima on each side of it
+	peakIdx := 0
+	for t, v := range values {
+		if v > values[peakIdx] {
+			peakIdx = t
+		}
+	}
+	if peakIdx == 0 || peakIdx == len(values)-1 {
+		return season{}, false // The season is not fully observed
+	}
+
+	leftMin, rightMin := values[peakIdx], values[peakIdx]
+	for t := 0; t < peakIdx; t++ {
+		leftMin = math.Min(leftMin, values[t])
+	}
+	for t := peakIdx + 1; t < len(values); t++ {
+		rightMin = math.Min(rightMin, values[t])
+	}
+
+	peak := values[peakIdx]
+	if peak-leftMin < opts.MinAmplitude || peak-rightMin < opts.MinAmplitude {
+		return season{}, false
+	}
+
+	// Walk away from the peak until the series drops below each threshold
+	sosLevel := leftMin + opts.SOSThreshold*(peak-leftMin)
+	eosLevel := rightMin + opts.EOSThreshold*(peak-rightMin)
+
+	sos := times[0]
+	for t := peakIdx; t > 0; t-- {
+		if values[t-1] <= sosLevel {
+			sos = crossing(times[t-1], values[t-1], times[t], values[t], sosLevel)
+			break
+		}
+	}
+	eos := times[len(times)-1]
+	for t := peakIdx; t < len(values)-1; t++ {
+		if values[t+1] <= eosLevel {
+			eos = crossing(times[t], values[t], times[t+1], values[t+1], eosLevel)
+			break
+		}
+	}
+
+	return season{
+		sos:      sos,
+		eos:      eos,
+		pos:      times[peakIdx],
+		peak:     peak,
+		integral: integrate(times, values, sos, eos),
+	}, true
+}
+
+// crossing returns the time at which the segment between two observations reaches level
+func crossing(t0, v0, t1, v1, level float64) float64 {
+	if v1 == v0 {
+		return t0
+	}
+	return t0 + (level-v0)/(v1-v0)*(t1-t0)
+}
+
+// integrate computes the trapezoidal area under the series between from and to
+func integrate(times, values []float64, from, to float64) float64 {
+	area := 0.0
+	for t := 0; t < len(times)-1; t++ {
+		t0, t1 := math.Max(times[t], from), math.Min(times[t+1], to)
+		if t1 <= t0 {
+			continue
+		}
+		v0 := interpolateAt(times[t], values[t], times[t+1], values[t+1], t0)
+		v1 := interpolateAt(times[t], values[t], times[t+1], values[t+1], t1)
+		area += (v0 + v1) / 2 * (t1 - t0)
+	}
+	return area
+}
+
+// interpolateAt returns the value of the segment between two observations at time x
+func interpolateAt(t0, v0, t1, v1, x float64) float64 {
+	if t1 == t0 {
+		return v0
+	}
+	return v0 + (x-t0)/(t1-t0)*(v1-v0)
+}
+
+// ValidRange returns the minimum and maximum of the valid values of a result
+func ValidRange(result *Result) (float64, float64) {
+	lo, hi := math.MaxFloat64, -math.MaxFloat64
+	for i, v := range result.Values {
+		if result.Valid[i] {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+	}
+	if lo > hi {
+		return 0, 0
+	}
+	return lo, hi
+}
+
+// SummarizeFields averages the phenology metrics over each labelled field
+// labels holds one field ID per pixel, with 0 for pixels outside any field
+func SummarizeFields(pheno *Phenology, labels []int32) ([]metrics.FieldPhenology, error) {
+	if len(labels) != pheno.Width*pheno.Height {
+		return nil, fmt.Errorf("field labels have %d pixels, phenology has %d", len(labels), pheno.Width*pheno.Height)
+	}
+
+	fields := make(map[int32]*metrics.FieldPhenology)
+	for i, id := range labels {
+		if id == 0 {
+			continue
+		}
+		field, ok := fields[id]
+		if !ok {
+			field = &metrics.FieldPhenology{ID: int(id)}
+			fields[id] = field
+		}
+		field.Pixels++
+		if !pheno.Valid[i] {
+			continue
+		}
+		field.SeasonPixels++
+		field.MeanSOS += pheno.SOS[i]
+		field.MeanEOS += pheno.EOS[i]
+		field.MeanPOS += pheno.POS[i]
+		field.MeanLength += pheno.Length[i]
+		field.MeanPeak += pheno.Peak[i]
+		field.MeanIntegral += pheno.Integral[i]
+	}
+
+	summaries := make([]metrics.FieldPhenology, 0, len(fields))
+	for _, field := range fields {
+		if field.SeasonPixels > 0 {
+			n := float64(field.SeasonPixels)
+			field.MeanSOS /= n
+			field.MeanEOS /= n
+			field.MeanPOS /= n
+			field.MeanLength /= n
+			field.MeanPeak /= n
+			field.MeanIntegral /= n
+		}
+		summaries = append(summaries, *field)
+	}
+	sort.Slice(summaries, func(a, b int) bool { return summaries[a].ID < summaries[b].ID })
+
+	return summaries, nil
+}
+
+// ReadFieldRects reads field rectangles from CSV rows of id,x0,y0,x1,y1 in pixel
+// coordinates and rasterizes them into a label grid; later rows overwrite earlier ones
+func ReadFieldRects(r io.Reader, width, height int) ([]int32, error) {
+	labels := make([]int32, width*height)
+	bounds := image.Rect(0, 0, width, height)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "id") {
+			continue
+		}
+
+		var id int32
+		var x0, y0, x1, y1 int
+		if _, err := fmt.Sscanf(text, "%d,%d,%d,%d,%d", &id, &x0, &y0, &x1, &y1); err != nil {
+			return nil, fmt.Errorf("line %d: invalid field rectangle %q: %v", line, text, err)
+		}
+		if id <= 0 {
+			return nil, fmt.Errorf("line %d: field IDs must be positive", line)
+		}
+
+		rect := image.Rect(x0, y0, x1, y1).Intersect(bounds)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				labels[y*width+x] = id
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
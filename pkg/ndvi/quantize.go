@@ -0,0 +1,166 @@
+package ndvi
+
+import (
+	"math"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+)
+
+// Quantize converts an NDVI result to int16 samples, with the no-data code where
+// the result has no data
+func Quantize(result *Result, q jp2.Quantization, numThreads int) []int16 {
+	pixelCount := result.Width * result.Height
+	samples := make([]int16, pixelCount)
+
+	// Process samples in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if !result.Valid[i] {
+					samples[i] = q.NoData
+					continue
+				}
+				samples[i] = q.Quantize(result.Values[i])
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return samples
+}
+
+// Float32 converts an NDVI result to float32 values, with NaN where the result has no data
+func Float32(result *Result, numThreads int) []float32 {
+	pixelCount := result.Width * result.Height
+	values := make([]float32, pixelCount)
+	nan := float32(math.NaN())
+
+	// Process values in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if !result.Valid[i] {
+					values[i] = nan
+					continue
+				}
+				values[i] = float32(result.Values[i])
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return values
+}
+
+// Float64 returns the NDVI values of a result with NaN where the result has no data
+func Float64(result *Result, numThreads int) []float64 {
+	pixelCount := result.Width * result.Height
+	values := make([]float64, pixelCount)
+	nan := math.NaN()
+
+	// Process values in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if !result.Valid[i] {
+					values[i] = nan
+					continue
+				}
+				values[i] = result.Values[i]
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return values
+}
+
+// QuantizationError returns the largest absolute difference between an NDVI result and
+// its dequantized product, and the number of pixels whose validity differs
+func QuantizationError(result *Result, product *jp2.QuantizedBand, numThreads int) (float64, int, error) {
+	if result.Width != product.Width || result.Height != product.Height {
+		return 0, 0, &ResultDimensionError{
+			BeforeWidth:  result.Width,
+			BeforeHeight: result.Height,
+			AfterWidth:   product.Width,
+			AfterHeight:  product.Height,
+		}
+	}
+
+	pixelCount := result.Width * result.Height
+
+	// Compare in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	maxErrors := make([]float64, numWorkers)
+	mismatches := make([]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localMax := 0.0
+			localMismatches := 0
+			for i := start; i < end; i++ {
+				if result.Valid[i] != product.Valid[i] {
+					localMismatches++
+					continue
+				}
+				if result.Valid[i] {
+					localMax = math.Max(localMax, math.Abs(result.Values[i]-product.Values[i]))
+				}
+			}
+			maxErrors[worker] = localMax
+			mismatches[worker] = localMismatches
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	maxError := 0.0
+	totalMismatches := 0
+	for w := 0; w < numWorkers; w++ {
+		maxError = math.Max(maxError, maxErrors[w])
+		totalMismatches += mismatches[w]
+	}
+	return maxError, totalMismatches, nil
+}
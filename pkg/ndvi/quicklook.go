@@ -0,0 +1,91 @@
+package ndvi
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// span is a source pixel and the fraction of it covered by a quicklook pixel
+type span struct {
+	index  int
+	weight float64
+}
+
+// Quicklook downsamples a colorized image by area averaging so that its largest
+// dimension is at most maxDim; images that already fit are copied
+// Each quicklook pixel averages the source pixels it covers, weighting the
+// partially covered ones on its edges by their covered fraction
+func Quicklook(img *image.RGBA, maxDim, numThreads int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	scale := math.Max(1, float64(max(width, height))/float64(maxDim))
+	outWidth := max(1, int(math.Round(float64(width)/scale)))
+	outHeight := max(1, int(math.Round(float64(height)/scale)))
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+
+	origin := img.PixOffset(bounds.Min.X, bounds.Min.Y)
+	columns := areaSpans(width, outWidth)
+	rows := areaSpans(height, outHeight)
+
+	// Process quicklook rows in parallel
+	numWorkers := numThreads
+	chunkSize := (outHeight + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, outHeight)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				for x := 0; x < outWidth; x++ {
+					// Premultiplied samples average correctly, alpha included
+					var sum [4]float64
+					total := 0.0
+					for _, row := range rows[y] {
+						src := img.Pix[origin+row.index*img.Stride:]
+						for _, col := range columns[x] {
+							weight := row.weight * col.weight
+							p := src[col.index*4 : col.index*4+4]
+							sum[0] += weight * float64(p[0])
+							sum[1] += weight * float64(p[1])
+							sum[2] += weight * float64(p[2])
+							sum[3] += weight * float64(p[3])
+							total += weight
+						}
+					}
+					dst := out.Pix[y*out.Stride+x*4:]
+					for c := 0; c < 4; c++ {
+						dst[c] = uint8(math.Round(sum[c] / total))
+					}
+				}
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return out
+}
+
+// areaSpans returns, for each of outSize pixels spread over size source pixels, the
+// source pixels it covers and their covered fractions
+func areaSpans(size, outSize int) [][]span {
+	spans := make([][]span, outSize)
+	step := float64(size) / float64(outSize)
+	for i := range spans {
+		lo, hi := float64(i)*step, float64(i+1)*step
+		for s := int(lo); s < size && float64(s) < hi; s++ {
+			weight := math.Min(hi, float64(s+1)) - math.Max(lo, float64(s))
+			if weight > 0 {
+				spans[i] = append(spans[i], span{s, weight})
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,289 @@
+package ndvi
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// StretchMethod selects how NDVI values are remapped before color lookup
+type StretchMethod int
+
+const (
+	StretchNone       StretchMethod = iota // Values are colorized as they are
+	StretchMinMax                          // An explicit [min, max] range is stretched over the colormap
+	StretchPercentile                      // The range between two percentiles is stretched over the colormap
+	StretchEqualize                        // Histogram equalization
+)
+
+// stretchBins is the number of histogram bins over the NDVI range [-1, 1]
+const stretchBins = 2000
+
+// String returns the flag name of the method
+func (m StretchMethod) String() string {
+	switch m {
+	case StretchNone:
+		return "none"
+	case StretchMinMax:
+		return "minmax"
+	case StretchPercentile:
+		return "percentile"
+	case StretchEqualize:
+		return "equalize"
+	default:
+		return fmt.Sprintf("StretchMethod(%d)", int(m))
+	}
+}
+
+// ParseStretchMethod converts a flag value into a StretchMethod
+func ParseStretchMethod(name string) (StretchMethod, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return StretchNone, nil
+	case "minmax":
+		return StretchMinMax, nil
+	case "percentile":
+		return StretchPercentile, nil
+	case "equalize":
+		return StretchEqualize, nil
+	default:
+		return 0, fmt.Errorf("unknown stretch method: %s", name)
+	}
+}
+
+// StretchOptions configures a stretch
+type StretchOptions struct {
+	Method         StretchMethod
+	Min, Max       float64 // NDVI range of the minmax method
+	LowPercentile  float64 // Lower percentile of the percentile method (e.g. 2)
+	HighPercentile float64 // Upper percentile of the percentile method (e.g. 98)
+}
+
+// DefaultStretchOptions returns no stretch, with a 2-98% clip for the percentile method
+func DefaultStretchOptions() StretchOptions {
+	return StretchOptions{
+		Method:         StretchNone,
+		Min:            -1,
+		Max:            1,
+		LowPercentile:  2,
+		HighPercentile: 98,
+	}
+}
+
+// Stretch remaps NDVI values onto a colormap domain
+// Linear methods map [Min, Max] onto the domain and clamp values outside it;
+// equalization maps each value to its cumulative frequency
+type Stretch struct {
+	Method   StretchMethod
+	Min, Max float64
+	cdf      []float64 // Cumulative frequency at the upper edge of each bin, for equalization
+}
+
+// ComputeStretch computes the stretch parameters from the valid NDVI values
+// A nil valid slice treats every pixel as valid
+// Returns stretch metrics, the stretch, and any error
+func ComputeStretch(ndviData []float64, valid []bool, opts StretchOptions, numThreads int) (*metrics.StretchMetrics, *Stretch, error) {
+	stretch := &Stretch{Method: opts.Method, Min: -1, Max: 1}
+	stretchMetrics := &metrics.StretchMetrics{Method: opts.Method.String()}
+
+	switch opts.Method {
+	case StretchNone:
+	case StretchMinMax:
+		if opts.Max <= opts.Min {
+			return nil, nil, fmt.Errorf("invalid stretch range: min %v, max %v", opts.Min, opts.Max)
+		}
+		stretch.Min, stretch.Max = opts.Min, opts.Max
+	case StretchPercentile:
+		if opts.LowPercentile < 0 || opts.HighPercentile > 100 || opts.HighPercentile <= opts.LowPercentile {
+			return nil, nil, fmt.Errorf("invalid stretch percentiles: %v, %v", opts.LowPercentile, opts.HighPercentile)
+		}
+		hist, total := histogram(ndviData, valid, numThreads)
+		if total == 0 {
+			return nil, nil, fmt.Errorf("no valid NDVI values to stretch")
+		}
+		stretch.Min = percentile(hist, total, opts.LowPercentile)
+		stretch.Max = percentile(hist, total, opts.HighPercentile)
+		if stretch.Max <= stretch.Min {
+			stretch.Max = stretch.Min + 2.0/stretchBins
+		}
+		stretchMetrics.LowPercentile = opts.LowPercentile
+		stretchMetrics.HighPercentile = opts.HighPercentile
+	case StretchEqualize:
+		hist, total := histogram(ndviData, valid, numThreads)
+		if total == 0 {
+			return nil, nil, fmt.Errorf("no valid NDVI values to stretch")
+		}
+		stretch.cdf = make([]float64, stretchBins)
+		cumulative := 0
+		for b, count := range hist {
+			cumulative += count
+			stretch.cdf[b] = float64(cumulative) / float64(total)
+		}
+		stretch.Min = percentile(hist, total, 0)
+		stretch.Max = percentile(hist, total, 100)
+	default:
+		return nil, nil, fmt.Errorf("unknown stretch method: %v", opts.Method)
+	}
+
+	stretchMetrics.Min = stretch.Min
+	stretchMetrics.Max = stretch.Max
+
+	return stretchMetrics, stretch, nil
+}
+
+// Apply returns the NDVI values remapped onto the colormap domain [lo, hi]
+// Pixels whose valid flag is false keep their value; a nil valid slice treats every pixel as valid
+func (s *Stretch) Apply(ndviData []float64, valid []bool, lo, hi float64, numThreads int) []float64 {
+	pixelCount := len(ndviData)
+	stretched := make([]float64, pixelCount)
+
+	// Process stretch in parallel
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if valid != nil && !valid[i] {
+					stretched[i] = ndviData[i]
+					continue
+				}
+				stretched[i] = lo + s.Fraction(ndviData[i])*(hi-lo)
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return stretched
+}
+
+// Fraction returns the position in [0, 1] of an NDVI value after stretching
+func (s *Stretch) Fraction(value float64) float64 {
+	switch s.Method {
+	case StretchNone:
+		return math.Max(0, math.Min(1, (value+1)/2))
+	case StretchEqualize:
+		b, frac := binOf(value)
+		below := 0.0
+		if b > 0 {
+			below = s.cdf[b-1]
+		}
+		return below + frac*(s.cdf[b]-below)
+	default:
+		return math.Max(0, math.Min(1, (value-s.Min)/(s.Max-s.Min)))
+	}
+}
+
+// Invert returns the NDVI value that is stretched to the given position in [0, 1],
+// used to label colorbars of stretched maps
+func (s *Stretch) Invert(fraction float64) float64 {
+	fraction = math.Max(0, math.Min(1, fraction))
+	switch s.Method {
+	case StretchNone:
+		return fraction*2 - 1
+	case StretchEqualize:
+		// First bin whose cumulative frequency reaches the fraction
+		b := 0
+		for b < stretchBins-1 && s.cdf[b] < fraction {
+			b++
+		}
+		below := 0.0
+		if b > 0 {
+			below = s.cdf[b-1]
+		}
+		frac := 1.0
+		if s.cdf[b] > below {
+			frac = (fraction - below) / (s.cdf[b] - below)
+		}
+		return math.Max(s.Min, math.Min(s.Max, binValue(b, frac)))
+	default:
+		return s.Min + fraction*(s.Max-s.Min)
+	}
+}
+
+// histogram counts the valid NDVI values in stretchBins bins over [-1, 1]
+func histogram(ndviData []float64, valid []bool, numThreads int) ([]int, int) {
+	pixelCount := len(ndviData)
+
+	// Setup parallel processing
+	numWorkers := numThreads
+	chunkSize := (pixelCount + numWorkers - 1) / numWorkers
+	histograms := make([][]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, pixelCount)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			localHist := make([]int, stretchBins)
+			for i := start; i < end; i++ {
+				if valid != nil && !valid[i] {
+					continue
+				}
+				b, _ := binOf(ndviData[i])
+				localHist[b]++
+			}
+			histograms[worker] = localHist
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Combine worker results
+	hist := make([]int, stretchBins)
+	total := 0
+	for _, localHist := range histograms {
+		for b, count := range localHist {
+			hist[b] += count
+			total += count
+		}
+	}
+	return hist, total
+}
+
+// percentile returns the NDVI value below which p percent of the histogram lies,
+// interpolating linearly inside the bin
+func percentile(hist []int, total int, p float64) float64 {
+	target := p / 100 * float64(total)
+	cumulative := 0.0
+	for b, count := range hist {
+		if count == 0 {
+			continue
+		}
+		if cumulative+float64(count) >= target {
+			return binValue(b, (target-cumulative)/float64(count))
+		}
+		cumulative += float64(count)
+	}
+	return 1
+}
+
+// binOf returns the histogram bin of an NDVI value and its position inside the bin
+func binOf(value float64) (int, float64) {
+	pos := (math.Max(-1, math.Min(1, value)) + 1) / 2 * stretchBins
+	b := int(pos)
+	if b >= stretchBins {
+		return stretchBins - 1, 1
+	}
+	return b, pos - float64(b)
+}
+
+// binValue returns the NDVI value at a position inside a histogram bin
+func binValue(b int, frac float64) float64 {
+	return (float64(b)+frac)/stretchBins*2 - 1
+}
@@ -0,0 +1,76 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// File is an output file written to a temporary file next to its final path and
+// renamed over it on Commit, so readers and interrupted runs never see a partial file
+type File struct {
+	*os.File
+	path string
+}
+
+// Create creates the directory of path and a temporary file in it for writing path
+// The file must be finished with Commit or discarded with Abort
+func Create(path string) (*File, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	// Temporary files are private; outputs get the permissions of os.Create
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	return &File{File: tmp, path: path}, nil
+}
+
+// Path returns the final path of the file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Commit flushes the temporary file to disk, closes it and renames it to the final
+// path, replacing any file there. The temporary file is removed if this fails
+func (f *File) Commit() error {
+	if err := f.File.Sync(); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write %s: %v", f.path, err)
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to write %s: %v", f.path, err)
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to write %s: %v", f.path, err)
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, leaving the final path untouched
+func (f *File) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// WriteFile atomically writes data to path, creating its directory if needed
+func WriteFile(path string, data []byte) error {
+	f, err := Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return f.Commit()
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policy decides what happens when an output path already exists
+type Policy int
+
+// Overwrite policies
+const (
+	Overwrite Policy = iota // Replace the existing file
+	Skip                    // Keep the existing file and do not write
+	Fail                    // Stop with an error
+	Version                 // Write to the first free path with a _1, _2, ... suffix
+)
+
+var policyNames = []string{"overwrite", "skip", "fail", "version"}
+
+// ParsePolicy parses an overwrite policy name
+func ParsePolicy(name string) (Policy, error) {
+	for i, n := range policyNames {
+		if strings.EqualFold(name, n) {
+			return Policy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overwrite policy %q (use %s)", name, strings.Join(policyNames, ", "))
+}
+
+// String returns the name of a policy
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// Resolve applies a policy to an output path, returning the path to write and
+// whether writing must be skipped because the path exists
+func Resolve(path string, policy Policy) (string, bool, error) {
+	if !exists(path) {
+		return path, false, nil
+	}
+	switch policy {
+	case Overwrite:
+		return path, false, nil
+	case Skip:
+		return path, true, nil
+	case Fail:
+		return "", false, fmt.Errorf("output %s already exists", path)
+	case Version:
+		ext := filepath.Ext(path)
+		base := strings.TrimSuffix(path, ext)
+		for v := 1; ; v++ {
+			versioned := fmt.Sprintf("%s_%d%s", base, v, ext)
+			if !exists(versioned) {
+				return versioned, false, nil
+			}
+		}
+	}
+	return "", false, fmt.Errorf("invalid overwrite policy %d", int(policy))
+}
+
+// exists reports whether a file or directory exists at path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
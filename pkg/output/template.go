@@ -0,0 +1,57 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Fields holds the values of the placeholders of a path template, by name
+type Fields map[string]string
+
+// Expand replaces the {name} placeholders of a path template with their fields
+// Unknown or unterminated placeholders are errors, so typos do not end up in paths
+func Expand(template string, fields Fields) (string, error) {
+	var b strings.Builder
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", template)
+		}
+		name := rest[open+1 : open+end]
+		value, ok := fields[name]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%s} in %q", name, template)
+		}
+		b.WriteString(rest[:open])
+		b.WriteString(value)
+		rest = rest[open+end+1:]
+	}
+}
+
+// Sentinel-2 tile identifiers, such as T30TVK, and acquisition dates, such as
+// 20230615T105629, in granule and product file names
+var (
+	tilePattern = regexp.MustCompile(`(?:^|_)(T\d{2}[A-Z]{3})(?:_|\.|$)`)
+	datePattern = regexp.MustCompile(`(?:^|_)(\d{8})T\d{6}(?:_|\.|$)`)
+)
+
+// SceneFields returns the tile identifier and acquisition date (YYYYMMDD) found in
+// the name of a Sentinel-2 band file, empty when missing
+func SceneFields(path string) (tile, date string) {
+	name := filepath.Base(path)
+	if m := tilePattern.FindStringSubmatch(name); m != nil {
+		tile = m[1]
+	}
+	if m := datePattern.FindStringSubmatch(name); m != nil {
+		date = m[1]
+	}
+	return tile, date
+}
@@ -0,0 +1,87 @@
+package rawio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/output"
+)
+
+// float32Bytes encodes values as little-endian float32 samples
+func float32Bytes(values []float32, numThreads int) []byte {
+	data := make([]byte, 4*len(values))
+
+	// Encode samples in parallel
+	numWorkers := numThreads
+	chunkSize := (len(values) + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, len(values))
+		end := min(start+chunkSize, len(values))
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(values[i]))
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return data
+}
+
+// float64Bytes encodes values as little-endian float64 samples
+func float64Bytes(values []float64, numThreads int) []byte {
+	data := make([]byte, 8*len(values))
+
+	// Encode samples in parallel
+	numWorkers := numThreads
+	chunkSize := (len(values) + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, len(values))
+		end := min(start+chunkSize, len(values))
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(values[i]))
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return data
+}
+
+// writeFile writes a file from a header followed by sections produced one at a time,
+// so that only one encoded section is held in memory
+func writeFile(path string, header []byte, sections int, section func(i int) []byte) error {
+	file, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	w.Write(header)
+	for i := 0; i < sections; i++ {
+		w.Write(section(i))
+	}
+	if err := w.Flush(); err != nil {
+		file.Abort()
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return file.Commit()
+}
@@ -0,0 +1,117 @@
+package rawio
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/output"
+)
+
+// ENVI data type codes
+const (
+	enviFloat32 = 4
+	enviFloat64 = 5
+)
+
+// ENVIHeader describes an ENVI raster beyond its size and data type
+type ENVIHeader struct {
+	Description string
+	BandNames   []string
+	Georef      *geo.Georeference // Written as map info when north-up UTM or geographic, nil for none
+}
+
+// ENVIHeaderPath returns the .hdr file that accompanies an ENVI data file
+func ENVIHeaderPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".hdr"
+}
+
+// WriteENVIFloat64 writes a single band of float64 values as an ENVI data file and its
+// .hdr header
+func WriteENVIFloat64(path string, values []float64, width, height int, h ENVIHeader, numThreads int) error {
+	if len(values) != width*height {
+		return fmt.Errorf("%d values do not match %dx%d", len(values), width, height)
+	}
+	if err := writeENVIHeader(path, width, height, 1, enviFloat64, h); err != nil {
+		return err
+	}
+	return writeFile(path, nil, 1, func(int) []byte { return float64Bytes(values, numThreads) })
+}
+
+// WriteENVIImage writes the components of a decoded image as float32 bands of an ENVI
+// data file in band-sequential order, and its .hdr header
+func WriteENVIImage(path string, img *jp2.JP2Image, h ENVIHeader, numThreads int) error {
+	for c, data := range img.Data {
+		if len(data) != img.Width*img.Height {
+			return fmt.Errorf("component %d has %d samples, want %d", c, len(data), img.Width*img.Height)
+		}
+	}
+	if err := writeENVIHeader(path, img.Width, img.Height, img.Components, enviFloat32, h); err != nil {
+		return err
+	}
+	return writeFile(path, nil, img.Components, func(c int) []byte { return float32Bytes(img.Data[c], numThreads) })
+}
+
+// writeENVIHeader writes the .hdr header of a little-endian band-sequential raster
+func writeENVIHeader(path string, width, height, bands, dataType int, h ENVIHeader) error {
+	if len(h.BandNames) > 0 && len(h.BandNames) != bands {
+		return fmt.Errorf("%d band names for %d bands", len(h.BandNames), bands)
+	}
+
+	var b strings.Builder
+	b.WriteString("ENVI\n")
+	if h.Description != "" {
+		fmt.Fprintf(&b, "description = {%s}\n", h.Description)
+	}
+	fmt.Fprintf(&b, "samples = %d\n", width)
+	fmt.Fprintf(&b, "lines = %d\n", height)
+	fmt.Fprintf(&b, "bands = %d\n", bands)
+	b.WriteString("header offset = 0\n")
+	b.WriteString("file type = ENVI Standard\n")
+	fmt.Fprintf(&b, "data type = %d\n", dataType)
+	b.WriteString("interleave = bsq\n")
+	b.WriteString("byte order = 0\n")
+	if len(h.BandNames) > 0 {
+		fmt.Fprintf(&b, "band names = {%s}\n", strings.Join(h.BandNames, ", "))
+	}
+	if h.Georef != nil {
+		if mapInfo, ok := enviMapInfo(h.Georef); ok {
+			fmt.Fprintf(&b, "map info = {%s}\n", mapInfo)
+		}
+	}
+
+	if err := output.WriteFile(ENVIHeaderPath(path), []byte(b.String())); err != nil {
+		return fmt.Errorf("error writing ENVI header: %v", err)
+	}
+	return nil
+}
+
+// enviMapInfo returns the map info of a north-up UTM or geographic georeference, tied
+// at the top-left corner of the top-left pixel (ENVI pixel 1, 1)
+// ETRS89 is labeled WGS-84, from which it differs by less than a meter
+func enviMapInfo(g *geo.Georeference) (string, bool) {
+	if !g.IsNorthUp() {
+		return "", false
+	}
+	pixelWidth, pixelHeight := g.PixelSize()
+	tie := fmt.Sprintf("1, 1, %.10g, %.10g, %.10g, %.10g", g.GeoTransform[0], g.GeoTransform[3], pixelWidth, math.Abs(pixelHeight))
+
+	if g.IsGeographic() {
+		return fmt.Sprintf("Geographic Lat/Lon, %s, WGS-84, units=Degrees", tie), true
+	}
+	zone, south, ok := geo.UTMZone(g.EPSG)
+	if !ok {
+		return "", false
+	}
+	hemisphere, datum := "North", "WGS-84"
+	if south {
+		hemisphere = "South"
+	}
+	if g.EPSG > 26900 && g.EPSG < 27000 {
+		datum = "North America 1983"
+	}
+	return fmt.Sprintf("UTM, %s, %d, %s, %s, units=Meters", tie, zone, hemisphere, datum), true
+}
@@ -0,0 +1,208 @@
+package rawio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/jp2"
+)
+
+// npyMagic starts every .npy file
+const npyMagic = "\x93NUMPY"
+
+// Fields of the .npy header dictionary
+var (
+	npyDescr        = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortranOrder = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape        = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// WriteNPYFloat64 writes values as a little-endian float64 .npy array of the given
+// shape, in C order
+func WriteNPYFloat64(path string, values []float64, shape []int, numThreads int) error {
+	header, err := npyHeader("<f8", shape, len(values))
+	if err != nil {
+		return err
+	}
+	return writeFile(path, header, 1, func(int) []byte { return float64Bytes(values, numThreads) })
+}
+
+// WriteNPYFloat32 writes values as a little-endian float32 .npy array of the given
+// shape, in C order
+func WriteNPYFloat32(path string, values []float32, shape []int, numThreads int) error {
+	header, err := npyHeader("<f4", shape, len(values))
+	if err != nil {
+		return err
+	}
+	return writeFile(path, header, 1, func(int) []byte { return float32Bytes(values, numThreads) })
+}
+
+// WriteNPYImage writes the components of a decoded image as a float32 .npy array of
+// shape (height, width), or (components, height, width) for several components
+func WriteNPYImage(path string, img *jp2.JP2Image, numThreads int) error {
+	shape := []int{img.Height, img.Width}
+	if img.Components > 1 {
+		shape = []int{img.Components, img.Height, img.Width}
+	}
+	header, err := npyHeader("<f4", shape, img.Components*img.Width*img.Height)
+	if err != nil {
+		return err
+	}
+	for c, data := range img.Data {
+		if len(data) != img.Width*img.Height {
+			return fmt.Errorf("component %d has %d samples, want %d", c, len(data), img.Width*img.Height)
+		}
+	}
+	return writeFile(path, header, img.Components, func(c int) []byte { return float32Bytes(img.Data[c], numThreads) })
+}
+
+// npyHeader returns the magic string, version and header dictionary of a version 1.0
+// .npy file, padded so that the data starts at a multiple of 64 bytes
+func npyHeader(descr string, shape []int, count int) ([]byte, error) {
+	dims := make([]string, len(shape))
+	size := 1
+	for i, d := range shape {
+		dims[i] = strconv.Itoa(d)
+		size *= d
+	}
+	if size != count {
+		return nil, fmt.Errorf("shape %v does not match %d values", shape, count)
+	}
+	shapeText := "(" + strings.Join(dims, ", ") + ")"
+	if len(shape) == 1 {
+		shapeText = "(" + dims[0] + ",)"
+	}
+
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': %s, }", descr, shapeText)
+	prefix := len(npyMagic) + 4
+	padding := (64 - (prefix+len(dict)+1)%64) % 64
+	dict += strings.Repeat(" ", padding) + "\n"
+	if len(dict) > math.MaxUint16 {
+		return nil, fmt.Errorf("npy header too long")
+	}
+
+	header := make([]byte, prefix, prefix+len(dict))
+	copy(header, npyMagic)
+	header[6], header[7] = 1, 0
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(dict)))
+	return append(header, dict...), nil
+}
+
+// ReadNPYFloat32 reads a C order .npy array of little-endian floats or integers and
+// returns its values converted to float32 and its shape
+func ReadNPYFloat32(path string, numThreads int) ([]float32, []int, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	descr, shape, data, err := parseNPY(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	count := 1
+	for _, d := range shape {
+		count *= d
+	}
+	var itemSize int
+	var sample func(b []byte) float32
+	switch descr {
+	case "<f4":
+		itemSize, sample = 4, func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+	case "<f8":
+		itemSize, sample = 8, func(b []byte) float32 { return float32(math.Float64frombits(binary.LittleEndian.Uint64(b))) }
+	case "|u1", "<u1":
+		itemSize, sample = 1, func(b []byte) float32 { return float32(b[0]) }
+	case "<u2":
+		itemSize, sample = 2, func(b []byte) float32 { return float32(binary.LittleEndian.Uint16(b)) }
+	case "<i2":
+		itemSize, sample = 2, func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) }
+	case "<i4":
+		itemSize, sample = 4, func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported npy dtype %q", path, descr)
+	}
+	if len(data) < count*itemSize {
+		return nil, nil, fmt.Errorf("%s: truncated npy data: %d bytes, want %d", path, len(data), count*itemSize)
+	}
+
+	values := make([]float32, count)
+
+	// Convert samples in parallel
+	numWorkers := numThreads
+	chunkSize := (count + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, count)
+		end := min(start+chunkSize, count)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				values[i] = sample(data[i*itemSize:])
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return values, shape, nil
+}
+
+// parseNPY splits a .npy file into its dtype, shape and data
+func parseNPY(file []byte) (string, []int, []byte, error) {
+	if len(file) < 10 || !bytes.HasPrefix(file, []byte(npyMagic)) {
+		return "", nil, nil, fmt.Errorf("not a npy file")
+	}
+
+	var headerLen, prefix int
+	switch file[6] {
+	case 1:
+		headerLen, prefix = int(binary.LittleEndian.Uint16(file[8:])), 10
+	case 2, 3:
+		if len(file) < 12 {
+			return "", nil, nil, fmt.Errorf("truncated npy header")
+		}
+		headerLen, prefix = int(binary.LittleEndian.Uint32(file[8:])), 12
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported npy version %d.%d", file[6], file[7])
+	}
+	if len(file) < prefix+headerLen {
+		return "", nil, nil, fmt.Errorf("truncated npy header")
+	}
+	dict := string(file[prefix : prefix+headerLen])
+
+	descr := npyDescr.FindStringSubmatch(dict)
+	fortranOrder := npyFortranOrder.FindStringSubmatch(dict)
+	shapeText := npyShape.FindStringSubmatch(dict)
+	if descr == nil || fortranOrder == nil || shapeText == nil {
+		return "", nil, nil, fmt.Errorf("invalid npy header %q", dict)
+	}
+	if fortranOrder[1] == "True" {
+		return "", nil, nil, fmt.Errorf("fortran order npy arrays are not supported")
+	}
+
+	var shape []int
+	for _, field := range strings.Split(shapeText[1], ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		d, err := strconv.Atoi(field)
+		if err != nil || d < 0 {
+			return "", nil, nil, fmt.Errorf("invalid npy shape %q", shapeText[1])
+		}
+		shape = append(shape, d)
+	}
+	return descr[1], shape, file[prefix+headerLen:], nil
+}
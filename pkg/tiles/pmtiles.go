@@ -0,0 +1,269 @@
+package tiles
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/output"
+)
+
+// PMTiles v3 header layout and codes
+const (
+	pmtilesHeaderSize  = 127
+	pmtilesMaxRootSize = 16384 - pmtilesHeaderSize // Header and root directory fit in the first 16 KiB
+	pmtilesLeafSize    = 4096                      // Initial number of entries per leaf directory
+
+	pmtilesCompressionGzip = 2
+	pmtilesCompressionNone = 1
+	pmtilesTypePNG         = 2
+)
+
+// pmtile is a tile received by a PMTilesSink
+type pmtile struct {
+	id   uint64
+	z    int
+	data []byte
+}
+
+// pmtilesEntry is a directory entry: a run of tiles with the same content, or a
+// leaf directory when RunLength is 0
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// PMTilesSink collects tiles and writes them as a single PMTiles v3 archive
+// Tiles with the same content are stored once, and runs of consecutive tiles with
+// the same content share one directory entry
+type PMTilesSink struct {
+	path  string
+	name  string
+	mu    sync.Mutex
+	tiles []pmtile
+}
+
+// NewPMTilesSink creates a sink that writes a PMTiles archive to path on Finish; name
+// is stored in the archive metadata
+func NewPMTilesSink(path, name string) *PMTilesSink {
+	return &PMTilesSink{path: path, name: name}
+}
+
+// WriteTile keeps a PNG tile for the archive
+func (s *PMTilesSink) WriteTile(z, x, y int, data []byte) error {
+	tile := pmtile{id: tileID(z, x, y), z: z, data: bytes.Clone(data)}
+	s.mu.Lock()
+	s.tiles = append(s.tiles, tile)
+	s.mu.Unlock()
+	return nil
+}
+
+// Finish writes the archive with the tiles received so far, bounds being the
+// longitude and latitude extent of the data, and returns the number of distinct
+// tile contents stored
+func (s *PMTilesSink) Finish(bounds Bounds) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tiles) == 0 {
+		return 0, fmt.Errorf("no tiles to write to %s", s.path)
+	}
+	sort.Slice(s.tiles, func(i, j int) bool { return s.tiles[i].id < s.tiles[j].id })
+
+	// Tile data in tile ID order, each distinct content once
+	var tileData bytes.Buffer
+	offsets := make(map[[sha256.Size]byte]uint64)
+	var entries []pmtilesEntry
+	minZoom, maxZoom := s.tiles[0].z, s.tiles[0].z
+	for _, tile := range s.tiles {
+		minZoom, maxZoom = min(minZoom, tile.z), max(maxZoom, tile.z)
+		hash := sha256.Sum256(tile.data)
+		offset, ok := offsets[hash]
+		if !ok {
+			offset = uint64(tileData.Len())
+			offsets[hash] = offset
+			tileData.Write(tile.data)
+		}
+
+		if n := len(entries); n > 0 {
+			last := &entries[n-1]
+			if last.Offset == offset && last.TileID+uint64(last.RunLength) == tile.id {
+				last.RunLength++
+				continue
+			}
+		}
+		entries = append(entries, pmtilesEntry{TileID: tile.id, Offset: offset, Length: uint32(len(tile.data)), RunLength: 1})
+	}
+
+	root, leaves, err := buildDirectories(entries)
+	if err != nil {
+		return 0, err
+	}
+	metadata, err := json.Marshal(map[string]any{
+		"name":   s.name,
+		"type":   "overlay",
+		"format": "png",
+		"bounds": fmt.Sprintf("%f,%f,%f,%f", bounds.West, bounds.South, bounds.East, bounds.North),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if metadata, err = gzipBytes(metadata); err != nil {
+		return 0, err
+	}
+
+	// Header, root directory, metadata, leaf directories and tile data
+	header := make([]byte, pmtilesHeaderSize)
+	copy(header, "PMTiles")
+	header[7] = 3
+	sections := [][]byte{root, metadata, leaves, tileData.Bytes()}
+	offset := uint64(pmtilesHeaderSize)
+	for i, section := range sections {
+		binary.LittleEndian.PutUint64(header[8+16*i:], offset)
+		binary.LittleEndian.PutUint64(header[16+16*i:], uint64(len(section)))
+		offset += uint64(len(section))
+	}
+	binary.LittleEndian.PutUint64(header[72:], uint64(len(s.tiles)))
+	binary.LittleEndian.PutUint64(header[80:], uint64(len(entries)))
+	binary.LittleEndian.PutUint64(header[88:], uint64(len(offsets)))
+	header[96] = 1 // Clustered: tile data follows tile ID order
+	header[97] = pmtilesCompressionGzip
+	header[98] = pmtilesCompressionNone
+	header[99] = pmtilesTypePNG
+	header[100] = uint8(minZoom)
+	header[101] = uint8(maxZoom)
+	putE7(header[102:], bounds.West)
+	putE7(header[106:], bounds.South)
+	putE7(header[110:], bounds.East)
+	putE7(header[114:], bounds.North)
+	header[118] = uint8(minZoom)
+	putE7(header[119:], (bounds.West+bounds.East)/2)
+	putE7(header[123:], (bounds.South+bounds.North)/2)
+
+	file, err := output.Create(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("error creating PMTiles archive: %v", err)
+	}
+	w := bufio.NewWriter(file)
+	w.Write(header)
+	for _, section := range sections {
+		w.Write(section)
+	}
+	if err := w.Flush(); err != nil {
+		file.Abort()
+		return 0, fmt.Errorf("error writing PMTiles archive: %v", err)
+	}
+	if err := file.Commit(); err != nil {
+		return 0, fmt.Errorf("error writing PMTiles archive: %v", err)
+	}
+	return len(offsets), nil
+}
+
+// buildDirectories returns the compressed root directory and leaf directories of
+// the entries, moving entries to leaves until the root fits after the header
+func buildDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+	root, err := encodeDirectory(entries)
+	if err != nil || len(root) <= pmtilesMaxRootSize {
+		return root, nil, err
+	}
+
+	for leafSize := pmtilesLeafSize; ; leafSize += leafSize / 5 {
+		var leaves bytes.Buffer
+		var rootEntries []pmtilesEntry
+		for start := 0; start < len(entries); start += leafSize {
+			leaf, err := encodeDirectory(entries[start:min(start+leafSize, len(entries))])
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmtilesEntry{
+				TileID: entries[start].TileID,
+				Offset: uint64(leaves.Len()),
+				Length: uint32(len(leaf)),
+			})
+			leaves.Write(leaf)
+		}
+		if root, err = encodeDirectory(rootEntries); err != nil {
+			return nil, nil, err
+		}
+		if len(root) <= pmtilesMaxRootSize {
+			return root, leaves.Bytes(), nil
+		}
+	}
+}
+
+// encodeDirectory serializes directory entries as columns of varints (delta coded
+// tile IDs, run lengths, lengths and offsets, 0 for an offset continuing the
+// previous entry) and compresses them
+func encodeDirectory(entries []pmtilesEntry) ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(entries)))
+	lastID := uint64(0)
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, e.TileID-lastID)
+		lastID = e.TileID
+	}
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, uint64(e.Length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			buf = binary.AppendUvarint(buf, 0)
+		} else {
+			buf = binary.AppendUvarint(buf, e.Offset+1)
+		}
+	}
+	return gzipBytes(buf)
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// putE7 stores a coordinate in degrees as a little-endian int32 of 1e-7 degrees
+func putE7(b []byte, degrees float64) {
+	binary.LittleEndian.PutUint32(b, uint32(int32(math.Round(degrees*1e7))))
+}
+
+// tileID returns the PMTiles ID of a tile: the tiles of lower zooms followed by
+// the position of the tile along the Hilbert curve of its zoom
+func tileID(z, x, y int) uint64 {
+	id := (uint64(1)<<(2*z) - 1) / 3
+	for s := 1 << z >> 1; s > 0; s >>= 1 {
+		rx, ry := 0, 0
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		id += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// Rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x, s-1-y
+			}
+			x, y = y, x
+		}
+	}
+	return id
+}
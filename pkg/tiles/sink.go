@@ -0,0 +1,30 @@
+package tiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DirSink writes tiles as z/x/y.png files under a directory
+type DirSink struct {
+	dir string
+}
+
+// NewDirSink creates a sink writing under dir, creating it if needed
+func NewDirSink(dir string) (*DirSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating tile directory: %v", err)
+	}
+	return &DirSink{dir: dir}, nil
+}
+
+// WriteTile writes a tile to dir/z/x/y.png
+func (s *DirSink) WriteTile(z, x, y int, data []byte) error {
+	tileDir := filepath.Join(s.dir, strconv.Itoa(z), strconv.Itoa(x))
+	if err := os.MkdirAll(tileDir, 0755); err != nil {
+		return fmt.Errorf("error creating tile directory: %v", err)
+	}
+	return os.WriteFile(filepath.Join(tileDir, strconv.Itoa(y)+".png"), data, 0644)
+}
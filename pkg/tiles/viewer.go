@@ -0,0 +1,48 @@
+package tiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// viewerHTML is a Leaflet page showing the tiles next to it over OpenStreetMap
+const viewerHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>html, body, #map { height: 100%%; margin: 0; }</style>
+</head>
+<body>
+<div id="map"></div>
+<script>
+var bounds = [[%f, %f], [%f, %f]];
+var map = L.map('map');
+L.tileLayer('https://tile.openstreetmap.org/{z}/{x}/{y}.png', {
+  maxZoom: 19,
+  attribution: '&copy; OpenStreetMap contributors'
+}).addTo(map);
+L.tileLayer('{z}/{x}/{y}.png', {
+  minZoom: %d,
+  maxNativeZoom: %d,
+  maxZoom: 19,
+  bounds: bounds
+}).addTo(map);
+map.fitBounds(bounds);
+</script>
+</body>
+</html>
+`
+
+// WriteViewer writes an index.html in dir that shows the z/x/y tiles of the
+// directory over a base map, centered on bounds
+func WriteViewer(dir, title string, bounds Bounds, opts Options) error {
+	html := fmt.Sprintf(viewerHTML, title, bounds.South, bounds.West, bounds.North, bounds.East, opts.MinZoom, opts.MaxZoom)
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(html), 0644); err != nil {
+		return fmt.Errorf("error writing tile viewer: %v", err)
+	}
+	return nil
+}
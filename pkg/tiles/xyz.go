@@ -0,0 +1,313 @@
+package tiles
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+	"github.com/luismi/jp2_processing/pkg/ndvi"
+)
+
+// worldExtent is half the width of the Web Mercator world in meters
+const worldExtent = 20037508.342789244
+
+// gridStep is the spacing in tile pixels of the exactly reprojected points; the
+// source coordinates of the pixels in between are interpolated bilinearly
+const gridStep = 16
+
+// Options configures a tile pyramid
+type Options struct {
+	MinZoom, MaxZoom int
+	TileSize         int // Tile width and height in pixels, a multiple of 16
+}
+
+// DefaultOptions returns 256 pixel tiles; the zoom range must be set, see NativeZoom
+func DefaultOptions() Options {
+	return Options{TileSize: 256}
+}
+
+// Sink receives the encoded tiles of a pyramid; WriteTile is called concurrently
+type Sink interface {
+	WriteTile(z, x, y int, data []byte) error
+}
+
+// Bounds is a longitude and latitude bounding box in degrees
+type Bounds struct {
+	West, South, East, North float64
+}
+
+// level is a source image and its georeference
+type level struct {
+	img    *image.RGBA
+	georef *geo.Georeference
+}
+
+// tileJob identifies a tile to render
+type tileJob struct {
+	z, x, y int
+}
+
+// Render reprojects a georeferenced map to Web Mercator and renders the PNG tiles of
+// its zoom range into sink, with pixels outside the map or without data transparent
+// Pixels whose valid flag is false are masked out; a nil valid slice keeps every pixel
+// Tiles are rendered by a pool of numThreads workers, from overviews of the map
+// halved until their pixels are not smaller than the tile pixels; empty tiles are skipped
+func Render(img *image.RGBA, valid []bool, georef *geo.Georeference, opts Options, sink Sink, numThreads int) (*metrics.TileMetrics, error) {
+	if opts.TileSize < gridStep || opts.TileSize%gridStep != 0 {
+		return nil, fmt.Errorf("tile size must be a positive multiple of %d, got %d", gridStep, opts.TileSize)
+	}
+	if opts.MinZoom < 0 || opts.MaxZoom < opts.MinZoom || opts.MaxZoom > 24 {
+		return nil, fmt.Errorf("invalid zoom range %d-%d", opts.MinZoom, opts.MaxZoom)
+	}
+	proj, err := geo.ProjectionFor(georef.EPSG)
+	if err != nil {
+		return nil, err
+	}
+
+	// Full resolution level with no-data made transparent
+	levels := []level{{img: maskNoData(img, valid, numThreads), georef: georef}}
+
+	bounds := LonLatBounds(proj, georef, img.Bounds().Dx(), img.Bounds().Dy())
+	mercator, _ := geo.ProjectionFor(geo.EPSGWebMercator)
+	minX, minY := mercator.Forward(bounds.West, bounds.South)
+	maxX, maxY := mercator.Forward(bounds.East, bounds.North)
+	groundScale := math.Cos((bounds.South + bounds.North) / 2 * math.Pi / 180)
+
+	// Tiles of every zoom, highest first so overviews are built in order
+	var jobs []tileJob
+	zoomLevel := make(map[int]int)
+	for z := opts.MaxZoom; z >= opts.MinZoom; z-- {
+		tileSpan := 2 * worldExtent / math.Exp2(float64(z))
+		x0, x1 := tileIndex(minX+worldExtent, tileSpan, z), tileIndex(maxX+worldExtent, tileSpan, z)
+		y0, y1 := tileIndex(worldExtent-maxY, tileSpan, z), tileIndex(worldExtent-minY, tileSpan, z)
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				jobs = append(jobs, tileJob{z, x, y})
+			}
+		}
+
+		// Halve the source while its pixels stay smaller than the tile pixels on the ground
+		pixelGround := tileSpan / float64(opts.TileSize) * groundScale
+		for {
+			last := levels[len(levels)-1]
+			size := last.img.Bounds().Size()
+			if 2*groundPixelSize(last.georef) > pixelGround || size.X < 2 || size.Y < 2 {
+				break
+			}
+			half := ndvi.Quicklook(last.img, (max(size.X, size.Y)+1)/2, numThreads)
+			halfSize := half.Bounds().Size()
+			scaled := *last.georef
+			for _, i := range []int{1, 4} {
+				scaled.GeoTransform[i] *= float64(size.X) / float64(halfSize.X)
+			}
+			for _, i := range []int{2, 5} {
+				scaled.GeoTransform[i] *= float64(size.Y) / float64(halfSize.Y)
+			}
+			levels = append(levels, level{img: half, georef: &scaled})
+		}
+		zoomLevel[z] = len(levels) - 1
+	}
+
+	tileMetrics := &metrics.TileMetrics{MinZoom: opts.MinZoom, MaxZoom: opts.MaxZoom}
+
+	// Worker pool over tiles
+	jobCh := make(chan tileJob)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	wg.Add(numThreads)
+
+	for w := 0; w < numThreads; w++ {
+		go func() {
+			defer wg.Done()
+			tile := image.NewRGBA(image.Rect(0, 0, opts.TileSize, opts.TileSize))
+			var buf bytes.Buffer
+			for job := range jobCh {
+				src := levels[zoomLevel[job.z]]
+				empty, err := renderTile(tile, src, proj, job, opts.TileSize)
+				if err == nil && !empty {
+					buf.Reset()
+					if err = png.Encode(&buf, tile); err == nil {
+						err = sink.WriteTile(job.z, job.x, job.y, buf.Bytes())
+					}
+				}
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					if firstErr == nil {
+						firstErr = fmt.Errorf("tile %d/%d/%d: %v", job.z, job.x, job.y, err)
+					}
+				case empty:
+					tileMetrics.EmptyTiles++
+				default:
+					tileMetrics.Tiles++
+					tileMetrics.Size += int64(buf.Len())
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return tileMetrics, nil
+}
+
+// renderTile fills tile with the source pixels nearest to its pixel centers and
+// reports whether every pixel is transparent
+func renderTile(tile *image.RGBA, src level, proj geo.Projection, job tileJob, tileSize int) (bool, error) {
+	mercator, _ := geo.ProjectionFor(geo.EPSGWebMercator)
+	res := 2 * worldExtent / math.Exp2(float64(job.z)) / float64(tileSize)
+	originX := -worldExtent + float64(job.x*tileSize)*res
+	originY := worldExtent - float64(job.y*tileSize)*res
+
+	// Source pixel coordinates at the grid points
+	n := tileSize/gridStep + 1
+	cols := make([]float64, n*n)
+	rows := make([]float64, n*n)
+	for gy := 0; gy < n; gy++ {
+		for gx := 0; gx < n; gx++ {
+			lon, lat := mercator.Inverse(originX+float64(gx*gridStep)*res, originY-float64(gy*gridStep)*res)
+			x, y := proj.Forward(lon, lat)
+			col, row, err := src.georef.WorldToPixel(x, y)
+			if err != nil {
+				return false, err
+			}
+			cols[gy*n+gx], rows[gy*n+gx] = col, row
+		}
+	}
+
+	srcImg := src.img
+	width, height := srcImg.Bounds().Dx(), srcImg.Bounds().Dy()
+	empty := true
+	for ty := 0; ty < tileSize; ty++ {
+		gy, fy := (ty*2+1)/(2*gridStep), (float64(ty)+0.5)/gridStep-float64((ty*2+1)/(2*gridStep))
+		for tx := 0; tx < tileSize; tx++ {
+			gx, fx := (tx*2+1)/(2*gridStep), (float64(tx)+0.5)/gridStep-float64((tx*2+1)/(2*gridStep))
+			i := gy*n + gx
+			col := bilinear(cols[i], cols[i+1], cols[i+n], cols[i+n+1], fx, fy)
+			row := bilinear(rows[i], rows[i+1], rows[i+n], rows[i+n+1], fx, fy)
+
+			dst := tile.Pix[ty*tile.Stride+tx*4 : ty*tile.Stride+tx*4+4]
+			c, r := int(math.Floor(col)), int(math.Floor(row))
+			if c < 0 || r < 0 || c >= width || r >= height {
+				dst[0], dst[1], dst[2], dst[3] = 0, 0, 0, 0
+				continue
+			}
+			copy(dst, srcImg.Pix[r*srcImg.Stride+c*4:r*srcImg.Stride+c*4+4])
+			if dst[3] != 0 {
+				empty = false
+			}
+		}
+	}
+	return empty, nil
+}
+
+// bilinear interpolates between the four corners of a grid cell
+func bilinear(v00, v10, v01, v11, fx, fy float64) float64 {
+	top := v00 + (v10-v00)*fx
+	bottom := v01 + (v11-v01)*fx
+	return top + (bottom-top)*fy
+}
+
+// tileIndex returns the tile containing a distance from the left or top world edge
+func tileIndex(distance, tileSpan float64, z int) int {
+	return max(0, min(1<<z-1, int(math.Floor(distance/tileSpan))))
+}
+
+// groundPixelSize returns the pixel width of a georeference in meters
+func groundPixelSize(georef *geo.Georeference) float64 {
+	width, _ := georef.PixelSize()
+	size := math.Hypot(width, georef.GeoTransform[4])
+	if georef.IsGeographic() {
+		size *= math.Pi / 180 * 6378137
+	}
+	return size
+}
+
+// LonLatBounds returns the longitude and latitude bounding box of a width x height
+// grid, following its edges to account for the curvature of projected grids
+func LonLatBounds(proj geo.Projection, georef *geo.Georeference, width, height int) Bounds {
+	b := Bounds{West: math.Inf(1), South: math.Inf(1), East: math.Inf(-1), North: math.Inf(-1)}
+	const samples = 64
+	for i := 0; i <= samples; i++ {
+		f := float64(i) / samples
+		for _, p := range [4][2]float64{
+			{f * float64(width), 0},
+			{f * float64(width), float64(height)},
+			{0, f * float64(height)},
+			{float64(width), f * float64(height)},
+		} {
+			lon, lat := proj.Inverse(georef.PixelToWorld(p[0], p[1]))
+			b.West, b.East = math.Min(b.West, lon), math.Max(b.East, lon)
+			b.South, b.North = math.Min(b.South, lat), math.Max(b.North, lat)
+		}
+	}
+	return b
+}
+
+// NativeZoom returns the lowest zoom whose tile pixels are not larger on the ground
+// than the pixels of the georeferenced grid
+func NativeZoom(georef *geo.Georeference, width, height, tileSize int) (int, error) {
+	proj, err := geo.ProjectionFor(georef.EPSG)
+	if err != nil {
+		return 0, err
+	}
+	bounds := LonLatBounds(proj, georef, width, height)
+	groundScale := math.Cos((bounds.South + bounds.North) / 2 * math.Pi / 180)
+	worldPixels := 2 * worldExtent * groundScale / groundPixelSize(georef)
+	return max(0, min(24, int(math.Ceil(math.Log2(worldPixels/float64(tileSize)))))), nil
+}
+
+// maskNoData returns a copy of img with the pixels whose valid flag is false made
+// transparent, or img itself when valid is nil
+func maskNoData(img *image.RGBA, valid []bool, numThreads int) *image.RGBA {
+	if valid == nil {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	masked := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Process rows in parallel
+	numWorkers := numThreads
+	chunkSize := (height + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := w * chunkSize
+		end := min(start+chunkSize, height)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				src := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+				dst := masked.Pix[y*masked.Stride:]
+				for x := 0; x < width; x++ {
+					if valid[y*width+x] {
+						copy(dst[x*4:x*4+4], src[x*4:x*4+4])
+					}
+				}
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	return masked
+}
@@ -0,0 +1,91 @@
+package vector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/luismi/jp2_processing/pkg/output"
+)
+
+// WriteGeoJSON writes polygons as a GeoJSON feature collection with one feature per
+// line, each with its class index and label, mean NDVI, pixel count and area
+// labels holds the label of each class index; a nonzero EPSG code is written as a
+// named CRS member, which GDAL and QGIS read for coordinates other than WGS84
+// Returns the size of the file in bytes
+func WriteGeoJSON(path, name string, polygons []Polygon, labels []string, epsg int) (int64, error) {
+	file, err := output.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(file)
+	nameJSON, _ := json.Marshal(name)
+	fmt.Fprintf(w, "{\n\"type\": \"FeatureCollection\",\n\"name\": %s,\n", nameJSON)
+	if epsg != 0 {
+		fmt.Fprintf(w, "\"crs\": {\"type\": \"name\", \"properties\": {\"name\": \"urn:ogc:def:crs:EPSG::%d\"}},\n", epsg)
+	}
+	w.WriteString("\"features\": [\n")
+
+	var buf []byte
+	for i, polygon := range polygons {
+		label := ""
+		if class := polygon.Class - 1; class >= 0 && class < len(labels) {
+			label = labels[class]
+		}
+		labelJSON, _ := json.Marshal(label)
+
+		buf = append(buf[:0], `{"type": "Feature", "properties": {"id": `...)
+		buf = strconv.AppendInt(buf, int64(i+1), 10)
+		buf = append(buf, `, "class": `...)
+		buf = strconv.AppendInt(buf, int64(polygon.Class-1), 10)
+		buf = append(buf, `, "label": `...)
+		buf = append(buf, labelJSON...)
+		buf = append(buf, `, "mean_ndvi": `...)
+		buf = strconv.AppendFloat(buf, polygon.Mean, 'f', 4, 64)
+		buf = append(buf, `, "pixels": `...)
+		buf = strconv.AppendInt(buf, int64(polygon.Pixels), 10)
+		buf = append(buf, `, "area": `...)
+		buf = strconv.AppendFloat(buf, polygon.Area, 'f', -1, 64)
+		buf = append(buf, `}, "geometry": {"type": "Polygon", "coordinates": [`...)
+		for r, ring := range polygon.Rings {
+			if r > 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = append(buf, '[')
+			for p, point := range ring {
+				if p > 0 {
+					buf = append(buf, ", "...)
+				}
+				buf = append(buf, '[')
+				buf = strconv.AppendFloat(buf, point.X, 'f', -1, 64)
+				buf = append(buf, ", "...)
+				buf = strconv.AppendFloat(buf, point.Y, 'f', -1, 64)
+				buf = append(buf, ']')
+			}
+			buf = append(buf, ']')
+		}
+		buf = append(buf, "]}}"...)
+		if i < len(polygons)-1 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '\n')
+		w.Write(buf)
+	}
+	w.WriteString("]\n}\n")
+
+	if err := w.Flush(); err != nil {
+		file.Abort()
+		return 0, fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := file.Commit(); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
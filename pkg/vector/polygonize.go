@@ -0,0 +1,421 @@
+package vector
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Point is a vertex in map coordinates
+type Point struct {
+	X, Y float64
+}
+
+// Polygon is a 4-connected region of pixels with the same class
+type Polygon struct {
+	Class  int       // Class raster value: class index plus one
+	Pixels int       // Number of pixels of the region
+	Area   float64   // Area of the region in square map units
+	Mean   float64   // Mean of the values of the region
+	Rings  [][]Point // Closed outer ring, counterclockwise, followed by clockwise holes
+}
+
+// Options configures polygonisation
+type Options struct {
+	Tolerance float64 // Douglas-Peucker tolerance in map units, 0 to keep every pixel corner
+	MinArea   float64 // Minimum area in square map units of polygons and holes
+}
+
+// Directions of travel along pixel edges, in image coordinates with y down
+const (
+	east = iota
+	south
+	west
+	north
+)
+
+var (
+	dirX = [4]int{1, 0, -1, 0}
+	dirY = [4]int{0, 1, 0, -1}
+)
+
+// Polygonize traces the 4-connected regions of a class raster (class index plus one,
+// 0 for no data) into polygons with holes in the map coordinates of georef
+// Rings follow pixel edges, so adjacent polygons share their boundaries exactly, and
+// simplification keeps them shared: each boundary between two junctions is simplified
+// the same way in both polygons. Regions and holes smaller than the minimum area are
+// dropped, the latter filled by the surrounding polygon.
+// values gives the mean of each region, typically the NDVI of each pixel
+func Polygonize(classImg *image.Gray, values []float64, georef *geo.Georeference, opts Options, numThreads int) ([]Polygon, *metrics.VectorMetrics, error) {
+	width, height := classImg.Bounds().Dx(), classImg.Bounds().Dy()
+	if classImg.Stride != width {
+		return nil, nil, fmt.Errorf("class raster is not contiguous")
+	}
+	if len(values) != width*height {
+		return nil, nil, fmt.Errorf("got %d values for a %dx%d class raster", len(values), width, height)
+	}
+	if georef == nil {
+		return nil, nil, fmt.Errorf("polygonisation needs a georeference")
+	}
+	if opts.Tolerance < 0 || opts.MinArea < 0 {
+		return nil, nil, fmt.Errorf("invalid polygonisation tolerance %g or minimum area %g", opts.Tolerance, opts.MinArea)
+	}
+
+	labels, numRegions := labelRegions(classImg.Pix, width, height, numThreads)
+
+	// Gather the class, size and value sum of each region
+	classes := make([]uint8, numRegions)
+	pixels := make([]int, numRegions)
+	sums := make([]float64, numRegions)
+	for i, label := range labels {
+		if label < 0 {
+			continue
+		}
+		classes[label] = classImg.Pix[i]
+		pixels[label]++
+		sums[label] += values[i]
+	}
+
+	gt := georef.GeoTransform
+	pixelArea := math.Abs(gt[1]*gt[5] - gt[2]*gt[4])
+
+	// Trace the rings of the regions kept, starting each at the top edge of a pixel
+	// The first ring found for a region starts at its top left pixel, so it is the outer one
+	index := make([]int, numRegions)
+	var polygons []Polygon
+	for label := range index {
+		index[label] = -1
+		if float64(pixels[label])*pixelArea >= opts.MinArea {
+			index[label] = len(polygons)
+			polygons = append(polygons, Polygon{
+				Class:  int(classes[label]),
+				Pixels: pixels[label],
+				Area:   float64(pixels[label]) * pixelArea,
+				Mean:   sums[label] / float64(pixels[label]),
+			})
+		}
+	}
+	visited := make([]bool, width*height)
+	rings := make([][]corner, len(polygons))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			label := labels[i]
+			if label < 0 || index[label] < 0 || visited[i] || (y > 0 && labels[i-width] == label) {
+				continue
+			}
+			p := index[label]
+			polygons[p].Rings = append(polygons[p].Rings, nil)
+			rings[p] = append(rings[p], traceRing(labels, visited, width, height, x, y)...)
+			rings[p] = append(rings[p], corner{x: -1})
+		}
+	}
+
+	// Transform, filter and simplify the rings of each polygon in parallel
+	numPolygons := len(polygons)
+	numWorkers := numThreads
+	chunkSize := (numPolygons + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, numPolygons)
+		end := min(start+chunkSize, numPolygons)
+
+		go func(start, end int) {
+			defer wg.Done()
+			for p := start; p < end; p++ {
+				polygons[p].Rings = buildRings(rings[p], georef, opts)
+				rings[p] = nil
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	vectorMetrics := &metrics.VectorMetrics{Regions: numRegions, Polygons: numPolygons}
+	for _, polygon := range polygons {
+		vectorMetrics.Holes += len(polygon.Rings) - 1
+		for _, ring := range polygon.Rings {
+			vectorMetrics.Vertices += len(ring) - 1
+		}
+	}
+	return polygons, vectorMetrics, nil
+}
+
+// corner is a pixel corner of a traced ring
+type corner struct {
+	x, y     int
+	junction bool // Three or more regions, or two diagonal ones, meet at the corner
+}
+
+// buildRings converts the traced rings of a polygon, separated by a corner with a
+// negative x, to map coordinates, drops small holes, simplifies the rings and orients
+// and closes them
+func buildRings(traced []corner, georef *geo.Georeference, opts Options) [][]Point {
+	var rings [][]Point
+	var ring []Point
+	var corners []corner
+	for _, c := range traced {
+		if c.x >= 0 {
+			x, y := georef.PixelToWorld(float64(c.x), float64(c.y))
+			ring = append(ring, Point{x, y})
+			corners = append(corners, c)
+			continue
+		}
+
+		area := signedArea(ring)
+		outer := len(rings) == 0
+		if !outer && math.Abs(area) < opts.MinArea {
+			ring, corners = nil, nil
+			continue
+		}
+		if opts.Tolerance > 0 {
+			ring = simplifyShared(ring, corners, opts.Tolerance)
+			area = signedArea(ring)
+		}
+		// Outer rings are counterclockwise and holes clockwise, as in RFC 7946
+		if (area < 0) == outer {
+			for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+				ring[i], ring[j] = ring[j], ring[i]
+			}
+		}
+		rings = append(rings, append(ring, ring[0]))
+		ring, corners = nil, nil
+	}
+	return rings
+}
+
+// signedArea returns the shoelace area of an open ring, positive when counterclockwise
+func signedArea(ring []Point) float64 {
+	area := 0.0
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i].X*ring[j].Y - ring[j].X*ring[i].Y
+	}
+	return area / 2
+}
+
+// traceRing follows the pixel edges of a ring with its region on the right, starting
+// eastward along the top edge of pixel (x, y), and returns the pixel corners where
+// it turns or meets other boundaries. Top edges walked are marked as visited
+// At a corner touching two diagonal pixels of the region the ring turns right, so
+// regions are 4-connected
+func traceRing(labels []int32, visited []bool, width, height, x, y int) []corner {
+	label := labels[y*width+x]
+	at := func(px, py int) int32 {
+		if px < 0 || py < 0 || px >= width || py >= height {
+			return -1
+		}
+		return labels[py*width+px]
+	}
+	inside := func(px, py int) bool {
+		return at(px, py) == label
+	}
+
+	var corners []corner
+	vx, vy, dir := x, y, east
+	for {
+		if dir == east {
+			visited[vy*width+vx] = true
+		}
+		vx += dirX[dir]
+		vy += dirY[dir]
+
+		// Pixels to the right and left of the edge ahead
+		var right, left bool
+		switch dir {
+		case east:
+			right, left = inside(vx, vy), inside(vx, vy-1)
+		case south:
+			right, left = inside(vx-1, vy), inside(vx, vy)
+		case west:
+			right, left = inside(vx-1, vy-1), inside(vx-1, vy)
+		case north:
+			right, left = inside(vx, vy-1), inside(vx-1, vy-1)
+		}
+
+		next := dir
+		switch {
+		case right && left:
+			next = (dir + 3) % 4
+		case !right:
+			next = (dir + 1) % 4
+		}
+		junction := isJunction(at(vx-1, vy-1), at(vx, vy-1), at(vx-1, vy), at(vx, vy))
+		if next != dir || junction {
+			corners = append(corners, corner{vx, vy, junction})
+		}
+		if vx == x && vy == y && next == east {
+			return corners
+		}
+		dir = next
+	}
+}
+
+// isJunction reports whether the boundaries between the four pixels around a corner,
+// given by their labels, meet there: three or four regions touch the corner, or two
+// touch it diagonally
+func isJunction(topLeft, topRight, bottomLeft, bottomRight int32) bool {
+	if topLeft == bottomRight && topRight == bottomLeft {
+		return topLeft != topRight
+	}
+	distinct := 1
+	if topRight != topLeft {
+		distinct++
+	}
+	if bottomLeft != topLeft && bottomLeft != topRight {
+		distinct++
+	}
+	if bottomRight != topLeft && bottomRight != topRight && bottomRight != bottomLeft {
+		distinct++
+	}
+	return distinct >= 3
+}
+
+// labelRegions labels the 4-connected regions of equal nonzero classes from 0, and
+// pixels of class 0 with -1. Returns the labels and the number of regions
+// Row bands are labeled in parallel and joined across their borders afterwards
+func labelRegions(classes []uint8, width, height, numThreads int) ([]int32, int) {
+	labels := make([]int32, width*height)
+
+	// Label row bands in parallel
+	numWorkers := numThreads
+	chunkSize := (height + numWorkers - 1) / numWorkers
+	counts := make([]int, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, height)
+		end := min(start+chunkSize, height)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			var parent []int32
+			for y := start; y < end; y++ {
+				for x := 0; x < width; x++ {
+					i := y*width + x
+					class := classes[i]
+					if class == 0 {
+						labels[i] = -1
+						continue
+					}
+					leftSame := x > 0 && classes[i-1] == class
+					upSame := y > start && classes[i-width] == class
+					switch {
+					case leftSame && upSame:
+						labels[i] = labels[i-1]
+						union(parent, labels[i-1], labels[i-width])
+					case leftSame:
+						labels[i] = labels[i-1]
+					case upSame:
+						labels[i] = labels[i-width]
+					default:
+						labels[i] = int32(len(parent))
+						parent = append(parent, int32(len(parent)))
+					}
+				}
+			}
+
+			compact, count := compactRoots(parent)
+			for i := start * width; i < end*width; i++ {
+				if labels[i] >= 0 {
+					labels[i] = compact[labels[i]]
+				}
+			}
+			counts[worker] = count
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	// Offset the band labels and join regions across band borders
+	offsets := make([]int32, numWorkers)
+	total := 0
+	for w := 0; w < numWorkers; w++ {
+		offsets[w] = int32(total)
+		total += counts[w]
+	}
+	parent := make([]int32, total)
+	for i := range parent {
+		parent[i] = int32(i)
+	}
+	bandLabel := func(i, worker int) int32 { return labels[i] + offsets[worker] }
+	for w := 1; w < numWorkers; w++ {
+		y := w * chunkSize
+		if y >= height {
+			break
+		}
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if classes[i] != 0 && classes[i-width] == classes[i] {
+				union(parent, bandLabel(i, w), bandLabel(i-width, w-1))
+			}
+		}
+	}
+	compact, numRegions := compactRoots(parent)
+
+	// Relabel pixels in parallel
+	wg.Add(numWorkers)
+
+	for w := 0; w < numWorkers; w++ {
+		start := min(w*chunkSize, height)
+		end := min(start+chunkSize, height)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			for i := start * width; i < end*width; i++ {
+				if labels[i] >= 0 {
+					labels[i] = compact[bandLabel(i, worker)]
+				}
+			}
+		}(w, start, end)
+	}
+
+	wg.Wait()
+
+	return labels, numRegions
+}
+
+// find returns the root of a label, halving the path to it
+func find(parent []int32, label int32) int32 {
+	for parent[label] != label {
+		parent[label] = parent[parent[label]]
+		label = parent[label]
+	}
+	return label
+}
+
+// union joins the sets of two labels under the smaller root
+func union(parent []int32, a, b int32) {
+	ra, rb := find(parent, a), find(parent, b)
+	if ra < rb {
+		parent[rb] = ra
+	} else if rb < ra {
+		parent[ra] = rb
+	}
+}
+
+// compactRoots numbers the sets of a union-find forest from 0 in order of their
+// smallest label, returning the number of each label's set and the number of sets
+func compactRoots(parent []int32) ([]int32, int) {
+	compact := make([]int32, len(parent))
+	count := 0
+	for label := range parent {
+		root := find(parent, int32(label))
+		if root == int32(label) {
+			compact[label] = int32(count)
+			count++
+		} else {
+			compact[label] = compact[root]
+		}
+	}
+	return compact, count
+}
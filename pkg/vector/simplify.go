@@ -0,0 +1,178 @@
+package vector
+
+import "math"
+
+// Simplify reduces the vertices of an open ring with the Douglas-Peucker algorithm,
+// keeping the vertices farther than tolerance from the simplified outline
+// The ring is split at its first vertex and the vertex farthest from it, and both
+// halves are simplified as polylines
+func Simplify(ring []Point, tolerance float64) []Point {
+	n := len(ring)
+	if n < 4 {
+		return ring
+	}
+
+	far, farDist := 0, -1.0
+	for i := 1; i < n; i++ {
+		if d := math.Hypot(ring[i].X-ring[0].X, ring[i].Y-ring[0].Y); d > farDist {
+			far, farDist = i, d
+		}
+	}
+
+	keep := make([]bool, n+1)
+	keep[0], keep[far], keep[n] = true, true, true
+	closed := append(ring[:n:n], ring[0])
+	simplifyRange(closed, 0, far, tolerance, keep)
+	simplifyRange(closed, far, n, tolerance, keep)
+
+	simplified := make([]Point, 0, n)
+	for i := 0; i < n; i++ {
+		if keep[i] {
+			simplified = append(simplified, ring[i])
+		}
+	}
+	return simplified
+}
+
+// simplifyRange marks the vertices of points[first:last+1] kept by Douglas-Peucker,
+// using a stack instead of recursion so long rings cannot exhaust the stack
+func simplifyRange(points []Point, first, last int, tolerance float64, keep []bool) {
+	stack := [][2]int{{first, last}}
+	for len(stack) > 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		index, maxDist := -1, tolerance
+		for i := r[0] + 1; i < r[1]; i++ {
+			if d := segmentDistance(points[i], points[r[0]], points[r[1]]); d > maxDist {
+				index, maxDist = i, d
+			}
+		}
+		if index >= 0 {
+			keep[index] = true
+			stack = append(stack, [2]int{r[0], index}, [2]int{index, r[1]})
+		}
+	}
+}
+
+// segmentDistance returns the distance from p to the segment from a to b
+func segmentDistance(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	lengthSq := dx*dx + dy*dy
+	if lengthSq == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lengthSq
+	t = max(0, min(1, t))
+	return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+}
+
+// simplifyShared simplifies a traced ring so the boundary it shares with each neighbour
+// keeps the same vertices in the neighbour's ring. The ring is split into arcs at its
+// junctions, and each arc is simplified on its own from the end with the lowest pixel
+// corner, so both rings along it simplify the same vertices in the same order; a ring
+// without junctions is a single arc from its lowest corner
+// Arcs keep at least one of their inner vertices, so rings do not collapse
+func simplifyShared(ring []Point, corners []corner, tolerance float64) []Point {
+	n := len(ring)
+	var starts []int
+	for i, c := range corners {
+		if c.junction {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		lowest := 0
+		for i := 1; i < n; i++ {
+			if cornerLess(corners[i], corners[lowest]) {
+				lowest = i
+			}
+		}
+		starts = []int{lowest}
+	}
+
+	keep := make([]bool, n)
+	for k, start := range starts {
+		edges := (starts[(k+1)%len(starts)] - start + n) % n
+		if edges == 0 {
+			edges = n // A single junction starts and ends a loop around the ring
+		}
+		arc := make([]int, edges+1)
+		for i := range arc {
+			arc[i] = (start + i) % n
+		}
+
+		// Orient the arc from its lowest end, or its lowest second vertex for loops
+		first, last := corners[arc[0]], corners[arc[edges]]
+		if cornerLess(last, first) || (first == last && cornerLess(corners[arc[edges-1]], corners[arc[1]])) {
+			for i, j := 0, edges; i < j; i, j = i+1, j-1 {
+				arc[i], arc[j] = arc[j], arc[i]
+			}
+		}
+
+		points := make([]Point, len(arc))
+		for i, index := range arc {
+			points[i] = ring[index]
+		}
+		for i, kept := range simplifyArc(points, tolerance) {
+			if kept {
+				keep[arc[i]] = true
+			}
+		}
+	}
+
+	simplified := make([]Point, 0, n)
+	for i, p := range ring {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// simplifyArc marks the vertices of an arc kept by Douglas-Peucker, including its ends
+// An arc that closes on itself is split at its vertex farthest from its ends
+func simplifyArc(points []Point, tolerance float64) []bool {
+	last := len(points) - 1
+	keep := make([]bool, len(points))
+	keep[0], keep[last] = true, true
+	if points[0] != points[last] {
+		simplifyPart(points, 0, last, tolerance, keep)
+		return keep
+	}
+
+	far, farDist := 0, -1.0
+	for i := 1; i < last; i++ {
+		if d := math.Hypot(points[i].X-points[0].X, points[i].Y-points[0].Y); d > farDist {
+			far, farDist = i, d
+		}
+	}
+	keep[far] = true
+	simplifyPart(points, 0, far, tolerance, keep)
+	simplifyPart(points, far, last, tolerance, keep)
+	return keep
+}
+
+// simplifyPart marks the vertices of points[first:last+1] kept by Douglas-Peucker, and
+// the one farthest from the segment between the ends when no inner vertex is kept
+func simplifyPart(points []Point, first, last int, tolerance float64, keep []bool) {
+	if last-first < 2 {
+		return
+	}
+	simplifyRange(points, first, last, tolerance, keep)
+	far, farDist := -1, -1.0
+	for i := first + 1; i < last; i++ {
+		if keep[i] {
+			return
+		}
+		if d := segmentDistance(points[i], points[first], points[last]); d > farDist {
+			far, farDist = i, d
+		}
+	}
+	keep[far] = true
+}
+
+// cornerLess orders pixel corners by row and then column
+func cornerLess(a, b corner) bool {
+	return a.y < b.y || (a.y == b.y && a.x < b.x)
+}
@@ -0,0 +1,400 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/luismi/jp2_processing/pkg/geo"
+	"github.com/luismi/jp2_processing/pkg/jp2"
+	"github.com/luismi/jp2_processing/pkg/metrics"
+)
+
+// Options configures the arrays of a Zarr store
+type Options struct {
+	ChunkSize int    // Chunk width and height in pixels
+	Level     int    // zlib compression level, 1 (fastest) to 9 (smallest)
+	Name      string // Name of the NDVI array in the store
+}
+
+// DefaultOptions returns 512x512 chunks compressed at zlib level 6 in an "ndvi" array
+func DefaultOptions() Options {
+	return Options{ChunkSize: 512, Level: 6, Name: "ndvi"}
+}
+
+// Writer saves rasters as arrays of a Zarr v2 store on local disk, with xarray
+// dimension names, x and y coordinate arrays and the georeference as attributes
+// It implements jp2.QuantizedWriter so it can replace the JPEG2000 product writer
+type Writer struct {
+	opts   Options
+	georef *geo.Georeference
+}
+
+// array describes a 2D array to write: its Zarr dtype, sample size, fill value and
+// a function encoding the little-endian samples of a row range into dst
+type array struct {
+	name      string
+	width     int
+	height    int
+	dtype     string
+	itemSize  int
+	fill      []byte // Encoded fill value
+	fillValue any    // Fill value as written in .zarray
+	attrs     map[string]any
+	encode    func(dst []byte, offset, count int)
+}
+
+// zarrayMeta is the content of a .zarray file
+type zarrayMeta struct {
+	ZarrFormat         int            `json:"zarr_format"`
+	Shape              []int          `json:"shape"`
+	Chunks             []int          `json:"chunks"`
+	DType              string         `json:"dtype"`
+	Compressor         map[string]any `json:"compressor"`
+	FillValue          any            `json:"fill_value"`
+	Order              string         `json:"order"`
+	Filters            []any          `json:"filters"`
+	DimensionSeparator string         `json:"dimension_separator"`
+}
+
+// NewWriter creates a Zarr writer
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.ChunkSize <= 0 {
+		return nil, fmt.Errorf("Zarr chunk size must be positive, got %d", opts.ChunkSize)
+	}
+	if opts.Level < 1 || opts.Level > 9 {
+		return nil, fmt.Errorf("Zarr zlib level must be between 1 and 9, got %d", opts.Level)
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("Zarr array name must not be empty")
+	}
+	return &Writer{opts: opts}, nil
+}
+
+// SetGeoreference sets the georeferencing written as attributes and coordinate
+// arrays, nil for none
+func (w *Writer) SetGeoreference(georef *geo.Georeference) {
+	w.georef = georef
+}
+
+// WriteQuantized saves int16 samples as the NDVI array of the store at outputPath,
+// with the no-data value as fill value and the scale and offset as CF attributes
+// Returns the time and size of each stage of the write and any error that occurred
+func (w *Writer) WriteQuantized(samples []int16, width, height int, q jp2.Quantization, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if len(samples) != width*height {
+		return nil, fmt.Errorf("got %d samples for a %dx%d image", len(samples), width, height)
+	}
+	a := array{
+		name:      w.opts.Name,
+		width:     width,
+		height:    height,
+		dtype:     "<i2",
+		itemSize:  2,
+		fill:      binary.LittleEndian.AppendUint16(nil, uint16(q.NoData)),
+		fillValue: q.NoData,
+		attrs: map[string]any{
+			"long_name":    "NDVI",
+			"scale_factor": q.Scale,
+			"add_offset":   q.Offset,
+			"units":        q.Units,
+		},
+		encode: func(dst []byte, offset, count int) {
+			for i, s := range samples[offset : offset+count] {
+				binary.LittleEndian.PutUint16(dst[2*i:], uint16(s))
+			}
+		},
+	}
+	return w.writeArray(a, outputPath, startSave, threads)
+}
+
+// WriteFloat32 saves float32 values as the NDVI array of the store at outputPath,
+// with NaN as fill value
+// Returns the time and size of each stage of the write and any error that occurred
+func (w *Writer) WriteFloat32(values []float32, width, height int, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if len(values) != width*height {
+		return nil, fmt.Errorf("got %d values for a %dx%d image", len(values), width, height)
+	}
+	return w.writeArray(float32Array(w.opts.Name, values, width, height, "NDVI"), outputPath, startSave, threads)
+}
+
+// WriteBand saves the first component of a decoded band as a float32 array called
+// name in the store at outputPath
+// Returns the time and size of each stage of the write and any error that occurred
+func (w *Writer) WriteBand(img *jp2.JP2Image, name, outputPath string, threads int) (*metrics.WriteMetrics, error) {
+	startSave := time.Now()
+
+	if img.Components < 1 || len(img.Data[0]) != img.Width*img.Height {
+		return nil, fmt.Errorf("band %s has no %dx%d component", name, img.Width, img.Height)
+	}
+	return w.writeArray(float32Array(name, img.Data[0], img.Width, img.Height, name), outputPath, startSave, threads)
+}
+
+// float32Array describes float32 values with NaN as fill value
+func float32Array(name string, values []float32, width, height int, longName string) array {
+	nan := float32(math.NaN())
+	return array{
+		name:      name,
+		width:     width,
+		height:    height,
+		dtype:     "<f4",
+		itemSize:  4,
+		fill:      binary.LittleEndian.AppendUint32(nil, math.Float32bits(nan)),
+		fillValue: "NaN",
+		attrs:     map[string]any{"long_name": longName},
+		encode: func(dst []byte, offset, count int) {
+			for i, v := range values[offset : offset+count] {
+				binary.LittleEndian.PutUint32(dst[4*i:], math.Float32bits(v))
+			}
+		},
+	}
+}
+
+// writeArray writes an array, its metadata and the group metadata of the store
+// Chunks are written in parallel; chunks holding only the fill value are omitted,
+// as Zarr readers fill missing chunks with it. Chunks are compressed and written
+// together, reported as encode time, and the metadata as file time
+func (w *Writer) writeArray(a array, storePath string, startSave time.Time, threads int) (*metrics.WriteMetrics, error) {
+	writeMetrics := &metrics.WriteMetrics{}
+	startFile := time.Now()
+
+	arrayPath := filepath.Join(storePath, a.name)
+	if err := os.RemoveAll(arrayPath); err != nil {
+		return nil, fmt.Errorf("error removing previous array %s: %v", arrayPath, err)
+	}
+	if err := os.MkdirAll(arrayPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating Zarr store: %v", err)
+	}
+	if err := w.writeGroup(storePath, a.width, a.height); err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]any{"_ARRAY_DIMENSIONS": []string{"y", "x"}}
+	for k, v := range a.attrs {
+		attrs[k] = v
+	}
+	w.addGeoAttrs(attrs)
+	if err := w.writeMetadata(arrayPath, a.width, a.height, a.dtype, a.fillValue, attrs); err != nil {
+		return nil, err
+	}
+	writeMetrics.FileTime = time.Since(startFile)
+	startEncode := time.Now()
+
+	// Process chunks in parallel
+	size := w.opts.ChunkSize
+	chunksX := (a.width + size - 1) / size
+	chunksY := (a.height + size - 1) / size
+	numChunks := chunksX * chunksY
+	numWorkers := threads
+	chunkSize := (numChunks + numWorkers - 1) / numWorkers
+	errs := make([]error, numWorkers)
+	written := make([]int64, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+
+	for worker := 0; worker < numWorkers; worker++ {
+		start := min(worker*chunkSize, numChunks)
+		end := min(start+chunkSize, numChunks)
+
+		go func(worker, start, end int) {
+			defer wg.Done()
+			buf := make([]byte, size*size*a.itemSize)
+			for c := start; c < end; c++ {
+				cx, cy := c%chunksX, c/chunksX
+				n, err := w.writeChunk(a, arrayPath, cx, cy, buf)
+				if err != nil {
+					errs[worker] = err
+					return
+				}
+				written[worker] += int64(n)
+			}
+		}(worker, start, end)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	writeMetrics.EncodeTime = time.Since(startEncode)
+
+	for _, n := range written {
+		writeMetrics.Bytes += n
+	}
+	writeMetrics.CompressionRatio = jp2.CompressionRatio(int64(a.width*a.height*a.itemSize), writeMetrics.Bytes)
+	writeMetrics.TotalTime = time.Since(startSave)
+	return writeMetrics, nil
+}
+
+// writeChunk encodes, compresses and writes chunk (cx, cy), padding the parts outside
+// the array with the fill value. Returns the bytes written
+func (w *Writer) writeChunk(a array, arrayPath string, cx, cy int, buf []byte) (int, error) {
+	size := w.opts.ChunkSize
+	x0, y0 := cx*size, cy*size
+	cols := min(size, a.width-x0)
+	rows := min(size, a.height-y0)
+
+	for i := 0; i < len(buf); i += a.itemSize {
+		copy(buf[i:], a.fill)
+	}
+	for y := 0; y < rows; y++ {
+		a.encode(buf[y*size*a.itemSize:], (y0+y)*a.width+x0, cols)
+	}
+	if onlyFill(buf, a.fill) {
+		return 0, nil
+	}
+
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, w.opts.Level)
+	if err != nil {
+		return 0, err
+	}
+	zw.Write(buf)
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("error compressing Zarr chunk: %v", err)
+	}
+
+	chunkPath := filepath.Join(arrayPath, strconv.Itoa(cy)+"."+strconv.Itoa(cx))
+	if err := os.WriteFile(chunkPath, compressed.Bytes(), 0644); err != nil {
+		return 0, fmt.Errorf("error writing Zarr chunk: %v", err)
+	}
+	return compressed.Len(), nil
+}
+
+// onlyFill reports whether every sample of buf equals the fill value
+func onlyFill(buf, fill []byte) bool {
+	for i := 0; i < len(buf); i += len(fill) {
+		if !bytes.Equal(buf[i:i+len(fill)], fill) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeGroup writes the group metadata of the store and, when georeferenced and
+// north-up, the x and y coordinate arrays of the pixel centers
+func (w *Writer) writeGroup(storePath string, width, height int) error {
+	if err := writeJSON(filepath.Join(storePath, ".zgroup"), map[string]any{"zarr_format": 2}); err != nil {
+		return err
+	}
+	groupAttrs := map[string]any{}
+	w.addGeoAttrs(groupAttrs)
+	if err := writeJSON(filepath.Join(storePath, ".zattrs"), groupAttrs); err != nil {
+		return err
+	}
+	if w.georef == nil || !w.georef.IsNorthUp() {
+		return nil
+	}
+
+	units, xName, yName := "m", "projection_x_coordinate", "projection_y_coordinate"
+	if w.georef.IsGeographic() {
+		units, xName, yName = "degrees", "longitude", "latitude"
+	}
+	coords := []struct {
+		name, standardName string
+		count              int
+		origin, step       float64
+	}{
+		{"x", xName, width, w.georef.GeoTransform[0], w.georef.GeoTransform[1]},
+		{"y", yName, height, w.georef.GeoTransform[3], w.georef.GeoTransform[5]},
+	}
+	for _, c := range coords {
+		values := make([]byte, 8*c.count)
+		for i := 0; i < c.count; i++ {
+			binary.LittleEndian.PutUint64(values[8*i:], math.Float64bits(c.origin+(float64(i)+0.5)*c.step))
+		}
+
+		coordPath := filepath.Join(storePath, c.name)
+		if err := os.MkdirAll(coordPath, 0755); err != nil {
+			return fmt.Errorf("error creating Zarr store: %v", err)
+		}
+		meta := zarrayMeta{
+			ZarrFormat:         2,
+			Shape:              []int{c.count},
+			Chunks:             []int{c.count},
+			DType:              "<f8",
+			Compressor:         map[string]any{"id": "zlib", "level": w.opts.Level},
+			FillValue:          "NaN",
+			Order:              "C",
+			DimensionSeparator: ".",
+		}
+		if err := writeJSON(filepath.Join(coordPath, ".zarray"), meta); err != nil {
+			return err
+		}
+		attrs := map[string]any{"_ARRAY_DIMENSIONS": []string{c.name}, "standard_name": c.standardName, "units": units}
+		if err := writeJSON(filepath.Join(coordPath, ".zattrs"), attrs); err != nil {
+			return err
+		}
+
+		var compressed bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&compressed, w.opts.Level)
+		zw.Write(values)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing Zarr chunk: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(coordPath, "0"), compressed.Bytes(), 0644); err != nil {
+			return fmt.Errorf("error writing Zarr chunk: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeMetadata writes the .zarray and .zattrs files of a 2D array
+func (w *Writer) writeMetadata(arrayPath string, width, height int, dtype string, fillValue any, attrs map[string]any) error {
+	meta := zarrayMeta{
+		ZarrFormat:         2,
+		Shape:              []int{height, width},
+		Chunks:             []int{w.opts.ChunkSize, w.opts.ChunkSize},
+		DType:              dtype,
+		Compressor:         map[string]any{"id": "zlib", "level": w.opts.Level},
+		FillValue:          fillValue,
+		Order:              "C",
+		DimensionSeparator: ".",
+	}
+	if err := writeJSON(filepath.Join(arrayPath, ".zarray"), meta); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(arrayPath, ".zattrs"), attrs)
+}
+
+// addGeoAttrs adds the georeference to attributes: the CRS as an EPSG URL as read by
+// GDAL, and the GDAL geotransform
+func (w *Writer) addGeoAttrs(attrs map[string]any) {
+	if w.georef == nil {
+		return
+	}
+	if w.georef.EPSG != 0 {
+		attrs["_CRS"] = map[string]any{"url": fmt.Sprintf("http://www.opengis.net/def/crs/EPSG/0/%d", w.georef.EPSG)}
+		attrs["epsg"] = w.georef.EPSG
+	}
+	attrs["geotransform"] = w.georef.GeoTransform
+}
+
+// writeJSON writes a value as indented JSON, keeping the "<" of dtypes unescaped
+func writeJSON(path string, v any) error {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}